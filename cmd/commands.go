@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+
+	"github.com/GosMachine/ServiceAuth/internal/app"
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/logger"
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"github.com/GosMachine/ServiceAuth/internal/password"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+)
+
+// command is a maintenance task run as "<binary> <name> [flags]" instead of
+// starting the service.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help": {
+			usage: "list the commands",
+			run:   listCommands,
+		},
+		"breach-filter": {
+			usage: "build the breached password filter from a Pwned Passwords SHA-1 dump",
+			run:   buildBreachFilter,
+		},
+		"reconcile-balances": {
+			usage: "check that every cached balance matches the sum of its ledger",
+			run:   reconcileBalances,
+		},
+		"migrate-balances": {
+			usage: "move the float users.balance of every user into the ledger",
+			run:   migrateBalances,
+		},
+		"export-user": {
+			usage: "write the data kept about a user to a zip, for access requests",
+			run:   exportUser,
+		},
+	}
+}
+
+func listCommands([]string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("Without a command the service starts. Commands:")
+	for _, name := range names {
+		fmt.Printf("  %-16s %s\n", name, commands[name].usage)
+	}
+	return nil
+}
+
+// buildBreachFilter reads the "HASH:COUNT" dump twice, once to size the
+// filter and once to fill it, so it needs a file rather than a pipe.
+func buildBreachFilter(args []string) error {
+	flags := flag.NewFlagSet("breach-filter", flag.ContinueOnError)
+	in := flags.String("in", "", "Pwned Passwords SHA-1 dump, one HASH:COUNT per line")
+	out := flags.String("out", "breached.filter", "filter file to write")
+	rate := flags.Float64("fp-rate", 0.001, "false positive rate")
+	minCount := flags.Int("min-count", 1, "skip hashes seen fewer times than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return errors.New("-in is required")
+	}
+	if *rate <= 0 || *rate >= 1 {
+		return errors.New("-fp-rate must be between 0 and 1")
+	}
+
+	var n uint64
+	err := scanHashes(*in, *minCount, func([20]byte) { n++ })
+	if err != nil {
+		return err
+	}
+	filter := password.NewFilter(n, *rate)
+	if err := scanHashes(*in, *minCount, filter.Add); err != nil {
+		return err
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if _, err := filter.WriteTo(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s with %d hashes\n", *out, filter.Len())
+	return nil
+}
+
+func scanHashes(path string, minCount int, fn func([20]byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		sum, count, ok := password.ParseHashLine(scanner.Text())
+		if !ok {
+			if scanner.Text() == "" {
+				continue
+			}
+			return fmt.Errorf("%s:%d: not a HASH:COUNT line", path, line)
+		}
+		if count >= minCount {
+			fn(sum)
+		}
+	}
+	return scanner.Err()
+}
+
+// exportUser writes the data export of a user, see auth.ExportUser. It runs
+// against the configured storage, so it has to run where the service does.
+// The audit log names the operating system user as the actor.
+func exportUser(args []string) error {
+	flags := flag.NewFlagSet("export-user", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_PATH"), "path to config file")
+	email := flags.String("email", "", "email of the user")
+	out := flags.String("out", "", "zip file to write, user-data.zip by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	normalized, err := mailaddr.Normalize(*email)
+	if err != nil {
+		return errors.New("-email must be a valid email")
+	}
+	if *out == "" {
+		*out = "user-data.zip"
+	}
+
+	cfg := config.MustLoadPath(*configPath)
+	log, _, err := logger.New(cfg.Log)
+	if err != nil {
+		return err
+	}
+	defer log.Sync()
+	svc, stop := app.NewService(log, cfg)
+	defer stop()
+
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor += ":" + u.Username
+	}
+	archive, err := svc.ExportUser(auth.WithActor(context.Background(), actor), normalized)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, archive, 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s (%d bytes)\n", *out, len(archive))
+	return nil
+}
+
+// reconcileBalances lists the balances that differ from their ledger and
+// fails when there are any, so it can run from cron.
+func reconcileBalances(args []string) error {
+	flags := flag.NewFlagSet("reconcile-balances", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_PATH"), "path to config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := config.MustLoadPath(*configPath)
+	log, _, err := logger.New(cfg.Log)
+	if err != nil {
+		return err
+	}
+	defer log.Sync()
+	svc, stop := app.NewService(log, cfg)
+	defer stop()
+
+	mismatches, err := svc.ReconcileBalances(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, m := range mismatches {
+		fmt.Printf("user %d %s: cached %d, ledger %d\n", m.UserID, m.Currency, m.Cached, m.Ledger)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d balances do not match the ledger", len(mismatches))
+	}
+	fmt.Println("all balances match the ledger")
+	return nil
+}
+
+// migrateBalances gives every user their old users.balance as an opening
+// ledger entry. Running it again only migrates the users it missed.
+func migrateBalances(args []string) error {
+	flags := flag.NewFlagSet("migrate-balances", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_PATH"), "path to config file")
+	currency := flags.String("currency", "", "ISO 4217 code the old balances are in")
+	scale := flags.Int("scale", 2, "decimal places of the currency's minor unit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *currency == "" {
+		return errors.New("-currency is required")
+	}
+
+	cfg := config.MustLoadPath(*configPath)
+	log, _, err := logger.New(cfg.Log)
+	if err != nil {
+		return err
+	}
+	defer log.Sync()
+	svc, stop := app.NewService(log, cfg)
+	defer stop()
+
+	migrated, err := svc.MigrateLegacyBalances(context.Background(), *currency, *scale)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrated %d balances\n", migrated)
+	return nil
+}
@@ -1,49 +1,65 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/GosMachine/ServiceAuth/internal/app"
 	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/logger"
 	_ "github.com/joho/godotenv/autoload"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	cfg := config.MustLoad()
 
-	log := setupLogger()
-	log.Info("starting application", zap.Any("config", cfg))
+	log, level, err := logger.New(cfg.Log)
+	if err != nil {
+		panic("failed to build logger: " + err.Error())
+	}
+	defer log.Sync()
+	log.Info("starting application", zap.Any("config", cfg.Redacted()))
 
-	application := app.New(log, cfg.TokenTtl, cfg.RememberMeTokenTTL)
+	application := app.New(log, cfg)
 
 	go application.GRPCSrv.MustRun()
+	go reloadLogLevel(log, cfg, level)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
 	sign := <-stop
 	log.Info("stopping application", zap.String("signal", sign.String()))
-	application.GRPCSrv.Stop()
+	application.Stop()
 	log.Info("application stopped")
 }
 
-func setupLogger() *zap.Logger {
-	cfg := zap.Config{
-		Encoding:          "json",
-		DisableStacktrace: true,
-		Level:             zap.NewAtomicLevelAt(zapcore.InfoLevel),
-		OutputPaths:       []string{"stdout"},
-		EncoderConfig:     zap.NewProductionEncoderConfig(),
-	}
-	cfg.EncoderConfig.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-		enc.AppendString(t.Format("2006-01-02 15:04:05"))
+// reloadLogLevel re-reads log.level from the config file on every SIGHUP.
+func reloadLogLevel(log *zap.Logger, cfg *config.Config, level zap.AtomicLevel) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		text, err := cfg.ReloadLogLevel()
+		if err == nil {
+			err = level.UnmarshalText([]byte(text))
+		}
+		if err != nil {
+			log.Error("failed to reload log level", zap.Error(err))
+			continue
+		}
+		log.Info("log level changed", zap.String("level", level.String()))
 	}
-	logger, _ := cfg.Build()
-	defer logger.Sync()
-	return logger
 }
@@ -1,29 +1,272 @@
 package app
 
 import (
+	"context"
+	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	grpcapp "github.com/GosMachine/ServiceAuth/internal/app/grpc"
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/geoip"
+	grpcauth "github.com/GosMachine/ServiceAuth/internal/grpc/auth"
+	"github.com/GosMachine/ServiceAuth/internal/logger"
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"github.com/GosMachine/ServiceAuth/internal/metrics"
+	"github.com/GosMachine/ServiceAuth/internal/notifier"
+	"github.com/GosMachine/ServiceAuth/internal/password"
 	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
 	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
 	"github.com/GosMachine/ServiceAuth/internal/storage/redis"
+	"github.com/GosMachine/ServiceAuth/internal/tracing"
+	"github.com/GosMachine/ServiceAuth/internal/webhooks"
+	"github.com/redis/go-redis/extra/redisotel/v9"
+	goredis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
+const tracingShutdownTimeout = 5 * time.Second
+
 type App struct {
 	GRPCSrv *grpcapp.App
+
+	log             *zap.Logger
+	ctx             context.Context
+	cancel          context.CancelFunc
+	workers         sync.WaitGroup
+	shutdownTracing func(context.Context) error
+}
+
+func New(log *zap.Logger, cfg *config.Config) *App {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &App{log: log, ctx: ctx, cancel: cancel}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, cfg.Env)
+	if err != nil {
+		panic(err)
+	}
+	a.shutdownTracing = shutdownTracing
+
+	var (
+		m            *metrics.Metrics
+		interceptors = []grpc.UnaryServerInterceptor{
+			logger.UnaryServerInterceptor(log),
+			grpcauth.UnaryServerInterceptor(),
+			grpcauth.ServiceTokenInterceptor(log, cfg.GRPC.ServiceTokens),
+		}
+	)
+	if cfg.Metrics.Addr != "" {
+		m = metrics.New()
+		interceptors = append(interceptors, m.UnaryServerInterceptor())
+		a.goWorker(func(ctx context.Context) {
+			m.Serve(ctx, log, cfg.Metrics.Addr)
+		})
+	}
+
+	st := a.newStorage(cfg, m)
+	authService := a.newAuthService(cfg, st, m)
+	if err := authService.SeedRoles(ctx, cfg.RBAC); err != nil {
+		panic(err)
+	}
+	a.goWorker(func(ctx context.Context) {
+		authService.PruneAuditLog(ctx, cfg.Audit.Retention, cfg.Audit.PruneInterval)
+	})
+	a.goWorker(func(ctx context.Context) {
+		authService.PurgeDeletedAccounts(ctx, cfg.Deletion.PurgeInterval)
+	})
+	sink := events.Fanout(a.newEventSink(cfg.Events, st.redisClient), webhooks.NewDispatcher(st.db))
+	relay := events.NewRelay(st.db, sink, log, cfg.Events)
+	a.goWorker(relay.Run)
+	a.goWorker(webhooks.NewWorker(st.db, log, cfg.Webhooks).Run)
+	a.goWorker(a.newNotificationQueue(cfg.Notifier, st.db).Run)
+	a.GRPCSrv = grpcapp.New(log, authService, authService, os.Getenv("AUTH_SERVICE_ADDR"),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(interceptors...),
+	)
+	return a
+}
+
+// NewService builds the auth service on the configured storage without
+// serving it, for commands that run once. stop ends the workers it needs.
+func NewService(log *zap.Logger, cfg *config.Config) (svc *auth.Auth, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &App{log: log, ctx: ctx, cancel: cancel}
+	svc = a.newAuthService(cfg, a.newStorage(cfg, nil), nil)
+	return svc, func() {
+		a.cancel()
+		a.workers.Wait()
+	}
+}
+
+func (a *App) newAuthService(cfg *config.Config, st storageSet, m *metrics.Metrics) *auth.Auth {
+	return auth.New(a.log, st.db, st.cache, st.sessions, cfg.TokenTtl, cfg.RememberMeTokenTTL,
+		auth.WithMetrics(m),
+		auth.WithInsecureWebhooks(cfg.Webhooks.AllowHTTP),
+		auth.WithNotifications(cfg.Notifier.DefaultLocale),
+		auth.WithDeviceTracking(cfg.Devices),
+		auth.WithGeoIP(a.openGeoIP(cfg.GeoIP), cfg.GeoIP),
+		auth.WithPasswordPolicy(a.newPasswordPolicy(cfg.Password)),
+		auth.WithPasswordRotation(cfg.Password),
+		auth.WithEmailValidator(a.newEmailValidator(cfg.Email)),
+		auth.WithAccessRefresh(cfg.RBAC.RefreshInterval),
+		auth.WithAccountDeletion(cfg.Deletion),
+		auth.WithDataExport(cfg.Export),
+	)
+}
+
+// Stop shuts the gRPC server down and waits for background workers.
+func (a *App) Stop() {
+	a.GRPCSrv.Stop()
+	a.cancel()
+	a.workers.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := a.shutdownTracing(ctx); err != nil {
+		a.log.Error("failed to flush traces", zap.Error(err))
+	}
+}
+
+// goWorker runs fn in the background until the App is stopped.
+func (a *App) goWorker(fn func(ctx context.Context)) {
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		fn(a.ctx)
+	}()
+}
+
+// storageSet is what newStorage wires up from the config.
+type storageSet struct {
+	db       database.Database
+	cache    redis.Service
+	sessions storage.SessionStore
+	// redisClient is nil when Redis is not configured.
+	redisClient goredis.UniversalClient
+}
+
+func (a *App) newStorage(cfg *config.Config, m *metrics.Metrics) storageSet {
+	if cfg.Storage == "memory" {
+		a.log.Warn("using in-memory storage, all data is lost on restart")
+		db := memory.NewDatabase(nil)
+		return storageSet{db: db, cache: memory.NewRedis(db, a.log, nil), sessions: memory.NewSessions(nil)}
+	}
+	plugins := []database.Plugin{tracing.GormPlugin}
+	if m != nil {
+		plugins = append(plugins, m.GormPlugin)
+	}
+	db, err := database.New(a.log, cfg.Database, plugins...)
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		st            = storageSet{db: db, cache: redis.NewPassthrough(db)}
+		redisSessions *redis.Sessions
+	)
+	if cfg.NeedsRedis() {
+		client, err := redis.Connect(cfg.Redis)
+		if err != nil {
+			panic(err)
+		}
+		if err := redisotel.InstrumentTracing(client, redisotel.WithDBStatement(false)); err != nil {
+			panic(err)
+		}
+		if m != nil {
+			client.AddHook(m.RedisHook())
+		}
+		st.cache = redis.New(client, cfg.Redis.KeyPrefix, db, a.log)
+		st.redisClient = client
+		redisSessions = redis.NewSessions(client, cfg.Redis.KeyPrefix, a.log)
+	}
+
+	if cfg.Session.Store == "redis" {
+		st.sessions = redisSessions
+		return st
+	}
+	pgSessions, err := database.NewSessions(db, a.log)
+	if err != nil {
+		panic(err)
+	}
+	a.goWorker(func(ctx context.Context) {
+		pgSessions.Reap(ctx, cfg.Session.ReapInterval)
+	})
+	st.sessions = pgSessions
+	if cfg.Session.RedisCache {
+		st.sessions = storage.NewCachedSessionStore(pgSessions, redisSessions, a.log)
+	}
+	return st
+}
+
+func (a *App) newEventSink(cfg config.EventsConfig, client goredis.UniversalClient) events.Sink {
+	switch cfg.Sink {
+	case "redis":
+		return events.NewRedisStreamSink(client, cfg.RedisStream, cfg.RedisStreamLen)
+	case "webhook":
+		return events.NewWebhookSink(cfg.WebhookURL, &http.Client{Timeout: cfg.WebhookTimeout})
+	}
+	return events.NewLogSink(a.log)
+}
+
+// openGeoIP loads the GeoIP databases and keeps them current. It returns
+// nil, which locates nothing, when none are configured.
+func (a *App) openGeoIP(cfg config.GeoIPConfig) *geoip.DB {
+	if cfg.CityDB == "" && cfg.ASNDB == "" {
+		return nil
+	}
+	db, err := geoip.Open(a.log, cfg)
+	if err != nil {
+		panic(err)
+	}
+	a.goWorker(func(ctx context.Context) {
+		db.Watch(ctx, cfg.ReloadInterval)
+		db.Close()
+	})
+	return db
+}
+
+func (a *App) newPasswordPolicy(cfg config.PasswordConfig) *password.Policy {
+	breached, err := password.OpenBreached(cfg.Breached)
+	if err != nil {
+		panic(err)
+	}
+	return password.NewPolicy(cfg, breached)
+}
+
+func (a *App) newEmailValidator(cfg config.EmailConfig) *mailaddr.Validator {
+	v, err := mailaddr.NewValidator(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return v
 }
 
-func New(log *zap.Logger, tokenTTL, RememberMeTokenTTL time.Duration) *App {
-	db, err := database.New()
+func (a *App) newNotificationQueue(cfg config.NotifierConfig, db database.Database) *notifier.Queue {
+	templates, err := notifier.LoadTemplates(cfg.DefaultLocale)
 	if err != nil {
 		panic(err)
 	}
-	redis := redis.New(db, log)
-	authService := auth.New(log, db, redis, tokenTTL, RememberMeTokenTTL)
-	grpcApp := grpcapp.New(log, authService, os.Getenv("AUTH_SERVICE_ADDR"))
-	return &App{
-		GRPCSrv: grpcApp,
+	var sender notifier.Notifier
+	switch cfg.Driver {
+	case "smtp":
+		smtp := notifier.NewSMTP(cfg.From, cfg.SMTP)
+		a.goWorker(func(ctx context.Context) {
+			<-ctx.Done()
+			smtp.Close()
+		})
+		sender = smtp
+	case "file":
+		if sender, err = notifier.NewFileNotifier(cfg.Dir, cfg.From); err != nil {
+			panic(err)
+		}
+	default:
+		sender = notifier.NewLogNotifier(a.log)
 	}
+	return notifier.NewQueue(db, sender, templates, a.log, cfg)
 }
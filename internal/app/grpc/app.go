@@ -1,6 +1,7 @@
 package grpcapp
 
 import (
+	grpcadmin "github.com/GosMachine/ServiceAuth/internal/grpc/admin"
 	grpcauth "github.com/GosMachine/ServiceAuth/internal/grpc/auth"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
@@ -13,9 +14,9 @@ type App struct {
 	addr       string
 }
 
-func New(log *zap.Logger, authService grpcauth.Auth, addr string) *App {
-	gRPCServer := grpc.NewServer()
-	grpcauth.RegisterAuthServer(gRPCServer, authService)
+func New(log *zap.Logger, authService grpcauth.Auth, adminService grpcadmin.Admin, addr string, opts ...grpc.ServerOption) *App {
+	gRPCServer := grpc.NewServer(opts...)
+	registerServices(gRPCServer, authService, adminService)
 	return &App{
 		log:        log,
 		gRPCServer: gRPCServer,
@@ -0,0 +1,14 @@
+//go:build protosnext
+
+package grpcapp
+
+import (
+	grpcadmin "github.com/GosMachine/ServiceAuth/internal/grpc/admin"
+	grpcauth "github.com/GosMachine/ServiceAuth/internal/grpc/auth"
+	"google.golang.org/grpc"
+)
+
+func registerServices(gRPCServer *grpc.Server, authService grpcauth.Auth, adminService grpcadmin.Admin) {
+	grpcauth.RegisterAuthServer(gRPCServer, authService)
+	grpcadmin.RegisterAdminServer(gRPCServer, adminService)
+}
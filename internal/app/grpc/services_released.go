@@ -0,0 +1,16 @@
+//go:build !protosnext
+
+package grpcapp
+
+import (
+	grpcadmin "github.com/GosMachine/ServiceAuth/internal/grpc/admin"
+	grpcauth "github.com/GosMachine/ServiceAuth/internal/grpc/auth"
+	"google.golang.org/grpc"
+)
+
+// registerServices serves what the protos release in go.mod describes: the
+// Auth RPCs it has and no AdminAuth. The handlers of the RPCs added since
+// are built with -tags protosnext, against a protos that has them.
+func registerServices(gRPCServer *grpc.Server, authService grpcauth.Auth, adminService grpcadmin.Admin) {
+	grpcauth.RegisterAuthServer(gRPCServer, authService)
+}
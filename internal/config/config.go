@@ -1,26 +1,345 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net/mail"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"go.uber.org/zap/zapcore"
 )
 
 type Config struct {
-	Env                string        `yaml:"env" env-default:"local"`
-	TokenTtl           time.Duration `yaml:"token_ttl" env-required:"true"`
-	RememberMeTokenTTL time.Duration `yaml:"remember_me_token_ttl" env-required:"true"`
-	GRPC               GRPCConfig    `yaml:"grpc"`
+	Env string `yaml:"env" env-default:"local"`
+	// Storage is "postgres" (Postgres plus Redis) or "memory", which keeps
+	// everything in process and needs no external services.
+	Storage            string         `yaml:"storage" env:"STORAGE" env-default:"postgres"`
+	TokenTtl           time.Duration  `yaml:"token_ttl" env-required:"true"`
+	RememberMeTokenTTL time.Duration  `yaml:"remember_me_token_ttl" env-required:"true"`
+	GRPC               GRPCConfig     `yaml:"grpc"`
+	Database           DatabaseConfig `yaml:"database"`
+	Redis              RedisConfig    `yaml:"redis"`
+	Session            SessionConfig  `yaml:"session"`
+	Metrics            MetricsConfig  `yaml:"metrics"`
+	Tracing            TracingConfig  `yaml:"tracing"`
+	Log                LogConfig      `yaml:"log"`
+	Audit              AuditConfig    `yaml:"audit"`
+	Events             EventsConfig   `yaml:"events"`
+	Webhooks           WebhooksConfig `yaml:"webhooks"`
+	Notifier           NotifierConfig `yaml:"notifier"`
+	Devices            DevicesConfig  `yaml:"devices"`
+	GeoIP              GeoIPConfig    `yaml:"geoip"`
+	Password           PasswordConfig `yaml:"password"`
+	Email              EmailConfig    `yaml:"email"`
+	RBAC               RBACConfig     `yaml:"rbac"`
+	Deletion           DeletionConfig `yaml:"deletion"`
+	Export             ExportConfig   `yaml:"export"`
+
+	path string
 }
 
 type GRPCConfig struct {
 	Timeout time.Duration `yaml:"timeout"`
+	// ServiceTokens maps the name of each service allowed to move balances
+	// to the token it sends, see grpcauth.ServiceTokenInterceptor.
+	ServiceTokens map[string]string `yaml:"service_tokens" env:"GRPC_SERVICE_TOKENS" secret:"true"`
+}
+
+// DatabaseConfig describes the Postgres connection. Either DSN (or DSNFile)
+// or the separate Host/User/Name fields are used, never both.
+type DatabaseConfig struct {
+	DSN              string        `yaml:"dsn" env:"DB_DSN" secret:"true"`
+	DSNFile          string        `yaml:"dsn_file" env:"DB_DSN_FILE"`
+	Host             string        `yaml:"host" env:"DB_HOST"`
+	Port             string        `yaml:"port" env:"DB_PORT" env-default:"5432"`
+	User             string        `yaml:"user" env:"DB_USERNAME"`
+	Password         string        `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	PasswordFile     string        `yaml:"password_file" env:"DB_PASSWORD_FILE"`
+	Name             string        `yaml:"name" env:"DB_DATABASE"`
+	SSLMode          string        `yaml:"sslmode" env:"DB_SSLMODE" env-default:"disable"`
+	SSLRootCert      string        `yaml:"sslrootcert" env:"DB_SSLROOTCERT"`
+	ReplicaDSN       string        `yaml:"replica_dsn" env:"DB_REPLICA_DSN" secret:"true"`
+	MaxOpenConns     int           `yaml:"max_open_conns" env-default:"20"`
+	MaxIdleConns     int           `yaml:"max_idle_conns" env-default:"5"`
+	ConnMaxLifetime  time.Duration `yaml:"conn_max_lifetime" env-default:"30m"`
+	ConnMaxIdleTime  time.Duration `yaml:"conn_max_idle_time" env-default:"5m"`
+	StatementTimeout time.Duration `yaml:"statement_timeout" env-default:"10s"`
+	ConnectAttempts  int           `yaml:"connect_attempts" env-default:"5"`
+	ConnectBackoff   time.Duration `yaml:"connect_backoff" env-default:"1s"`
+}
+
+// RedisConfig selects how the service talks to Redis. Addrs holds the single
+// server in standalone mode, the sentinels in sentinel mode and the seed
+// nodes in cluster mode.
+type RedisConfig struct {
+	Mode             string         `yaml:"mode" env:"REDIS_MODE" env-default:"standalone"`
+	Addrs            []string       `yaml:"addrs" env:"REDIS_ADDR" env-separator:","`
+	MasterName       string         `yaml:"master_name" env:"REDIS_MASTER_NAME"`
+	Username         string         `yaml:"username" env:"REDIS_USERNAME"`
+	Password         string         `yaml:"password" env:"REDIS_PASS" secret:"true"`
+	SentinelUsername string         `yaml:"sentinel_username" env:"REDIS_SENTINEL_USERNAME"`
+	SentinelPassword string         `yaml:"sentinel_password" env:"REDIS_SENTINEL_PASS" secret:"true"`
+	DB               int            `yaml:"db" env:"REDIS_DB"`
+	KeyPrefix        string         `yaml:"key_prefix" env:"REDIS_KEY_PREFIX"`
+	PoolSize         int            `yaml:"pool_size" env-default:"10"`
+	MinIdleConns     int            `yaml:"min_idle_conns" env-default:"2"`
+	DialTimeout      time.Duration  `yaml:"dial_timeout" env-default:"5s"`
+	ReadTimeout      time.Duration  `yaml:"read_timeout" env-default:"3s"`
+	WriteTimeout     time.Duration  `yaml:"write_timeout" env-default:"3s"`
+	TLS              RedisTLSConfig `yaml:"tls"`
+}
+
+type RedisTLSConfig struct {
+	Enabled            bool   `yaml:"enabled" env:"REDIS_TLS"`
+	CAFile             string `yaml:"ca_file" env:"REDIS_TLS_CA_FILE"`
+	CertFile           string `yaml:"cert_file" env:"REDIS_TLS_CERT_FILE"`
+	KeyFile            string `yaml:"key_file" env:"REDIS_TLS_KEY_FILE"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// MetricsConfig enables the Prometheus endpoint when Addr is set.
+type MetricsConfig struct {
+	Addr string `yaml:"addr" env:"METRICS_ADDR"`
+}
+
+// TracingConfig selects where spans go: "otlp" (gRPC to Endpoint), "stdout"
+// or "none". SampleRatio applies to traces started here, sampled parents are
+// always followed.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"`
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	Insecure    bool    `yaml:"insecure" env:"TRACING_INSECURE"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" env-default:"1"`
+}
+
+// LogConfig describes the service logger. Format is "json" or "console";
+// Sampling is off unless Initial is set.
+type LogConfig struct {
+	Level       string          `yaml:"level" env:"LOG_LEVEL" env-default:"info"`
+	Format      string          `yaml:"format" env:"LOG_FORMAT" env-default:"json"`
+	OutputPaths []string        `yaml:"output_paths" env:"LOG_OUTPUT" env-separator:"," env-default:"stdout"`
+	Sampling    SamplingConfig  `yaml:"sampling"`
+	Redaction   RedactionConfig `yaml:"redaction"`
+}
+
+// SamplingConfig keeps the first Initial entries with the same level and
+// message each second, then every Thereafter-th one.
+type SamplingConfig struct {
+	Initial    int `yaml:"initial"`
+	Thereafter int `yaml:"thereafter"`
+}
+
+// RedactionConfig says how personal data is logged. Email is "plain", "mask"
+// or "hash", IP is "plain", "truncate" or "drop". Tokens and passwords are
+// never logged whatever is set here.
+type RedactionConfig struct {
+	Email    string `yaml:"email" env:"LOG_REDACT_EMAIL" env-default:"mask"`
+	IP       string `yaml:"ip" env:"LOG_REDACT_IP" env-default:"truncate"`
+	HashSalt string `yaml:"hash_salt" env:"LOG_HASH_SALT" secret:"true"`
+}
+
+// AuditConfig sets how long auth events are kept and how often the ones
+// past Retention are deleted.
+type AuditConfig struct {
+	Retention     time.Duration `yaml:"retention" env:"AUDIT_RETENTION" env-default:"2160h"`
+	PruneInterval time.Duration `yaml:"prune_interval" env-default:"1h"`
+}
+
+// EventsConfig selects where domain events from the outbox are published:
+// "log", "redis" (a stream) or "webhook". Published rows are kept for
+// Retention. A relay leases the events it publishes for Lease, which has to
+// cover publishing a whole batch.
+type EventsConfig struct {
+	Sink           string        `yaml:"sink" env:"EVENTS_SINK" env-default:"log"`
+	RedisStream    string        `yaml:"redis_stream" env:"EVENTS_REDIS_STREAM" env-default:"auth:events"`
+	RedisStreamLen int64         `yaml:"redis_stream_len" env-default:"100000"`
+	WebhookURL     string        `yaml:"webhook_url" env:"EVENTS_WEBHOOK_URL" secret:"true"`
+	WebhookTimeout time.Duration `yaml:"webhook_timeout" env-default:"5s"`
+	PollInterval   time.Duration `yaml:"poll_interval" env-default:"1s"`
+	BatchSize      int           `yaml:"batch_size" env-default:"100"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env-default:"5m"`
+	Retention      time.Duration `yaml:"retention" env-default:"168h"`
+	Lease          time.Duration `yaml:"lease" env-default:"5m"`
+}
+
+// WebhooksConfig tunes partner webhook delivery. Subscriptions themselves
+// are managed over gRPC. AllowHTTP permits plain http URLs, which is only
+// meant for local development. A worker leases the deliveries it sends for
+// Lease, which has to cover sending a whole batch.
+type WebhooksConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+	BatchSize    int           `yaml:"batch_size" env-default:"50"`
+	Timeout      time.Duration `yaml:"timeout" env-default:"10s"`
+	MaxAttempts  int           `yaml:"max_attempts" env-default:"10"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" env-default:"1h"`
+	DisableAfter int           `yaml:"disable_after" env-default:"50"`
+	Retention    time.Duration `yaml:"retention" env-default:"720h"`
+	AllowHTTP    bool          `yaml:"allow_http" env:"WEBHOOKS_ALLOW_HTTP"`
+	Lease        time.Duration `yaml:"lease" env-default:"15m"`
+}
+
+// NotifierConfig selects how messages to users go out: "log" only logs
+// them, "file" writes .eml files to Dir and "smtp" sends them through SMTP.
+// The queue leases the notifications it sends for Lease, which has to cover
+// sending a whole batch.
+type NotifierConfig struct {
+	Driver        string        `yaml:"driver" env:"NOTIFIER_DRIVER" env-default:"log"`
+	From          string        `yaml:"from" env:"NOTIFIER_FROM" env-default:"ServiceAuth <no-reply@localhost>"`
+	Product       string        `yaml:"product" env-default:"ServiceAuth"`
+	DefaultLocale string        `yaml:"default_locale" env-default:"en"`
+	Dir           string        `yaml:"dir" env:"NOTIFIER_DIR" env-default:"./mail"`
+	SMTP          SMTPConfig    `yaml:"smtp"`
+	PollInterval  time.Duration `yaml:"poll_interval" env-default:"2s"`
+	BatchSize     int           `yaml:"batch_size" env-default:"20"`
+	MaxAttempts   int           `yaml:"max_attempts" env-default:"8"`
+	MaxBackoff    time.Duration `yaml:"max_backoff" env-default:"30m"`
+	Retention     time.Duration `yaml:"retention" env-default:"168h"`
+	Lease         time.Duration `yaml:"lease" env-default:"10m"`
+}
+
+// DevicesConfig controls new sign-in alerts. A device is an IP prefix of
+// IPv4Prefix/IPv6Prefix bits plus the user agent. Signing in from an unknown
+// one, or after InactivityThreshold without any sign-in, sends the user an
+// alert with a link to ReportURL that stays valid for ReportTTL. Reporting
+// a sign-in emails the user a link to ResetURL to choose a new password,
+// valid for ResetTTL.
+type DevicesConfig struct {
+	Enabled             bool          `yaml:"enabled" env:"DEVICES_ENABLED" env-default:"true"`
+	IPv4Prefix          int           `yaml:"ipv4_prefix" env-default:"24"`
+	IPv6Prefix          int           `yaml:"ipv6_prefix" env-default:"48"`
+	InactivityThreshold time.Duration `yaml:"inactivity_threshold" env-default:"2160h"`
+	ReportURL           string        `yaml:"report_url" env:"DEVICES_REPORT_URL" env-default:"http://localhost:8080/security/report"`
+	ReportTTL           time.Duration `yaml:"report_ttl" env-default:"168h"`
+	ResetURL            string        `yaml:"reset_url" env:"DEVICES_RESET_URL" env-default:"http://localhost:8080/security/reset"`
+	ResetTTL            time.Duration `yaml:"reset_ttl" env-default:"24h"`
+}
+
+// GeoIPConfig points at local mmdb files used to locate client addresses.
+// With BlockLogin or BlockRegister set, addresses from countries outside
+// AllowCountries (when given) or inside DenyCountries are turned away.
+// Addresses that can't be located pass unless BlockUnknown is set.
+type GeoIPConfig struct {
+	CityDB         string        `yaml:"city_db" env:"GEOIP_CITY_DB"`
+	ASNDB          string        `yaml:"asn_db" env:"GEOIP_ASN_DB"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env-default:"1m"`
+	AllowCountries []string      `yaml:"allow_countries" env:"GEOIP_ALLOW_COUNTRIES"`
+	DenyCountries  []string      `yaml:"deny_countries" env:"GEOIP_DENY_COUNTRIES"`
+	BlockUnknown   bool          `yaml:"block_unknown"`
+	BlockLogin     bool          `yaml:"block_login"`
+	BlockRegister  bool          `yaml:"block_register"`
+}
+
+// PasswordConfig is the policy new passwords must meet. MinScore is the
+// zxcvbn strength score from 0 (off) to 4, History how many previous
+// passwords may not be reused. A password older than MaxAge, if set, has to
+// be changed at the next sign-in, with a reset token valid for
+// ResetTokenTTL.
+type PasswordConfig struct {
+	MinLength     int            `yaml:"min_length" env-default:"10"`
+	MaxLength     int            `yaml:"max_length" env-default:"72"`
+	RequireLower  bool           `yaml:"require_lower"`
+	RequireUpper  bool           `yaml:"require_upper"`
+	RequireDigit  bool           `yaml:"require_digit"`
+	RequireSymbol bool           `yaml:"require_symbol"`
+	MinScore      int            `yaml:"min_score" env-default:"2"`
+	RejectEmail   bool           `yaml:"reject_email" env-default:"true"`
+	History       int            `yaml:"history" env-default:"1"`
+	MaxAge        time.Duration  `yaml:"max_age"`
+	ResetTokenTTL time.Duration  `yaml:"reset_token_ttl" env-default:"15m"`
+	Breached      BreachedConfig `yaml:"breached"`
+}
+
+// BreachedConfig checks new passwords against a local copy of the Pwned
+// Passwords data, either a filter built with the breach-filter command or
+// a directory of range files. Mode is "off", "warn" (only log) or "reject".
+type BreachedConfig struct {
+	Mode     string `yaml:"mode" env:"PASSWORD_BREACHED_MODE" env-default:"off"`
+	Filter   string `yaml:"filter" env:"PASSWORD_BREACHED_FILTER"`
+	RangeDir string `yaml:"range_dir" env:"PASSWORD_BREACHED_RANGE_DIR"`
+}
+
+// EmailConfig restricts the addresses new accounts and email changes may
+// use. DisposableList names a file of throwaway mail domains, one per line.
+// With AllowDomains set only those domains are accepted. Listed domains
+// match their subdomains too.
+type EmailConfig struct {
+	DisposableList string   `yaml:"disposable_list" env:"EMAIL_DISPOSABLE_LIST"`
+	AllowDomains   []string `yaml:"allow_domains" env:"EMAIL_ALLOW_DOMAINS" env-separator:","`
+	DenyDomains    []string `yaml:"deny_domains" env:"EMAIL_DENY_DOMAINS" env-separator:","`
+}
+
+// RBACConfig defines the roles and what they permit. Roles are created or
+// updated from it at startup, and Grants assigns roles to existing users
+// by email, such as the first administrators. Sessions pick up role changes
+// within RefreshInterval.
+type RBACConfig struct {
+	Roles           map[string]RoleConfig `yaml:"roles"`
+	Grants          map[string][]string   `yaml:"grants" pii:"email"`
+	RefreshInterval time.Duration         `yaml:"refresh_interval" env-default:"1m"`
+}
+
+type RoleConfig struct {
+	Description string   `yaml:"description"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// DeletionConfig controls accounts deleted by their users. The user is
+// emailed a link to CancelURL that restores the account within
+// GracePeriod. After that the purge job, running every PurgeInterval,
+// removes it: Mode "anonymize" keeps the row with the personal data wiped,
+// "delete" drops it. Either way the email can be used again. Users without
+// a password confirm the deletion with a session no older than
+// ReauthWindow instead.
+type DeletionConfig struct {
+	GracePeriod   time.Duration `yaml:"grace_period" env:"DELETION_GRACE_PERIOD" env-default:"720h"`
+	Mode          string        `yaml:"mode" env:"DELETION_MODE" env-default:"anonymize"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env-default:"1h"`
+	CancelURL     string        `yaml:"cancel_url" env:"DELETION_CANCEL_URL" env-default:"http://localhost:8080/account/restore"`
+	ReauthWindow  time.Duration `yaml:"reauth_window" env-default:"5m"`
+}
+
+// ExportConfig limits the data exports users ask for themselves to
+// RateLimit per RateWindow. Exports made by operators are not limited.
+type ExportConfig struct {
+	RateLimit  int           `yaml:"rate_limit" env:"EXPORT_RATE_LIMIT" env-default:"3"`
+	RateWindow time.Duration `yaml:"rate_window" env-default:"24h"`
+}
+
+// SMTPConfig describes the mail server. TLS is "starttls", "implicit"
+// (usually port 465) or "none", which is only fit for a local relay.
+type SMTPConfig struct {
+	Host               string        `yaml:"host" env:"SMTP_HOST"`
+	Port               int           `yaml:"port" env:"SMTP_PORT" env-default:"587"`
+	Username           string        `yaml:"username" env:"SMTP_USERNAME"`
+	Password           string        `yaml:"password" env:"SMTP_PASSWORD" secret:"true"`
+	TLS                string        `yaml:"tls" env:"SMTP_TLS" env-default:"starttls"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	Timeout            time.Duration `yaml:"timeout" env-default:"10s"`
+	IdleTimeout        time.Duration `yaml:"idle_timeout" env-default:"30s"`
+}
+
+// SessionConfig picks where sessions live. With Store "postgres" Redis is
+// optional; RedisCache puts it in front of Postgres as a write-through cache.
+type SessionConfig struct {
+	Store        string        `yaml:"store" env:"SESSION_STORE" env-default:"redis"`
+	RedisCache   bool          `yaml:"redis_cache" env:"SESSION_REDIS_CACHE"`
+	ReapInterval time.Duration `yaml:"reap_interval" env-default:"10m"`
 }
 
 func MustLoad() *Config {
-	path := fetchConfigPath()
+	return MustLoadPath(fetchConfigPath())
+}
+
+// MustLoadPath is MustLoad for commands, which take the path as a flag of
+// their own.
+func MustLoadPath(path string) *Config {
 	if path == "" {
 		panic("config path is empty")
 	}
@@ -32,9 +351,416 @@ func MustLoad() *Config {
 	if err := cleanenv.ReadConfig(path, &cfg); err != nil {
 		panic("failed to read config" + err.Error())
 	}
+	if err := cfg.Validate(); err != nil {
+		panic("invalid config: " + err.Error())
+	}
+	cfg.path = path
 	return &cfg
 }
 
+// ReloadLogLevel reads the config file again and returns the log level it
+// sets now. Nothing else is reloaded.
+func (c *Config) ReloadLogLevel() (string, error) {
+	var fresh Config
+	if err := cleanenv.ReadConfig(c.path, &fresh); err != nil {
+		return "", err
+	}
+	if err := fresh.Log.Validate(); err != nil {
+		return "", err
+	}
+	return fresh.Log.Level, nil
+}
+
+func (c *Config) Validate() error {
+	if err := c.Tracing.Validate(); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
+	if err := c.Log.Validate(); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	if err := c.Audit.Validate(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	if err := c.Events.Validate(); err != nil {
+		return fmt.Errorf("events: %w", err)
+	}
+	if err := c.Webhooks.Validate(); err != nil {
+		return fmt.Errorf("webhooks: %w", err)
+	}
+	if err := c.Notifier.Validate(); err != nil {
+		return fmt.Errorf("notifier: %w", err)
+	}
+	if err := c.Devices.Validate(); err != nil {
+		return fmt.Errorf("devices: %w", err)
+	}
+	if err := c.GeoIP.Validate(); err != nil {
+		return fmt.Errorf("geoip: %w", err)
+	}
+	if err := c.Password.Validate(); err != nil {
+		return fmt.Errorf("password: %w", err)
+	}
+	if err := c.Email.Validate(); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	if err := c.RBAC.Validate(); err != nil {
+		return fmt.Errorf("rbac: %w", err)
+	}
+	if err := c.Deletion.Validate(); err != nil {
+		return fmt.Errorf("deletion: %w", err)
+	}
+	if err := c.Export.Validate(); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	switch c.Storage {
+	case "memory":
+		if c.Events.Sink == "redis" {
+			return errors.New("events: the redis sink needs postgres storage")
+		}
+		return nil
+	case "postgres":
+	default:
+		return fmt.Errorf("unknown storage %q", c.Storage)
+	}
+	if err := c.Database.Validate(); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	if err := c.Session.Validate(); err != nil {
+		return fmt.Errorf("session: %w", err)
+	}
+	if c.NeedsRedis() {
+		if err := c.Redis.Validate(); err != nil {
+			return fmt.Errorf("redis: %w", err)
+		}
+	}
+	return nil
+}
+
+// NeedsRedis reports whether the configuration requires a Redis connection.
+func (c *Config) NeedsRedis() bool {
+	return len(c.Redis.Addrs) > 0 || c.Session.Store == "redis" || c.Session.RedisCache || c.Events.Sink == "redis"
+}
+
+func (c *TracingConfig) Validate() error {
+	switch c.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Endpoint == "" {
+			return errors.New("otlp exporter needs an endpoint")
+		}
+	default:
+		return fmt.Errorf("unknown exporter %q", c.Exporter)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return errors.New("sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+func (c *EventsConfig) Validate() error {
+	switch c.Sink {
+	case "log":
+	case "redis":
+		if c.RedisStream == "" {
+			return errors.New("the redis sink needs redis_stream")
+		}
+	case "webhook":
+		if c.WebhookURL == "" {
+			return errors.New("the webhook sink needs webhook_url")
+		}
+	default:
+		return fmt.Errorf("unknown sink %q", c.Sink)
+	}
+	if c.PollInterval <= 0 || c.BatchSize <= 0 || c.MaxBackoff <= 0 || c.Retention <= 0 || c.Lease <= 0 {
+		return errors.New("poll_interval, batch_size, max_backoff, retention and lease must be positive")
+	}
+	return nil
+}
+
+func (c *NotifierConfig) Validate() error {
+	switch c.Driver {
+	case "log":
+	case "file":
+		if c.Dir == "" {
+			return errors.New("the file driver needs dir")
+		}
+	case "smtp":
+		if c.SMTP.Host == "" {
+			return errors.New("the smtp driver needs smtp.host")
+		}
+		switch c.SMTP.TLS {
+		case "starttls", "implicit", "none":
+		default:
+			return fmt.Errorf("unknown smtp tls mode %q", c.SMTP.TLS)
+		}
+		if c.SMTP.Timeout <= 0 {
+			return errors.New("smtp.timeout must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown driver %q", c.Driver)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	if c.PollInterval <= 0 || c.BatchSize <= 0 || c.MaxAttempts <= 0 || c.MaxBackoff <= 0 || c.Retention <= 0 || c.Lease <= 0 {
+		return errors.New("poll_interval, batch_size, max_attempts, max_backoff, retention and lease must be positive")
+	}
+	return nil
+}
+
+func (c *DevicesConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.IPv4Prefix < 1 || c.IPv4Prefix > 32 || c.IPv6Prefix < 1 || c.IPv6Prefix > 128 {
+		return errors.New("ipv4_prefix must be within 1-32 and ipv6_prefix within 1-128")
+	}
+	if c.InactivityThreshold <= 0 || c.ReportTTL <= 0 || c.ResetTTL <= 0 {
+		return errors.New("inactivity_threshold, report_ttl and reset_ttl must be positive")
+	}
+	u, err := url.Parse(c.ReportURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid report_url %q", c.ReportURL)
+	}
+	u, err = url.Parse(c.ResetURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid reset_url %q", c.ResetURL)
+	}
+	return nil
+}
+
+func (c *GeoIPConfig) Validate() error {
+	if c.ReloadInterval <= 0 {
+		return errors.New("reload_interval must be positive")
+	}
+	for _, country := range append(c.AllowCountries, c.DenyCountries...) {
+		if len(country) != 2 || strings.ToUpper(country) != country {
+			return fmt.Errorf("%q is not an upper case ISO 3166-1 alpha-2 code", country)
+		}
+	}
+	if (c.BlockLogin || c.BlockRegister) && c.CityDB == "" {
+		return errors.New("blocking by country needs city_db")
+	}
+	return nil
+}
+
+func (c *PasswordConfig) Validate() error {
+	// bcrypt only looks at the first 72 bytes
+	if c.MinLength < 1 || c.MaxLength > 72 || c.MinLength > c.MaxLength {
+		return errors.New("need 1 <= min_length <= max_length <= 72")
+	}
+	if c.MinScore < 0 || c.MinScore > 4 {
+		return errors.New("min_score must be within 0-4")
+	}
+	if c.History < 0 || c.MaxAge < 0 {
+		return errors.New("history and max_age must not be negative")
+	}
+	if c.ResetTokenTTL <= 0 {
+		return errors.New("reset_token_ttl must be positive")
+	}
+	switch c.Breached.Mode {
+	case "off":
+	case "warn", "reject":
+		if (c.Breached.Filter == "") == (c.Breached.RangeDir == "") {
+			return errors.New("breached: set exactly one of filter and range_dir")
+		}
+	default:
+		return fmt.Errorf("breached: unknown mode %q", c.Breached.Mode)
+	}
+	return nil
+}
+
+func (c *EmailConfig) Validate() error {
+	for _, domain := range append(append([]string(nil), c.AllowDomains...), c.DenyDomains...) {
+		if strings.TrimSpace(domain) == "" || strings.Contains(domain, "@") {
+			return fmt.Errorf("%q is not a domain", domain)
+		}
+	}
+	return nil
+}
+
+var rbacNameRe = regexp.MustCompile(`^[a-z][a-z0-9_.:-]*$`)
+
+func (c *RBACConfig) Validate() error {
+	if c.RefreshInterval <= 0 {
+		return errors.New("refresh_interval must be positive")
+	}
+	for name, role := range c.Roles {
+		if !rbacNameRe.MatchString(name) || len(name) > 64 {
+			return fmt.Errorf("invalid role name %q", name)
+		}
+		for _, permission := range role.Permissions {
+			if !rbacNameRe.MatchString(permission) || len(permission) > 128 {
+				return fmt.Errorf("role %s: invalid permission %q", name, permission)
+			}
+		}
+	}
+	for role := range c.Grants {
+		if _, ok := c.Roles[role]; !ok {
+			return fmt.Errorf("grants: unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+func (c *DeletionConfig) Validate() error {
+	switch c.Mode {
+	case "anonymize", "delete":
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	if c.GracePeriod < 0 {
+		return errors.New("grace_period must not be negative")
+	}
+	if c.PurgeInterval <= 0 || c.ReauthWindow <= 0 {
+		return errors.New("purge_interval and reauth_window must be positive")
+	}
+	u, err := url.Parse(c.CancelURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid cancel_url %q", c.CancelURL)
+	}
+	return nil
+}
+
+func (c *ExportConfig) Validate() error {
+	if c.RateLimit <= 0 || c.RateWindow <= 0 {
+		return errors.New("rate_limit and rate_window must be positive")
+	}
+	return nil
+}
+
+func (c *WebhooksConfig) Validate() error {
+	if c.PollInterval <= 0 || c.BatchSize <= 0 || c.Timeout <= 0 || c.MaxBackoff <= 0 || c.Retention <= 0 || c.Lease <= 0 {
+		return errors.New("poll_interval, batch_size, timeout, max_backoff, retention and lease must be positive")
+	}
+	if c.MaxAttempts <= 0 || c.DisableAfter <= 0 {
+		return errors.New("max_attempts and disable_after must be positive")
+	}
+	return nil
+}
+
+func (c *AuditConfig) Validate() error {
+	if c.Retention <= 0 || c.PruneInterval <= 0 {
+		return errors.New("retention and prune_interval must be positive")
+	}
+	return nil
+}
+
+func (c *LogConfig) Validate() error {
+	if _, err := zapcore.ParseLevel(c.Level); err != nil {
+		return err
+	}
+	switch c.Format {
+	case "json", "console":
+	default:
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+	if len(c.OutputPaths) == 0 {
+		return errors.New("at least one output path is required")
+	}
+	if c.Sampling.Initial < 0 || c.Sampling.Thereafter < 0 {
+		return errors.New("sampling must not be negative")
+	}
+	return c.Redaction.Validate()
+}
+
+func (c *RedactionConfig) Validate() error {
+	switch c.Email {
+	case "plain", "mask", "hash":
+	default:
+		return fmt.Errorf("unknown email redaction %q", c.Email)
+	}
+	switch c.IP {
+	case "plain", "truncate", "drop":
+	default:
+		return fmt.Errorf("unknown ip redaction %q", c.IP)
+	}
+	return nil
+}
+
+func (c *SessionConfig) Validate() error {
+	switch c.Store {
+	case "redis":
+		if c.RedisCache {
+			return errors.New("redis_cache only applies to the postgres store")
+		}
+	case "postgres":
+		if c.ReapInterval <= 0 {
+			return errors.New("reap_interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown store %q", c.Store)
+	}
+	return nil
+}
+
+func (c *DatabaseConfig) Validate() error {
+	hasDSN := c.DSN != "" || c.DSNFile != ""
+	if c.DSN != "" && c.DSNFile != "" {
+		return errors.New("dsn and dsn_file are mutually exclusive")
+	}
+	if c.Password != "" && c.PasswordFile != "" {
+		return errors.New("password and password_file are mutually exclusive")
+	}
+	hasFields := c.Host != "" || c.User != "" || c.Name != "" || c.Password != "" || c.PasswordFile != ""
+	if hasDSN && hasFields {
+		return errors.New("dsn excludes host, user, name and password")
+	}
+	if !hasDSN && (c.Host == "" || c.User == "" || c.Name == "") {
+		return errors.New("either dsn or host, user and name must be set")
+	}
+	switch c.SSLMode {
+	case "disable", "allow", "prefer", "require":
+	case "verify-ca", "verify-full":
+		if c.SSLRootCert == "" && !hasDSN {
+			return fmt.Errorf("sslmode %s needs sslrootcert", c.SSLMode)
+		}
+	default:
+		return fmt.Errorf("unknown sslmode %q", c.SSLMode)
+	}
+	if c.MaxOpenConns <= 0 {
+		return errors.New("max_open_conns must be positive")
+	}
+	if c.MaxIdleConns < 0 || c.MaxIdleConns > c.MaxOpenConns {
+		return errors.New("max_idle_conns must be between 0 and max_open_conns")
+	}
+	if c.ConnMaxLifetime < 0 || c.ConnMaxIdleTime < 0 || c.StatementTimeout < 0 {
+		return errors.New("durations must not be negative")
+	}
+	if c.ConnectAttempts <= 0 || c.ConnectBackoff <= 0 {
+		return errors.New("connect_attempts and connect_backoff must be positive")
+	}
+	return nil
+}
+
+func (c *RedisConfig) Validate() error {
+	if len(c.Addrs) == 0 {
+		return errors.New("at least one address is required")
+	}
+	switch c.Mode {
+	case "standalone":
+		if len(c.Addrs) != 1 {
+			return errors.New("standalone mode takes exactly one address")
+		}
+	case "sentinel":
+		if c.MasterName == "" {
+			return errors.New("sentinel mode needs master_name")
+		}
+	case "cluster":
+		if c.DB != 0 {
+			return errors.New("cluster mode only supports db 0")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	if c.PoolSize <= 0 {
+		return errors.New("pool_size must be positive")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls cert_file and key_file go together")
+	}
+	return nil
+}
+
 func fetchConfigPath() string {
 	var res string
 
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+const masked = "******"
+
+// Redacted returns a copy of c that is safe to log: every non-empty string
+// field tagged `secret:"true"`, or every value of such a map, is replaced
+// with a mask, and the addresses in
+// fields tagged `pii:"email"` keep only their first letter and domain.
+func (c *Config) Redacted() Config {
+	out := *c
+	maskSecrets(reflect.ValueOf(&out).Elem())
+	return out
+}
+
+func maskSecrets(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.Kind() == reflect.Struct:
+			maskSecrets(f)
+		case f.Kind() == reflect.String && t.Field(i).Tag.Get("secret") == "true":
+			if f.String() != "" {
+				f.SetString(masked)
+			}
+		case f.Kind() == reflect.Map && t.Field(i).Tag.Get("secret") == "true":
+			f.Set(maskValues(f))
+		case t.Field(i).Tag.Get("pii") == "email":
+			f.Set(maskEmails(f))
+		}
+	}
+}
+
+// maskValues returns a copy of the map of strings v with every value masked.
+func maskValues(v reflect.Value) reflect.Value {
+	if v.IsNil() {
+		return v
+	}
+	out := reflect.MakeMapWithSize(v.Type(), v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		out.SetMapIndex(iter.Key(), reflect.ValueOf(masked).Convert(v.Type().Elem()))
+	}
+	return out
+}
+
+// maskEmails returns a masked copy of v, a string, a []string or a map of
+// either. The copy leaves the original config untouched, maps and slices
+// are shared with it.
+func maskEmails(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(maskEmail(v.String())).Convert(v.Type())
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(maskEmails(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), maskEmails(iter.Value()))
+		}
+		return out
+	}
+	return v
+}
+
+// maskEmail keeps the first character of the local part and the domain,
+// like the log redaction does.
+func maskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return masked
+	}
+	return email[:1] + "***" + email[at:]
+}
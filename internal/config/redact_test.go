@@ -0,0 +1,37 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	cfg := Config{
+		Database: DatabaseConfig{Password: "hunter2", User: "auth"},
+		GRPC:     GRPCConfig{ServiceTokens: map[string]string{"billing": "s3cret"}},
+		RBAC: RBACConfig{Grants: map[string][]string{
+			"admin": {"jane@example.com", "bob@example.org"},
+		}},
+	}
+	out := cfg.Redacted()
+
+	if out.Database.Password != masked {
+		t.Errorf("password = %q, want it masked", out.Database.Password)
+	}
+	if out.Database.User != "auth" {
+		t.Errorf("user = %q, want it kept", out.Database.User)
+	}
+	if got := out.GRPC.ServiceTokens["billing"]; got != masked {
+		t.Errorf("service token = %q, want it masked", got)
+	}
+	if got := cfg.GRPC.ServiceTokens["billing"]; got != "s3cret" {
+		t.Errorf("original service token = %q, want it untouched", got)
+	}
+	want := []string{"j***@example.com", "b***@example.org"}
+	if got := out.RBAC.Grants["admin"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("grants = %v, want %v", got, want)
+	}
+	if got := cfg.RBAC.Grants["admin"][0]; got != "jane@example.com" {
+		t.Errorf("original grant = %q, want it untouched", got)
+	}
+}
@@ -0,0 +1,107 @@
+// Package events defines the domain events ServiceAuth publishes and relays
+// them from the transactional outbox to a Sink.
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/utils"
+)
+
+// SchemaVersion is the version of the envelope and payloads below. It is
+// bumped on any change that isn't a new optional field.
+const SchemaVersion = 1
+
+// Event types.
+const (
+	TypeUserRegistered  = "user.registered"
+	TypeEmailVerified   = "user.email_verified"
+	TypePasswordChanged = "user.password_changed"
+	TypeEmailChanged    = "user.email_changed"
+	TypeUserDeleted     = "user.deleted"
+)
+
+// Types lists every event type, in the order they were introduced.
+var Types = []string{
+	TypeUserRegistered,
+	TypeEmailVerified,
+	TypePasswordChanged,
+	TypeEmailChanged,
+	TypeUserDeleted,
+}
+
+// KnownType reports whether eventType is one of Types.
+func KnownType(eventType string) bool {
+	for _, t := range Types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Envelope is what sinks deliver. Consumers should deduplicate on ID since
+// delivery is at least once.
+type Envelope struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+type UserRegistered struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	// Provider is set when the account was created through OAuth.
+	Provider string `json:"provider,omitempty"`
+}
+
+type EmailVerified struct {
+	Email string `json:"email"`
+}
+
+type PasswordChanged struct {
+	Email string `json:"email"`
+}
+
+type EmailChanged struct {
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+}
+
+// UserDeleted carries no email, the events a consumer already has about the
+// user had theirs replaced when the account was purged.
+type UserDeleted struct {
+	UserID int `json:"user_id"`
+}
+
+// New builds the outbox row for an event of eventType carrying data.
+func New(eventType string, data any) (models.OutboxEvent, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return models.OutboxEvent{}, err
+	}
+	now := time.Now()
+	return models.OutboxEvent{
+		EventID:       utils.GenerateRandomString(32),
+		Type:          eventType,
+		Version:       SchemaVersion,
+		Payload:       payload,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}, nil
+}
+
+// Wrap turns an outbox row into the envelope that is published.
+func Wrap(event models.OutboxEvent) Envelope {
+	return Envelope{
+		ID:         event.EventID,
+		Type:       event.Type,
+		Version:    event.Version,
+		OccurredAt: event.CreatedAt,
+		Data:       json.RawMessage(event.Payload),
+	}
+}
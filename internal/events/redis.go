@@ -0,0 +1,37 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStreamSink appends events to a Redis stream, trimmed to about maxLen
+// entries.
+type RedisStreamSink struct {
+	client redis.UniversalClient
+	stream string
+	maxLen int64
+}
+
+func NewRedisStreamSink(client redis.UniversalClient, stream string, maxLen int64) *RedisStreamSink {
+	return &RedisStreamSink{client: client, stream: stream, maxLen: maxLen}
+}
+
+func (s *RedisStreamSink) Publish(ctx context.Context, event Envelope) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"id":   event.ID,
+			"type": event.Type,
+			"body": body,
+		},
+	}).Err()
+}
@@ -0,0 +1,179 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+const (
+	minBackoff    = time.Second
+	pruneInterval = time.Hour
+)
+
+// Relay moves events from the outbox to a Sink. A relay leases the events
+// it takes for cfg.Lease, so several instances can run side by side, and
+// publishes them without holding a transaction. An event is only marked
+// published after the sink accepted it, which makes delivery at least once:
+// when a relay dies mid batch its events fall due again once the lease
+// runs out. Events that fail are retried with exponential backoff, so they
+// can overtake each other.
+type Relay struct {
+	db  database.Database
+	log *zap.Logger
+	cfg config.EventsConfig
+	// remote are the sinks outside our database, local the TxSinks that
+	// write through the transaction marking an event published.
+	remote []Sink
+	local  []TxSink
+}
+
+func NewRelay(db database.Database, sink Sink, log *zap.Logger, cfg config.EventsConfig) *Relay {
+	r := &Relay{db: db, log: log, cfg: cfg}
+	r.remote, r.local = split(sink)
+	return r
+}
+
+// Run polls the outbox every cfg.PollInterval until ctx is done. Full batches
+// are followed up immediately so a backlog drains without waiting.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			n, err := r.RelayBatch(ctx)
+			if err != nil {
+				r.log.Error("failed to relay outbox events", zap.Error(err))
+				break
+			}
+			if n < r.cfg.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+		if time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			r.prune(ctx)
+		}
+	}
+}
+
+// RelayBatch publishes up to cfg.BatchSize due events and returns how many
+// it tried.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	due, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i := range due {
+		if err := r.relay(ctx, &due[i]); err != nil {
+			return len(due), err
+		}
+	}
+	return len(due), nil
+}
+
+// claim leases up to cfg.BatchSize due events by moving their next attempt
+// cfg.Lease ahead, which keeps other relays off them.
+func (r *Relay) claim(ctx context.Context) ([]models.OutboxEvent, error) {
+	var due []models.OutboxEvent
+	err := r.db.WithTx(ctx, func(tx database.Database) error {
+		now := time.Now()
+		var err error
+		due, err = tx.DueOutboxEvents(ctx, now, r.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, event := range due {
+			event.NextAttemptAt = now.Add(r.cfg.Lease)
+			if err := tx.UpdateOutboxEvent(ctx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return due, err
+}
+
+// relay publishes a claimed event and records how it went. The remote sinks
+// are called first, outside any transaction. Only when all of them took the
+// event the local ones write it, together with marking it published.
+func (r *Relay) relay(ctx context.Context, event *models.OutboxEvent) error {
+	event.Attempts++
+	envelope := Wrap(*event)
+	var errs []error
+	for _, sink := range r.remote {
+		if err := sink.Publish(ctx, envelope); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := errors.Join(errs...)
+	if err == nil {
+		var publishErr error
+		err = r.db.WithTx(ctx, func(tx database.Database) error {
+			for _, sink := range r.local {
+				if publishErr = sink.PublishTx(ctx, tx, envelope); publishErr != nil {
+					return publishErr
+				}
+			}
+			now := time.Now()
+			published := *event
+			published.PublishedAt = &now
+			published.LastError = ""
+			return tx.UpdateOutboxEvent(ctx, published)
+		})
+		if err == nil || publishErr == nil {
+			// not recorded, the event is published again after the lease
+			return err
+		}
+	}
+	r.failed(event, err)
+	return r.db.UpdateOutboxEvent(ctx, *event)
+}
+
+// failed schedules the next try of an event that could not be published.
+func (r *Relay) failed(event *models.OutboxEvent, err error) {
+	event.LastError = err.Error()
+	if len(event.LastError) > 1024 {
+		event.LastError = event.LastError[:1024]
+	}
+	event.NextAttemptAt = time.Now().Add(Backoff(event.Attempts, r.cfg.MaxBackoff))
+	r.log.Warn("failed to publish event",
+		zap.String("event_id", event.EventID),
+		zap.String("type", event.Type),
+		zap.Int("attempts", event.Attempts),
+		zap.Error(err),
+	)
+}
+
+// Backoff is the wait before the next try after attempts failed ones: one
+// second, doubling each time, capped at limit.
+func Backoff(attempts int, limit time.Duration) time.Duration {
+	backoff := minBackoff
+	for i := 1; i < attempts && backoff < limit; i++ {
+		backoff *= 2
+	}
+	return min(backoff, limit)
+}
+
+// prune drops published events older than cfg.Retention.
+func (r *Relay) prune(ctx context.Context) {
+	pruned, err := r.db.PruneOutbox(ctx, time.Now().Add(-r.cfg.Retention))
+	if err != nil {
+		r.log.Error("failed to prune outbox", zap.Error(err))
+		return
+	}
+	if pruned > 0 {
+		r.log.Info("published events pruned", zap.Int64("count", pruned))
+	}
+}
@@ -0,0 +1,191 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+	"go.uber.org/zap"
+)
+
+var (
+	testConfig = config.EventsConfig{BatchSize: 10, MaxBackoff: time.Minute, Lease: time.Minute}
+	maxTime    = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func addEvent(t *testing.T, db database.Database, email string) models.OutboxEvent {
+	t.Helper()
+	event, err := New(TypeUserRegistered, UserRegistered{Email: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddOutboxEvent(context.Background(), &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// relayBatch runs RelayBatch but gives up instead of hanging when the sink
+// deadlocks on the database.
+func relayBatch(t *testing.T, r *Relay) int {
+	t.Helper()
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := r.RelayBatch(context.Background())
+		done <- result{n, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("RelayBatch: %v", res.err)
+		}
+		return res.n
+	case <-time.After(5 * time.Second):
+		t.Fatal("RelayBatch did not return")
+		return 0
+	}
+}
+
+func TestRelayPublishes(t *testing.T) {
+	db := memory.NewDatabase(nil)
+	sink := NewMemorySink()
+	first := addEvent(t, db, "a@example.com")
+	second := addEvent(t, db, "b@example.com")
+
+	if n := relayBatch(t, NewRelay(db, sink, zap.NewNop(), testConfig)); n != 2 {
+		t.Fatalf("relayed %d events, want 2", n)
+	}
+	published := sink.Events()
+	if len(published) != 2 || published[0].ID != first.EventID || published[1].ID != second.EventID {
+		t.Fatalf("published %v, want both events in order", published)
+	}
+	due, _ := db.DueOutboxEvents(context.Background(), maxTime, 10)
+	if len(due) != 0 {
+		t.Errorf("%d events still due after publishing", len(due))
+	}
+}
+
+func TestRelayRetriesFailures(t *testing.T) {
+	db := memory.NewDatabase(nil)
+	sink := NewMemorySink()
+	sink.Err = errors.New("stream is down")
+	addEvent(t, db, "a@example.com")
+
+	before := time.Now()
+	relayBatch(t, NewRelay(db, sink, zap.NewNop(), testConfig))
+
+	due, _ := db.DueOutboxEvents(context.Background(), maxTime, 10)
+	if len(due) != 1 {
+		t.Fatalf("%d events due, want the failed one", len(due))
+	}
+	event := due[0]
+	if event.Attempts != 1 || event.LastError != "stream is down" {
+		t.Errorf("attempts = %d, last error = %q", event.Attempts, event.LastError)
+	}
+	if wait := event.NextAttemptAt.Sub(before); wait < minBackoff || wait > testConfig.Lease {
+		t.Errorf("next attempt in %v, want the backoff", wait)
+	}
+}
+
+// sinkFunc publishes by calling a function.
+type sinkFunc func(ctx context.Context, event Envelope) error
+
+func (f sinkFunc) Publish(ctx context.Context, event Envelope) error {
+	return f(ctx, event)
+}
+
+func TestRelayPublishesOutsideTheTransaction(t *testing.T) {
+	db := memory.NewDatabase(nil)
+	addEvent(t, db, "a@example.com")
+	cfg := testConfig
+	var claimedByOther int
+	sink := sinkFunc(func(ctx context.Context, event Envelope) error {
+		// with the database held this would deadlock
+		other := NewRelay(db, NewMemorySink(), zap.NewNop(), cfg)
+		n, err := other.RelayBatch(ctx)
+		claimedByOther = n
+		return err
+	})
+
+	if n := relayBatch(t, NewRelay(db, sink, zap.NewNop(), cfg)); n != 1 {
+		t.Fatalf("relayed %d events, want 1", n)
+	}
+	if claimedByOther != 0 {
+		t.Errorf("a second relay took %d leased events, want none", claimedByOther)
+	}
+}
+
+func TestRelayLeaseExpires(t *testing.T) {
+	db := memory.NewDatabase(nil)
+	addEvent(t, db, "a@example.com")
+	r := NewRelay(db, NewMemorySink(), zap.NewNop(), testConfig)
+	if _, err := r.claim(context.Background()); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	// the relay died before publishing
+	if due, _ := db.DueOutboxEvents(context.Background(), time.Now(), 10); len(due) != 0 {
+		t.Fatal("a leased event is due")
+	}
+	due, _ := db.DueOutboxEvents(context.Background(), time.Now().Add(testConfig.Lease+time.Second), 10)
+	if len(due) != 1 || due[0].PublishedAt != nil {
+		t.Fatal("the event did not fall due after the lease")
+	}
+}
+
+// notifySink is a TxSink that queues a notification per event.
+type notifySink struct {
+	err error
+}
+
+func (s *notifySink) Publish(ctx context.Context, event Envelope) error {
+	return errors.New("only PublishTx is used")
+}
+
+func (s *notifySink) PublishTx(ctx context.Context, tx database.Database, event Envelope) error {
+	if err := tx.AddNotification(ctx, &models.Notification{To: "ops@example.com", Template: event.Type, Status: models.NotificationPending}); err != nil {
+		return err
+	}
+	return s.err
+}
+
+func TestRelayTxSinkCommitsWithTheEvent(t *testing.T) {
+	db := memory.NewDatabase(nil)
+	addEvent(t, db, "a@example.com")
+	remote := NewMemorySink()
+	local := &notifySink{err: errors.New("constraint violated")}
+	r := NewRelay(db, Fanout(remote, local), zap.NewNop(), testConfig)
+
+	relayBatch(t, r)
+	queued, _ := db.DueNotifications(context.Background(), maxTime, 10)
+	if len(queued) != 0 {
+		t.Error("the failed TxSink's write was committed")
+	}
+	due, _ := db.DueOutboxEvents(context.Background(), maxTime, 10)
+	if len(due) != 1 || due[0].LastError != "constraint violated" {
+		t.Fatalf("due = %v, want the event to be retried", due)
+	}
+
+	local.err = nil
+	if err := r.relay(context.Background(), &due[0]); err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+	queued, _ = db.DueNotifications(context.Background(), maxTime, 10)
+	if len(queued) != 1 {
+		t.Errorf("queued %d notifications, want 1", len(queued))
+	}
+	if due, _ := db.DueOutboxEvents(context.Background(), maxTime, 10); len(due) != 0 {
+		t.Error("the event is still due")
+	}
+	if len(remote.Events()) != 2 {
+		t.Errorf("the remote sink got %d events, want the event twice", len(remote.Events()))
+	}
+}
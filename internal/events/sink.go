@@ -0,0 +1,121 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+
+	"go.uber.org/zap"
+)
+
+// Sink delivers envelopes to the outside world. Publish returning nil means
+// the event was accepted and won't be sent again.
+type Sink interface {
+	Publish(ctx context.Context, event Envelope) error
+}
+
+// TxSink is a Sink that writes to our own database. The relay hands it its
+// transaction, so whatever it writes commits together with the event being
+// marked published.
+type TxSink interface {
+	Sink
+	PublishTx(ctx context.Context, tx database.Database, event Envelope) error
+}
+
+// split sorts sink, and the sinks of a Fanout, into the ones that publish
+// outside our database and the TxSinks.
+func split(sink Sink) (remote []Sink, local []TxSink) {
+	switch sink := sink.(type) {
+	case fanout:
+		for _, inner := range sink {
+			r, l := split(inner)
+			remote = append(remote, r...)
+			local = append(local, l...)
+		}
+	case TxSink:
+		local = append(local, sink)
+	default:
+		remote = append(remote, sink)
+	}
+	return remote, local
+}
+
+func publish(ctx context.Context, sink Sink, tx database.Database, event Envelope) error {
+	if txSink, ok := sink.(TxSink); ok {
+		return txSink.PublishTx(ctx, tx, event)
+	}
+	return sink.Publish(ctx, event)
+}
+
+// Fanout publishes every event to all sinks. If any of them fails the event
+// is retried on all of them, which the at least once contract allows.
+func Fanout(sinks ...Sink) Sink {
+	return fanout(sinks)
+}
+
+type fanout []Sink
+
+func (f fanout) Publish(ctx context.Context, event Envelope) error {
+	var errs []error
+	for _, sink := range f {
+		if err := sink.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) PublishTx(ctx context.Context, tx database.Database, event Envelope) error {
+	var errs []error
+	for _, sink := range f {
+		if err := publish(ctx, sink, tx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LogSink only logs events. It is the default when no real sink is set up.
+type LogSink struct {
+	log *zap.Logger
+}
+
+func NewLogSink(log *zap.Logger) *LogSink {
+	return &LogSink{log: log}
+}
+
+func (s *LogSink) Publish(ctx context.Context, event Envelope) error {
+	s.log.Info("event published", zap.String("event_id", event.ID), zap.String("type", event.Type))
+	return nil
+}
+
+// MemorySink keeps published events in memory, for tests and local runs.
+// Setting Err makes every Publish fail with it.
+type MemorySink struct {
+	mu     sync.Mutex
+	events []Envelope
+	Err    error
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Publish(ctx context.Context, event Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+// Events returns everything published so far.
+func (s *MemorySink) Events() []Envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Envelope(nil), s.events...)
+}
@@ -0,0 +1,194 @@
+// Package geoip resolves IP addresses to a country, city and autonomous
+// system using local MaxMind format (mmdb) files, such as GeoLite2-City and
+// GeoLite2-ASN. Nothing is looked up over the network.
+package geoip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/oschwald/maxminddb-golang"
+	"go.uber.org/zap"
+)
+
+// Location is what is known about an address. Fields that could not be
+// resolved are empty.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code.
+	Country string
+	City    string
+	ASN     uint
+	ASOrg   string
+}
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB looks addresses up in the configured files and picks up new versions
+// of them while running, see Watch. A nil *DB resolves nothing.
+type DB struct {
+	log  *zap.Logger
+	city *file
+	asn  *file
+}
+
+// file is one mmdb file and the version of it that is loaded.
+type file struct {
+	path string
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+// Open loads the files named in cfg. Either may be empty, a country-only
+// database works as the city one too.
+func Open(log *zap.Logger, cfg config.GeoIPConfig) (*DB, error) {
+	db := &DB{log: log}
+	var err error
+	if cfg.CityDB != "" {
+		if db.city, err = openFile(cfg.CityDB); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ASNDB != "" {
+		if db.asn, err = openFile(cfg.ASNDB); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+func openFile(path string) (*file, error) {
+	f := &file{path: path}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Lookup resolves ip, which may carry a port.
+func (db *DB) Lookup(ip string) Location {
+	var loc Location
+	if db == nil {
+		return loc
+	}
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return loc
+	}
+	var city cityRecord
+	if db.city.lookup(addr, &city) {
+		loc.Country = city.Country.ISOCode
+		loc.City = city.City.Names["en"]
+	}
+	var asn asnRecord
+	if db.asn.lookup(addr, &asn) {
+		loc.ASN = asn.Number
+		loc.ASOrg = asn.Organization
+	}
+	return loc
+}
+
+func (f *file) lookup(ip net.IP, result any) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.reader != nil && f.reader.Lookup(ip, result) == nil
+}
+
+// Watch checks the files every interval and loads the ones that changed,
+// until ctx is done. A file that fails to load keeps the previous version.
+func (db *DB) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, f := range []*file{db.city, db.asn} {
+			if f == nil {
+				continue
+			}
+			reloaded, err := f.reload()
+			if err != nil {
+				db.log.Error("failed to reload geoip database", zap.String("path", f.path), zap.Error(err))
+				continue
+			}
+			if reloaded {
+				db.log.Info("geoip database reloaded", zap.String("path", f.path))
+			}
+		}
+	}
+}
+
+// reload opens the file again if its size or modification time changed.
+func (f *file) reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	f.mu.RLock()
+	unchanged := f.reader != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size
+	f.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	reader, err := maxminddb.Open(f.path)
+	if err != nil {
+		return false, err
+	}
+	f.mu.Lock()
+	old := f.reader
+	f.reader, f.modTime, f.size = reader, info.ModTime(), info.Size()
+	f.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return true, nil
+}
+
+// Close releases the files.
+func (db *DB) Close() error {
+	if db == nil {
+		return nil
+	}
+	var errs []error
+	for _, f := range []*file{db.city, db.asn} {
+		if f == nil {
+			continue
+		}
+		f.mu.Lock()
+		if f.reader != nil {
+			errs = append(errs, f.reader.Close())
+			f.reader = nil
+		}
+		f.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,32 @@
+package geoip
+
+// Policy decides which countries may sign in or sign up.
+type Policy struct {
+	allow        map[string]bool
+	deny         map[string]bool
+	blockUnknown bool
+}
+
+// NewPolicy builds a Policy. An empty allow list allows every country not
+// in deny.
+func NewPolicy(allow, deny []string, blockUnknown bool) Policy {
+	p := Policy{allow: map[string]bool{}, deny: map[string]bool{}, blockUnknown: blockUnknown}
+	for _, country := range allow {
+		p.allow[country] = true
+	}
+	for _, country := range deny {
+		p.deny[country] = true
+	}
+	return p
+}
+
+// Allowed reports whether country, an ISO code or "" when unknown, may pass.
+func (p Policy) Allowed(country string) bool {
+	if country == "" {
+		return !p.blockUnknown
+	}
+	if p.deny[country] {
+		return false
+	}
+	return len(p.allow) == 0 || p.allow[country]
+}
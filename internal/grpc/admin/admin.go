@@ -0,0 +1,36 @@
+// Package grpcadmin serves the AdminAuth gRPC service, which lets
+// administrators manage user accounts. Every call is made with the session
+// token of an administrator, see authorize.
+package grpcadmin
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+type Admin interface {
+	CheckPermission(ctx context.Context, token, permission string) (allowed bool, session storage.Session, err error)
+	ListUsers(ctx context.Context, filter database.UserFilter, pageToken string) (users []models.User, nextPageToken string, err error)
+	GetUser(ctx context.Context, email string) (user models.User, roles []string, err error)
+	DisableUser(ctx context.Context, email, reason string, until time.Time) error
+	BanUser(ctx context.Context, email, reason string, until time.Time) error
+	EnableUser(ctx context.Context, email string) error
+	ForceLogout(ctx context.Context, email string) error
+	SetEmailVerified(ctx context.Context, email string, verified bool) error
+	DeleteUser(ctx context.Context, email string) error
+	RequirePasswordReset(ctx context.Context, email string) error
+	AssignRole(ctx context.Context, email, role string) error
+	RevokeRole(ctx context.Context, email, role string) error
+	ListAuditEvents(ctx context.Context, filter database.AuthEventFilter, pageToken string) (events []models.AuthEvent, nextPageToken string, err error)
+	CreateWebhook(ctx context.Context, url string, eventTypes []string) (models.WebhookSubscription, error)
+	ListWebhooks(ctx context.Context) ([]models.WebhookSubscription, error)
+	UpdateWebhook(ctx context.Context, id int64, url string, eventTypes []string, active, rotateSecret bool) (models.WebhookSubscription, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	ListWebhookDeliveries(ctx context.Context, filter database.WebhookDeliveryFilter, pageToken string) (deliveries []models.WebhookDelivery, nextPageToken string, err error)
+	WebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, []models.WebhookAttempt, error)
+	RedeliverWebhook(ctx context.Context, id int64) error
+}
@@ -0,0 +1,71 @@
+//go:build protosnext
+
+package grpcadmin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListAuditEvents pages through the security audit log. It shows other
+// users' emails, IPs and locations, so it needs users.read.
+func (s *serverAPI) ListAuditEvents(ctx context.Context, req *authv1.ListAuditEventsRequest) (*authv1.ListAuditEventsResponse, error) {
+	ctx, err := s.authorize(ctx, permUsersRead)
+	if err != nil {
+		return nil, err
+	}
+	if req.PageSize < 0 || (req.Since != 0 && req.Until != 0 && req.Since >= req.Until) {
+		return nil, status.Error(codes.InvalidArgument, "invalid filter")
+	}
+	filter := database.AuthEventFilter{
+		Subject: req.Subject,
+		Type:    req.Type,
+		Limit:   int(req.PageSize),
+	}
+	if req.Since != 0 {
+		filter.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until != 0 {
+		filter.Until = time.Unix(req.Until, 0)
+	}
+	events, next, err := s.admin.ListAuditEvents(ctx, filter, req.PageToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		return nil, status.Error(codes.Internal, "failed to list audit events")
+	}
+	resp := &authv1.ListAuditEventsResponse{
+		Events:        make([]*authv1.AuditEvent, len(events)),
+		NextPageToken: next,
+	}
+	for i, event := range events {
+		resp.Events[i] = toAuditEvent(event)
+	}
+	return resp, nil
+}
+
+func toAuditEvent(event models.AuthEvent) *authv1.AuditEvent {
+	return &authv1.AuditEvent{
+		Id:        event.ID,
+		Type:      event.Type,
+		Actor:     event.Actor,
+		Subject:   event.Subject,
+		IP:        event.IP,
+		Country:   event.Country,
+		City:      event.City,
+		Asn:       uint32(event.ASN),
+		UserAgent: event.UserAgent,
+		Result:    event.Result,
+		Reason:    event.Reason,
+		CreatedAt: event.CreatedAt.Unix(),
+	}
+}
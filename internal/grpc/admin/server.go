@@ -0,0 +1,302 @@
+//go:build protosnext
+
+package grpcadmin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Permissions the calls require, granted through the roles of RBACConfig.
+const (
+	permUsersRead      = "users.read"
+	permUsersWrite     = "users.write"
+	permRolesManage    = "roles.manage"
+	permWebhooksManage = "webhooks.manage"
+)
+
+type serverAPI struct {
+	authv1.UnimplementedAdminAuthServer
+	admin Admin
+}
+
+func RegisterAdminServer(gRPC *grpc.Server, admin Admin) {
+	authv1.RegisterAdminAuthServer(gRPC, &serverAPI{admin: admin})
+}
+
+func (s *serverAPI) ListUsers(ctx context.Context, req *authv1.ListUsersRequest) (*authv1.ListUsersResponse, error) {
+	ctx, err := s.authorize(ctx, permUsersRead)
+	if err != nil {
+		return nil, err
+	}
+	if req.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page size")
+	}
+	filter := database.UserFilter{
+		EmailPrefix: strings.ToLower(strings.TrimSpace(req.EmailPrefix)),
+		Verified:    req.Verified,
+		Limit:       int(req.PageSize),
+	}
+	if req.CreatedAfter > 0 {
+		filter.CreatedAfter = time.Unix(req.CreatedAfter, 0)
+	}
+	if req.CreatedBefore > 0 {
+		filter.CreatedBefore = time.Unix(req.CreatedBefore, 0)
+	}
+	users, next, err := s.admin.ListUsers(ctx, filter, req.PageToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		return nil, status.Error(codes.Internal, "failed to list users")
+	}
+	resp := &authv1.ListUsersResponse{
+		Users:         make([]*authv1.User, len(users)),
+		NextPageToken: next,
+	}
+	for i, user := range users {
+		resp.Users[i] = toUser(user, nil)
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) GetUser(ctx context.Context, req *authv1.GetUserRequest) (*authv1.GetUserResponse, error) {
+	ctx, email, err := s.authorizeFor(ctx, permUsersRead, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	user, roles, err := s.admin.GetUser(ctx, email)
+	if err != nil {
+		return nil, userError(err, "failed to get user")
+	}
+	return &authv1.GetUserResponse{User: toUser(user, roles)}, nil
+}
+
+func (s *serverAPI) DisableUser(ctx context.Context, req *authv1.DisableUserRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permUsersWrite, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	until, err := statusUntil(req.Until)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.DisableUser(ctx, email, req.Reason, until); err != nil {
+		return nil, userError(err, "failed to disable user")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// BanUser is DisableUser for abuse. Clients are told the account is banned
+// rather than disabled.
+func (s *serverAPI) BanUser(ctx context.Context, req *authv1.BanUserRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permUsersWrite, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	until, err := statusUntil(req.Until)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.BanUser(ctx, email, req.Reason, until); err != nil {
+		return nil, userError(err, "failed to ban user")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// statusUntil reads the end of a temporary status, zero means none.
+func statusUntil(unix int64) (time.Time, error) {
+	if unix == 0 {
+		return time.Time{}, nil
+	}
+	until := time.Unix(unix, 0)
+	if unix < 0 || !until.After(time.Now()) {
+		return time.Time{}, status.Error(codes.InvalidArgument, "until must be in the future")
+	}
+	return until, nil
+}
+
+func (s *serverAPI) EnableUser(ctx context.Context, req *authv1.EnableUserRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permUsersWrite, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.EnableUser(ctx, email); err != nil {
+		return nil, userError(err, "failed to enable user")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ForceLogout(ctx context.Context, req *authv1.ForceLogoutRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permUsersWrite, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.ForceLogout(ctx, email); err != nil {
+		return nil, userError(err, "failed to revoke sessions")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) SetEmailVerified(ctx context.Context, req *authv1.SetEmailVerifiedRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permUsersWrite, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.SetEmailVerified(ctx, email, req.Verified); err != nil {
+		return nil, userError(err, "failed to set email verified")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) DeleteUser(ctx context.Context, req *authv1.DeleteUserRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permUsersWrite, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.DeleteUser(ctx, email); err != nil {
+		return nil, userError(err, "failed to delete user")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// RequirePasswordReset makes the user choose a new password at the next
+// sign-in and ends their sessions.
+func (s *serverAPI) RequirePasswordReset(ctx context.Context, req *authv1.RequirePasswordResetRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permUsersWrite, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.RequirePasswordReset(ctx, email); err != nil {
+		return nil, userError(err, "failed to require password reset")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// AssignRole and RevokeRole need a permission of their own, with
+// users.write alone an administrator could grant themselves more.
+func (s *serverAPI) AssignRole(ctx context.Context, req *authv1.AssignRoleRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permRolesManage, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if req.Role == "" {
+		return nil, status.Error(codes.InvalidArgument, "role is required")
+	}
+	if err := s.admin.AssignRole(ctx, email, req.Role); err != nil {
+		return nil, roleError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) RevokeRole(ctx context.Context, req *authv1.RevokeRoleRequest) (*emptypb.Empty, error) {
+	ctx, email, err := s.authorizeFor(ctx, permRolesManage, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	if req.Role == "" {
+		return nil, status.Error(codes.InvalidArgument, "role is required")
+	}
+	if err := s.admin.RevokeRole(ctx, email, req.Role); err != nil {
+		return nil, roleError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// authorize checks that the caller's session, sent as a bearer token in the
+// authorization header, has permission. The returned context names the
+// administrator as the actor in the audit log.
+func (s *serverAPI) authorize(ctx context.Context, permission string) (context.Context, error) {
+	token := bearerToken(ctx)
+	if token == "" {
+		return ctx, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	allowed, session, err := s.admin.CheckPermission(ctx, token, permission)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return ctx, status.Error(codes.Unauthenticated, "invalid or expired token")
+		}
+		return ctx, status.Error(codes.Internal, "failed to check permission")
+	}
+	if !allowed {
+		return ctx, status.Error(codes.PermissionDenied, "missing permission "+permission)
+	}
+	return auth.WithActor(ctx, session.Email), nil
+}
+
+// authorizeFor is authorize for calls about one user, it also normalizes
+// their email.
+func (s *serverAPI) authorizeFor(ctx context.Context, permission, email string) (context.Context, string, error) {
+	ctx, err := s.authorize(ctx, permission)
+	if err != nil {
+		return ctx, "", err
+	}
+	normalized, err := mailaddr.Normalize(email)
+	if err != nil {
+		return ctx, "", status.Error(codes.InvalidArgument, "invalid email")
+	}
+	return ctx, normalized, nil
+}
+
+func bearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, value := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
+
+func userError(err error, msg string) error {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return status.Error(codes.NotFound, "user not found")
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+func roleError(err error) error {
+	if errors.Is(err, storage.ErrRoleNotFound) {
+		return status.Error(codes.NotFound, "role not found")
+	}
+	return userError(err, "failed to change role")
+}
+
+// toUser reports the status in effect, a suspension that ran out shows as
+// active.
+func toUser(user models.User, roles []string) *authv1.User {
+	u := &authv1.User{
+		Id:            int64(user.ID),
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Status:        user.StatusAt(time.Now()),
+		Roles:         roles,
+		LastLoginIP:   user.LastLoginIp,
+		LastLoginAt:   user.LastLoginDate.Unix(),
+		CreatedAt:     user.CreatedAt.Unix(),
+	}
+	if u.Status != models.UserActive {
+		u.StatusReason = user.StatusReason
+		if user.StatusUntil != nil {
+			u.StatusUntil = user.StatusUntil.Unix()
+		}
+	}
+	return u
+}
@@ -0,0 +1,252 @@
+//go:build protosnext
+
+package grpcadmin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const testPassword = "correct horse battery staple 9"
+
+// fixture is the AdminAuth service on in-memory storage, served over
+// bufconn. Every role of testRoles has an administrator signed in, named
+// after the role.
+type fixture struct {
+	client authv1.AdminAuthClient
+	auth   *auth.Auth
+	tokens map[string]string
+}
+
+var testRoles = map[string]config.RoleConfig{
+	"support":  {Permissions: []string{permUsersRead}},
+	"operator": {Permissions: []string{permUsersRead, permUsersWrite}},
+	"platform": {Permissions: []string{permWebhooksManage}},
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	log := zap.NewNop()
+	db := memory.NewDatabase(nil)
+	svc := auth.New(log, db, memory.NewRedis(db, log, nil), memory.NewSessions(nil), time.Hour, 24*time.Hour)
+	ctx := context.Background()
+	f := &fixture{auth: svc, tokens: map[string]string{}}
+	grants := map[string][]string{}
+	for role := range testRoles {
+		email := role + "@example.com"
+		if _, _, err := svc.Register(ctx, email, testPassword, "192.0.2.1", ""); err != nil {
+			t.Fatal(err)
+		}
+		grants[role] = []string{email}
+	}
+	if err := svc.SeedRoles(ctx, config.RBACConfig{Roles: testRoles, Grants: grants}); err != nil {
+		t.Fatal(err)
+	}
+	for role := range testRoles {
+		token, _, err := svc.Login(ctx, role+"@example.com", testPassword, "192.0.2.1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.tokens[role] = token
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	RegisterAdminServer(srv, svc)
+	go srv.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+	f.client = authv1.NewAdminAuthClient(conn)
+	return f
+}
+
+// as returns a context calling as the administrator of role, or without a
+// bearer token for "".
+func (f *fixture) as(role string) context.Context {
+	if role == "" {
+		return context.Background()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+f.tokens[role])
+}
+
+func wantCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Errorf("code = %v, want %v (%v)", got, want, err)
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		name string
+		role string
+		req  *authv1.ListAuditEventsRequest
+		want codes.Code
+	}{
+		{"all", "support", &authv1.ListAuditEventsRequest{}, codes.OK},
+		{"by subject", "support", &authv1.ListAuditEventsRequest{Subject: "operator@example.com"}, codes.OK},
+		{"negative page size", "support", &authv1.ListAuditEventsRequest{PageSize: -1}, codes.InvalidArgument},
+		{"empty range", "support", &authv1.ListAuditEventsRequest{Since: 200, Until: 100}, codes.InvalidArgument},
+		{"bad page token", "support", &authv1.ListAuditEventsRequest{PageToken: "nope"}, codes.InvalidArgument},
+		{"without users.read", "platform", &authv1.ListAuditEventsRequest{}, codes.PermissionDenied},
+		{"without a token", "", &authv1.ListAuditEventsRequest{}, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.ListAuditEvents(f.as(tt.role), tt.req)
+			wantCode(t, err, tt.want)
+			if err != nil {
+				return
+			}
+			if len(resp.Events) == 0 {
+				t.Fatal("no events")
+			}
+			for _, event := range resp.Events {
+				if tt.req.Subject != "" && event.Subject != tt.req.Subject {
+					t.Errorf("event of %q, want only %q", event.Subject, tt.req.Subject)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	f := newFixture(t)
+	ctx := f.as("platform")
+	refused := []struct {
+		role string
+		want codes.Code
+	}{
+		{"", codes.Unauthenticated},
+		{"operator", codes.PermissionDenied},
+	}
+	for _, tt := range refused {
+		_, err := f.client.CreateWebhook(f.as(tt.role), &authv1.CreateWebhookRequest{Url: "https://partner.example.com/hook"})
+		wantCode(t, err, tt.want)
+		_, err = f.client.ListWebhooks(f.as(tt.role), &emptypb.Empty{})
+		wantCode(t, err, tt.want)
+	}
+
+	creates := []struct {
+		name string
+		req  *authv1.CreateWebhookRequest
+		want codes.Code
+	}{
+		{"valid", &authv1.CreateWebhookRequest{Url: "https://partner.example.com/hook", EventTypes: []string{events.TypeUserRegistered}}, codes.OK},
+		{"plain http", &authv1.CreateWebhookRequest{Url: "http://partner.example.com/hook"}, codes.InvalidArgument},
+		{"relative url", &authv1.CreateWebhookRequest{Url: "/hook"}, codes.InvalidArgument},
+		{"unknown event type", &authv1.CreateWebhookRequest{Url: "https://partner.example.com/hook", EventTypes: []string{"user.renamed"}}, codes.InvalidArgument},
+	}
+	var id int64
+	for _, tt := range creates {
+		t.Run("create/"+tt.name, func(t *testing.T) {
+			resp, err := f.client.CreateWebhook(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil {
+				if resp.Secret == "" || !resp.Webhook.Active {
+					t.Errorf("response = %+v, want an active webhook and its secret", resp)
+				}
+				id = resp.Webhook.Id
+			}
+		})
+	}
+
+	list, err := f.client.ListWebhooks(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Webhooks) != 1 || list.Webhooks[0].Id != id {
+		t.Fatalf("ListWebhooks = %+v, want the created webhook", list.Webhooks)
+	}
+
+	updates := []struct {
+		name string
+		req  *authv1.UpdateWebhookRequest
+		want codes.Code
+	}{
+		{"rotate secret", &authv1.UpdateWebhookRequest{Id: id, Url: "https://partner.example.com/v2", Active: true, RotateSecret: true}, codes.OK},
+		{"unknown id", &authv1.UpdateWebhookRequest{Id: id + 100, Url: "https://partner.example.com/v2", Active: true}, codes.NotFound},
+		{"plain http", &authv1.UpdateWebhookRequest{Id: id, Url: "http://partner.example.com/v2", Active: true}, codes.InvalidArgument},
+	}
+	for _, tt := range updates {
+		t.Run("update/"+tt.name, func(t *testing.T) {
+			resp, err := f.client.UpdateWebhook(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && (resp.Secret == "" || resp.Webhook.Url != tt.req.Url) {
+				t.Errorf("response = %+v, want the new url and secret", resp)
+			}
+		})
+	}
+
+	_, err = f.client.ListWebhookDeliveries(ctx, &authv1.ListWebhookDeliveriesRequest{PageSize: -1})
+	wantCode(t, err, codes.InvalidArgument)
+	_, err = f.client.GetWebhookDelivery(ctx, &authv1.GetWebhookDeliveryRequest{Id: 404})
+	wantCode(t, err, codes.NotFound)
+	_, err = f.client.RedeliverWebhook(ctx, &authv1.RedeliverWebhookRequest{Id: 404})
+	wantCode(t, err, codes.NotFound)
+
+	_, err = f.client.DeleteWebhook(ctx, &authv1.DeleteWebhookRequest{Id: id})
+	wantCode(t, err, codes.OK)
+	_, err = f.client.DeleteWebhook(ctx, &authv1.DeleteWebhookRequest{Id: id})
+	wantCode(t, err, codes.NotFound)
+}
+
+func TestRequirePasswordReset(t *testing.T) {
+	f := newFixture(t)
+	if _, _, err := f.auth.Register(context.Background(), "jane@example.com", testPassword, "192.0.2.1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		role  string
+		email string
+		want  codes.Code
+	}{
+		{"without a token", "", "jane@example.com", codes.Unauthenticated},
+		{"without users.write", "support", "jane@example.com", codes.PermissionDenied},
+		{"malformed email", "operator", "jane", codes.InvalidArgument},
+		{"unknown user", "operator", "ann@example.com", codes.NotFound},
+		{"known user", "operator", "Jane@Example.com", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.client.RequirePasswordReset(f.as(tt.role), &authv1.RequirePasswordResetRequest{Email: tt.email})
+			wantCode(t, err, tt.want)
+		})
+	}
+	_, _, err := f.auth.Login(context.Background(), "jane@example.com", testPassword, "192.0.2.1", "")
+	if !errors.Is(err, auth.ErrPasswordResetRequired) {
+		t.Errorf("Login after the reset was required = %v, want ErrPasswordResetRequired", err)
+	}
+}
@@ -0,0 +1,182 @@
+//go:build protosnext
+
+package grpcadmin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// The webhook RPCs manage partner subscriptions and need webhooks.manage,
+// whoever can create one receives the events of every account.
+
+func (s *serverAPI) CreateWebhook(ctx context.Context, req *authv1.CreateWebhookRequest) (*authv1.CreateWebhookResponse, error) {
+	ctx, err := s.authorize(ctx, permWebhooksManage)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := s.admin.CreateWebhook(ctx, req.Url, req.EventTypes)
+	if err != nil {
+		return nil, webhookError(err, "failed to create webhook")
+	}
+	return &authv1.CreateWebhookResponse{Webhook: toWebhook(sub), Secret: sub.Secret}, nil
+}
+
+func (s *serverAPI) ListWebhooks(ctx context.Context, _ *emptypb.Empty) (*authv1.ListWebhooksResponse, error) {
+	ctx, err := s.authorize(ctx, permWebhooksManage)
+	if err != nil {
+		return nil, err
+	}
+	subs, err := s.admin.ListWebhooks(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to list webhooks")
+	}
+	resp := &authv1.ListWebhooksResponse{Webhooks: make([]*authv1.Webhook, len(subs))}
+	for i, sub := range subs {
+		resp.Webhooks[i] = toWebhook(sub)
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) UpdateWebhook(ctx context.Context, req *authv1.UpdateWebhookRequest) (*authv1.UpdateWebhookResponse, error) {
+	ctx, err := s.authorize(ctx, permWebhooksManage)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := s.admin.UpdateWebhook(ctx, req.Id, req.Url, req.EventTypes, req.Active, req.RotateSecret)
+	if err != nil {
+		return nil, webhookError(err, "failed to update webhook")
+	}
+	resp := &authv1.UpdateWebhookResponse{Webhook: toWebhook(sub)}
+	if req.RotateSecret {
+		resp.Secret = sub.Secret
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) DeleteWebhook(ctx context.Context, req *authv1.DeleteWebhookRequest) (*emptypb.Empty, error) {
+	ctx, err := s.authorize(ctx, permWebhooksManage)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.DeleteWebhook(ctx, req.Id); err != nil {
+		return nil, webhookError(err, "failed to delete webhook")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ListWebhookDeliveries(ctx context.Context, req *authv1.ListWebhookDeliveriesRequest) (*authv1.ListWebhookDeliveriesResponse, error) {
+	ctx, err := s.authorize(ctx, permWebhooksManage)
+	if err != nil {
+		return nil, err
+	}
+	if req.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page size")
+	}
+	filter := database.WebhookDeliveryFilter{
+		SubscriptionID: req.WebhookId,
+		Status:         req.Status,
+		Limit:          int(req.PageSize),
+	}
+	deliveries, next, err := s.admin.ListWebhookDeliveries(ctx, filter, req.PageToken)
+	if err != nil {
+		return nil, webhookError(err, "failed to list webhook deliveries")
+	}
+	resp := &authv1.ListWebhookDeliveriesResponse{
+		Deliveries:    make([]*authv1.WebhookDelivery, len(deliveries)),
+		NextPageToken: next,
+	}
+	for i, delivery := range deliveries {
+		resp.Deliveries[i] = toWebhookDelivery(delivery)
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) GetWebhookDelivery(ctx context.Context, req *authv1.GetWebhookDeliveryRequest) (*authv1.GetWebhookDeliveryResponse, error) {
+	ctx, err := s.authorize(ctx, permWebhooksManage)
+	if err != nil {
+		return nil, err
+	}
+	delivery, attempts, err := s.admin.WebhookDelivery(ctx, req.Id)
+	if err != nil {
+		return nil, webhookError(err, "failed to get webhook delivery")
+	}
+	resp := &authv1.GetWebhookDeliveryResponse{
+		Delivery: toWebhookDelivery(delivery),
+		Attempts: make([]*authv1.WebhookAttempt, len(attempts)),
+	}
+	for i, attempt := range attempts {
+		resp.Attempts[i] = &authv1.WebhookAttempt{
+			StatusCode: int32(attempt.StatusCode),
+			Error:      attempt.Error,
+			DurationMs: attempt.DurationMs,
+			CreatedAt:  attempt.CreatedAt.Unix(),
+		}
+	}
+	return resp, nil
+}
+
+func (s *serverAPI) RedeliverWebhook(ctx context.Context, req *authv1.RedeliverWebhookRequest) (*emptypb.Empty, error) {
+	ctx, err := s.authorize(ctx, permWebhooksManage)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.admin.RedeliverWebhook(ctx, req.Id); err != nil {
+		return nil, webhookError(err, "failed to redeliver webhook")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func webhookError(err error, msg string) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidWebhook):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, auth.ErrInvalidPageToken):
+		return status.Error(codes.InvalidArgument, "invalid page token")
+	case errors.Is(err, storage.ErrWebhookNotFound):
+		return status.Error(codes.NotFound, "webhook not found")
+	case errors.Is(err, storage.ErrDeliveryNotFound):
+		return status.Error(codes.NotFound, "webhook delivery not found")
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+func toWebhook(sub models.WebhookSubscription) *authv1.Webhook {
+	return &authv1.Webhook{
+		Id:                  sub.ID,
+		Url:                 sub.URL,
+		EventTypes:          sub.EventTypes,
+		Active:              sub.Active,
+		ConsecutiveFailures: int32(sub.ConsecutiveFailures),
+		DisabledReason:      sub.DisabledReason,
+		CreatedAt:           sub.CreatedAt.Unix(),
+	}
+}
+
+func toWebhookDelivery(delivery models.WebhookDelivery) *authv1.WebhookDelivery {
+	d := &authv1.WebhookDelivery{
+		Id:             delivery.ID,
+		WebhookId:      delivery.SubscriptionID,
+		EventId:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       int32(delivery.Attempts),
+		LastStatusCode: int32(delivery.LastStatusCode),
+		LastError:      delivery.LastError,
+		NextAttemptAt:  delivery.NextAttemptAt.Unix(),
+		CreatedAt:      delivery.CreatedAt.Unix(),
+	}
+	if delivery.DeliveredAt != nil {
+		d.DeliveredAt = delivery.DeliveredAt.Unix()
+	}
+	return d
+}
@@ -0,0 +1,77 @@
+//go:build protosnext
+
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// DeleteAccount closes the account of the session token. Users with a
+// password confirm with it, others need a recent sign-in and get
+// FailedPrecondition otherwise. The account is purged at PurgeAt unless the
+// emailed link is followed before.
+func (s *serverAPI) DeleteAccount(ctx context.Context, req *authv1.DeleteAccountRequest) (*authv1.DeleteAccountResponse, error) {
+	if req.Token == "" || len(req.Password) > maxPasswordBytes {
+		return nil, status.Error(codes.InvalidArgument, "invalid token or password")
+	}
+	purgeAt, err := s.auth.DeleteAccount(ctx, req.Token, req.Password, req.IP)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrSessionNotFound):
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired token")
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			return nil, status.Error(codes.InvalidArgument, "invalid password")
+		case errors.Is(err, auth.ErrReauthRequired):
+			return nil, status.Error(codes.FailedPrecondition, "sign in again to delete the account")
+		}
+		return nil, status.Error(codes.Internal, "failed to delete account")
+	}
+	return &authv1.DeleteAccountResponse{PurgeAt: purgeAt.Unix()}, nil
+}
+
+// CancelAccountDeletion is the target of the restore link sent by
+// DeleteAccount.
+func (s *serverAPI) CancelAccountDeletion(ctx context.Context, req *authv1.CancelAccountDeletionRequest) (*emptypb.Empty, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.auth.CancelAccountDeletion(ctx, req.Token); err != nil {
+		if errors.Is(err, auth.ErrInvalidDeletionToken) {
+			return nil, status.Error(codes.NotFound, "invalid or expired link")
+		}
+		return nil, status.Error(codes.Internal, "failed to restore account")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// ExportUserData returns the data kept about the user of the session token
+// as a zip of JSON files. Users get a few per day and ResourceExhausted
+// after that.
+func (s *serverAPI) ExportUserData(ctx context.Context, req *authv1.ExportUserDataRequest) (*authv1.ExportUserDataResponse, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	archive, err := s.auth.ExportUserData(ctx, req.Token, req.IP)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrSessionNotFound):
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired token")
+		case errors.Is(err, auth.ErrExportRateLimited):
+			return nil, status.Error(codes.ResourceExhausted, "too many data exports, try again later")
+		}
+		return nil, status.Error(codes.Internal, "failed to export data")
+	}
+	return &authv1.ExportUserDataResponse{
+		Archive:  archive,
+		FileName: "account-data-" + time.Now().UTC().Format("2006-01-02") + ".zip",
+	}, nil
+}
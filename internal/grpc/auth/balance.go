@@ -0,0 +1,111 @@
+//go:build protosnext
+
+package grpcauth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The balance RPCs move money for other services, ServiceTokenInterceptor
+// lets only those holding a service token call them. Amounts are in minor
+// units of the currency. Every call carries an idempotency key, a retry
+// with the same key returns the first result without applying it again.
+
+func (s *serverAPI) Credit(ctx context.Context, req *authv1.CreditRequest) (*authv1.CreditResponse, error) {
+	email, err := mailaddr.Normalize(req.Email)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+	tx, err := s.auth.Credit(ctx, email, req.Amount, req.Currency, req.IdempotencyKey, req.Description)
+	if err != nil {
+		return nil, balanceError(err, "failed to credit balance")
+	}
+	return &authv1.CreditResponse{Transaction: toBalanceTransaction(tx, email)}, nil
+}
+
+// Debit fails with FailedPrecondition when the balance is too low.
+func (s *serverAPI) Debit(ctx context.Context, req *authv1.DebitRequest) (*authv1.DebitResponse, error) {
+	email, err := mailaddr.Normalize(req.Email)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+	tx, err := s.auth.Debit(ctx, email, req.Amount, req.Currency, req.IdempotencyKey, req.Description)
+	if err != nil {
+		return nil, balanceError(err, "failed to debit balance")
+	}
+	return &authv1.DebitResponse{Transaction: toBalanceTransaction(tx, email)}, nil
+}
+
+func (s *serverAPI) Transfer(ctx context.Context, req *authv1.TransferRequest) (*authv1.TransferResponse, error) {
+	from, err := mailaddr.Normalize(req.FromEmail)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid from email")
+	}
+	to, err := mailaddr.Normalize(req.ToEmail)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid to email")
+	}
+	debit, credit, err := s.auth.Transfer(ctx, from, to, req.Amount, req.Currency, req.IdempotencyKey, req.Description)
+	if err != nil {
+		return nil, balanceError(err, "failed to transfer")
+	}
+	return &authv1.TransferResponse{
+		Debit:  toBalanceTransaction(debit, from),
+		Credit: toBalanceTransaction(credit, to),
+	}, nil
+}
+
+func (s *serverAPI) GetBalance(ctx context.Context, req *authv1.GetBalanceRequest) (*authv1.GetBalanceResponse, error) {
+	email, err := mailaddr.Normalize(req.Email)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
+	}
+	balance, err := s.auth.GetBalance(ctx, email, req.Currency)
+	if err != nil {
+		return nil, balanceError(err, "failed to get balance")
+	}
+	return &authv1.GetBalanceResponse{Amount: balance.Amount, Currency: balance.Currency}, nil
+}
+
+func balanceError(err error, msg string) error {
+	if statusErr, ok := accountStatusError(err); ok {
+		return statusErr
+	}
+	switch {
+	case errors.Is(err, auth.ErrInvalidAmount), errors.Is(err, auth.ErrInvalidCurrency),
+		errors.Is(err, auth.ErrInvalidIdempotencyKey), errors.Is(err, auth.ErrSelfTransfer):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, auth.ErrInsufficientFunds):
+		return status.Error(codes.FailedPrecondition, "insufficient funds")
+	case errors.Is(err, auth.ErrIdempotencyConflict):
+		return status.Error(codes.AlreadyExists, "idempotency key was used for a different operation")
+	case errors.Is(err, storage.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, storage.ErrSerializationFailure):
+		// still conflicting after the retries, the client can try again
+		return status.Error(codes.Aborted, "concurrent balance update, retry")
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+func toBalanceTransaction(tx models.BalanceTransaction, email string) *authv1.BalanceTransaction {
+	return &authv1.BalanceTransaction{
+		Id:           tx.ID,
+		Email:        email,
+		Amount:       tx.Amount,
+		Currency:     tx.Currency,
+		Kind:         tx.Kind,
+		Description:  tx.Description,
+		BalanceAfter: tx.BalanceAfter,
+		CreatedAt:    tx.CreatedAt.Unix(),
+	}
+}
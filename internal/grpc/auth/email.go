@@ -0,0 +1,33 @@
+package grpcauth
+
+import (
+	"errors"
+
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// emailError turns a rejected address into InvalidArgument with a
+// BadRequest detail for field and an ErrorInfo carrying the reason code.
+// ok is false for other errors.
+func emailError(err error, field string) (_ error, ok bool) {
+	var addrErr *mailaddr.Error
+	if !errors.As(err, &addrErr) {
+		return nil, false
+	}
+	badRequest := &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: addrErr.Message}},
+	}
+	info := &errdetails.ErrorInfo{
+		Reason:   "INVALID_EMAIL",
+		Domain:   "auth",
+		Metadata: map[string]string{"code": addrErr.Code},
+	}
+	st, detailsErr := status.New(codes.InvalidArgument, "invalid email").WithDetails(badRequest, info)
+	if detailsErr != nil {
+		return status.Error(codes.InvalidArgument, "invalid email"), true
+	}
+	return st.Err(), true
+}
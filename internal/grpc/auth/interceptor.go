@@ -0,0 +1,26 @@
+package grpcauth
+
+import (
+	"context"
+
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryServerInterceptor passes the client's user agent on to the service.
+// The frontend forwards the browser's in x-user-agent; direct gRPC clients
+// only have their own user-agent header.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, key := range []string{"x-user-agent", "user-agent"} {
+				if values := md.Get(key); len(values) > 0 && values[0] != "" {
+					ctx = auth.WithUserAgent(ctx, values[0])
+					break
+				}
+			}
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,67 @@
+package grpcauth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/GosMachine/ServiceAuth/internal/password"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxPasswordBytes bounds passwords accepted at login, bcrypt never looks
+// past it.
+const maxPasswordBytes = 72
+
+// passwordPolicyError turns a policy rejection into InvalidArgument with a
+// BadRequest detail carrying a readable message per broken rule and an
+// ErrorInfo listing the rule codes for clients with their own messages.
+// ok is false for other errors.
+func passwordPolicyError(err error) (_ error, ok bool) {
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) {
+		return nil, false
+	}
+	badRequest := &errdetails.BadRequest{}
+	violations := make([]string, len(policyErr.Violations))
+	for i, v := range policyErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "password",
+			Description: v.Message,
+		})
+		violations[i] = v.Code
+	}
+	info := &errdetails.ErrorInfo{
+		Reason:   "PASSWORD_POLICY",
+		Domain:   "auth",
+		Metadata: map[string]string{"violations": strings.Join(violations, ",")},
+	}
+	st, detailsErr := status.New(codes.InvalidArgument, "password does not meet the policy").WithDetails(badRequest, info)
+	if detailsErr != nil {
+		return status.Error(codes.InvalidArgument, "password does not meet the policy"), true
+	}
+	return st.Err(), true
+}
+
+// passwordResetError turns a Login refused for a password that has to be
+// changed into FailedPrecondition with an ErrorInfo carrying the reason and
+// the reset token for ResetPassword.
+func passwordResetError(err *auth.ResetRequiredError) error {
+	info := &errdetails.ErrorInfo{
+		Reason: "PASSWORD_RESET_REQUIRED",
+		Domain: "auth",
+		Metadata: map[string]string{
+			"reason":          err.Reason,
+			"reset_token":     err.Token,
+			"reset_token_ttl": strconv.FormatInt(int64(err.TokenTTL.Minutes()), 10),
+		},
+	}
+	st, detailsErr := status.New(codes.FailedPrecondition, "password reset required").WithDetails(info)
+	if detailsErr != nil {
+		return status.Error(codes.Internal, "failed to login")
+	}
+	return st.Err()
+}
@@ -0,0 +1,69 @@
+//go:build protosnext
+
+package grpcauth
+
+import (
+	"context"
+	"errors"
+
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// ReportLogin is the target of the "this wasn't me" link in new sign-in
+// alerts.
+func (s *serverAPI) ReportLogin(ctx context.Context, req *authv1.ReportLoginRequest) (*emptypb.Empty, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	if err := s.auth.ReportLogin(ctx, req.Token); err != nil {
+		if errors.Is(err, auth.ErrInvalidReportToken) {
+			return nil, status.Error(codes.NotFound, "invalid or expired link")
+		}
+		return nil, status.Error(codes.Internal, "failed to report login")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// ResetPassword sets a new password with the reset token a refused Login
+// handed out.
+func (s *serverAPI) ResetPassword(ctx context.Context, req *authv1.ResetPasswordRequest) (*authv1.ResetPasswordResponse, error) {
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+	token, tokenTTL, err := s.auth.ResetPassword(ctx, req.Token, req.Password, req.IP)
+	if err != nil {
+		if err, ok := passwordPolicyError(err); ok {
+			return nil, err
+		}
+		if errors.Is(err, auth.ErrInvalidResetToken) {
+			return nil, status.Error(codes.NotFound, "invalid or expired reset token")
+		}
+		if err, ok := accountStatusError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+	return &authv1.ResetPasswordResponse{Token: token, TokenTTL: int64(tokenTTL.Minutes())}, nil
+}
+
+// CheckPermission tells whether the session of a token allows a
+// permission. A token that isn't signed in is Unauthenticated, a signed in
+// user without the permission gets Allowed false.
+func (s *serverAPI) CheckPermission(ctx context.Context, req *authv1.CheckPermissionRequest) (*authv1.CheckPermissionResponse, error) {
+	if req.Token == "" || req.Permission == "" {
+		return nil, status.Error(codes.InvalidArgument, "token and permission are required")
+	}
+	allowed, session, err := s.auth.CheckPermission(ctx, req.Token, req.Permission)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired token")
+		}
+		return nil, status.Error(codes.Internal, "failed to check permission")
+	}
+	return &authv1.CheckPermissionResponse{Allowed: allowed, Email: session.Email, Roles: session.Roles}, nil
+}
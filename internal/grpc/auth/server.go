@@ -5,27 +5,39 @@ import (
 	"errors"
 	"time"
 
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"github.com/GosMachine/ServiceAuth/internal/models"
 	auth "github.com/GosMachine/ServiceAuth/internal/services"
 	"github.com/GosMachine/ServiceAuth/internal/storage"
-	"github.com/GosMachine/ServiceAuth/internal/utils"
 	authv1 "github.com/GosMachine/protos/gen/go/auth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type Auth interface {
-	Login(email, password, ip, rememberMe string) (token string, tokenTTL time.Duration, err error)
-	Logout(token string) error
-	OAuth(email, ip string) (token string, tokenTTL time.Duration, err error)
-	CreateToken(email, remember string) (string, time.Duration)
-	GetUserEmail(token string) string
-	EmailVerified(email string) (verified bool, err error)
-	EmailVerify(email string) error
-	ChangeEmail(email, newEmail, oldToken string) (token string, tokenTTL time.Duration, err error)
-	Register(email, password, ip, rememberMe string) (token string, tokenTTL time.Duration, err error)
-	ChangePass(email, password, ip, oldToken string) (token string, tokenTTL time.Duration, err error)
+	Login(ctx context.Context, email, password, ip, rememberMe string) (token string, tokenTTL time.Duration, err error)
+	Logout(ctx context.Context, token string) error
+	OAuth(ctx context.Context, email, ip, provider string) (token string, tokenTTL time.Duration, err error)
+	CreateToken(ctx context.Context, email, remember string) (token string, tokenTTL time.Duration, err error)
+	GetUserEmail(ctx context.Context, token string) string
+	EmailVerified(ctx context.Context, email string) (verified bool, err error)
+	EmailVerify(ctx context.Context, email string) error
+	ChangeEmail(ctx context.Context, email, newEmail, oldToken string) (token string, tokenTTL time.Duration, err error)
+	Register(ctx context.Context, email, password, ip, rememberMe string) (token string, tokenTTL time.Duration, err error)
+	ChangePass(ctx context.Context, email, password, ip, oldToken string) (token string, tokenTTL time.Duration, err error)
+	ReportLogin(ctx context.Context, token string) error
+	ResetPassword(ctx context.Context, resetToken, password, ip string) (token string, tokenTTL time.Duration, err error)
+	CheckPermission(ctx context.Context, token, permission string) (allowed bool, session storage.Session, err error)
+	DeleteAccount(ctx context.Context, token, password, ip string) (purgeAt time.Time, err error)
+	CancelAccountDeletion(ctx context.Context, cancelToken string) error
+	ExportUserData(ctx context.Context, token, ip string) (archive []byte, err error)
+	Credit(ctx context.Context, email string, amount int64, currency, idempotencyKey, description string) (models.BalanceTransaction, error)
+	Debit(ctx context.Context, email string, amount int64, currency, idempotencyKey, description string) (models.BalanceTransaction, error)
+	Transfer(ctx context.Context, from, to string, amount int64, currency, idempotencyKey, description string) (debit, credit models.BalanceTransaction, err error)
+	GetBalance(ctx context.Context, email, currency string) (models.Balance, error)
 }
 
 type serverAPI struct {
@@ -38,14 +50,28 @@ func RegisterAuthServer(gRPC *grpc.Server, auth Auth) {
 }
 
 func (s *serverAPI) Login(ctx context.Context, req *authv1.LoginRequest) (*authv1.LoginResponse, error) {
-	if !utils.ValidateAuthData(req.Email, req.Password) {
+	email, err := mailaddr.Normalize(req.Email)
+	if err != nil || req.Password == "" || len(req.Password) > maxPasswordBytes {
 		return nil, status.Error(codes.InvalidArgument, "invalid email or password")
 	}
-	token, tokenTTL, err := s.auth.Login(req.Email, req.Password, req.IP, req.RememberMe)
+	token, tokenTTL, err := s.auth.Login(ctx, email, req.Password, req.IP, req.RememberMe)
 	if err != nil {
 		if errors.Is(err, auth.ErrInvalidCredentials) {
 			return nil, status.Error(codes.InvalidArgument, "invalid email or password")
 		}
+		var resetErr *auth.ResetRequiredError
+		if errors.As(err, &resetErr) {
+			return nil, passwordResetError(resetErr)
+		}
+		if errors.Is(err, auth.ErrPasswordResetRequired) {
+			return nil, status.Error(codes.FailedPrecondition, "password reset required")
+		}
+		if errors.Is(err, auth.ErrCountryBlocked) {
+			return nil, status.Error(codes.PermissionDenied, "sign-in is not available in your country")
+		}
+		if err, ok := accountStatusError(err); ok {
+			return nil, err
+		}
 		return nil, status.Error(codes.Internal, "failed to login")
 	}
 
@@ -53,7 +79,7 @@ func (s *serverAPI) Login(ctx context.Context, req *authv1.LoginRequest) (*authv
 }
 
 func (s *serverAPI) Logout(ctx context.Context, req *authv1.LogoutRequest) (*emptypb.Empty, error) {
-	err := s.auth.Logout(req.Token)
+	err := s.auth.Logout(ctx, req.Token)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "failed to logout")
 	}
@@ -61,14 +87,20 @@ func (s *serverAPI) Logout(ctx context.Context, req *authv1.LogoutRequest) (*emp
 }
 
 func (s *serverAPI) Register(ctx context.Context, req *authv1.RegisterRequest) (*authv1.RegisterResponse, error) {
-	if !utils.ValidateAuthData(req.Email, req.Password) {
-		return nil, status.Error(codes.InvalidArgument, "invalid email or password")
-	}
-	token, tokenTTL, err := s.auth.Register(req.Email, req.Password, req.IP, req.RememberMe)
+	token, tokenTTL, err := s.auth.Register(ctx, req.Email, req.Password, req.IP, req.RememberMe)
 	if err != nil {
+		if err, ok := emailError(err, "email"); ok {
+			return nil, err
+		}
+		if err, ok := passwordPolicyError(err); ok {
+			return nil, err
+		}
 		if errors.Is(err, storage.ErrUserExists) {
 			return nil, status.Error(codes.AlreadyExists, "user already exists")
 		}
+		if errors.Is(err, auth.ErrCountryBlocked) {
+			return nil, status.Error(codes.PermissionDenied, "sign-up is not available in your country")
+		}
 
 		return nil, status.Error(codes.Internal, "failed to register user")
 	}
@@ -76,26 +108,42 @@ func (s *serverAPI) Register(ctx context.Context, req *authv1.RegisterRequest) (
 }
 
 func (s *serverAPI) OAuth(ctx context.Context, req *authv1.OAuthRequest) (*authv1.OAuthResponse, error) {
-	token, tokenTTL, err := s.auth.OAuth(req.Email, req.IP)
+	token, tokenTTL, err := s.auth.OAuth(ctx, req.Email, req.IP, oauthProvider(ctx))
 	if err != nil {
+		if err, ok := emailError(err, "email"); ok {
+			return nil, err
+		}
+		if errors.Is(err, auth.ErrCountryBlocked) {
+			return nil, status.Error(codes.PermissionDenied, "sign-in is not available in your country")
+		}
+		if errors.Is(err, auth.ErrPasswordResetRequired) {
+			return nil, status.Error(codes.FailedPrecondition, "password reset required")
+		}
+		if err, ok := accountStatusError(err); ok {
+			return nil, err
+		}
 		return nil, status.Error(codes.Internal, "failed to OAuth")
 	}
 	return &authv1.OAuthResponse{Token: token, TokenTTL: int64(tokenTTL.Minutes())}, nil
 }
 
 func (s *serverAPI) ChangePass(ctx context.Context, req *authv1.ChangePassRequest) (*authv1.ChangePassResponse, error) {
-	if !utils.ValidateAuthData(req.Email, req.Password) {
-		return nil, status.Error(codes.InvalidArgument, "invalid email or password")
+	email, err := mailaddr.Normalize(req.Email)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid email")
 	}
-	token, tokenTTL, err := s.auth.ChangePass(req.Email, req.Password, req.IP, req.OldToken)
+	token, tokenTTL, err := s.auth.ChangePass(ctx, email, req.Password, req.IP, req.OldToken)
 	if err != nil {
+		if err, ok := passwordPolicyError(err); ok {
+			return nil, err
+		}
 		return nil, status.Error(codes.Internal, "failed to change password")
 	}
 	return &authv1.ChangePassResponse{Token: token, TokenTTL: int64(tokenTTL.Minutes())}, nil
 }
 
 func (s *serverAPI) EmailVerified(ctx context.Context, req *authv1.EmailVerifiedRequest) (*authv1.EmailVerifiedResponse, error) {
-	verified, err := s.auth.EmailVerified(req.Email)
+	verified, err := s.auth.EmailVerified(ctx, req.Email)
 	if err != nil {
 		if errors.Is(err, storage.ErrUserNotFound) {
 			return nil, status.Error(codes.NotFound, "user not found")
@@ -106,18 +154,24 @@ func (s *serverAPI) EmailVerified(ctx context.Context, req *authv1.EmailVerified
 }
 
 func (s *serverAPI) ChangeEmail(ctx context.Context, req *authv1.ChangeEmailRequest) (*authv1.ChangeEmailResponse, error) {
-	token, tokenTTL, err := s.auth.ChangeEmail(req.Email, req.NewEmail, req.OldToken)
+	token, tokenTTL, err := s.auth.ChangeEmail(ctx, req.Email, req.NewEmail, req.OldToken)
 	if err != nil {
+		if err, ok := emailError(err, "new_email"); ok {
+			return nil, err
+		}
 		if errors.Is(err, storage.ErrUserNotFound) {
 			return nil, status.Error(codes.NotFound, "user not found")
 		}
+		if errors.Is(err, storage.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "user already exists")
+		}
 		return nil, status.Error(codes.Internal, "internal server error")
 	}
 	return &authv1.ChangeEmailResponse{Token: token, TokenTTL: int64(tokenTTL.Minutes())}, nil
 }
 
 func (s *serverAPI) EmailVerify(ctx context.Context, req *authv1.EmailVerifyRequest) (*emptypb.Empty, error) {
-	err := s.auth.EmailVerify(req.Email)
+	err := s.auth.EmailVerify(ctx, req.Email)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "email verify failed")
 	}
@@ -125,11 +179,34 @@ func (s *serverAPI) EmailVerify(ctx context.Context, req *authv1.EmailVerifyRequ
 }
 
 func (s *serverAPI) CreateToken(ctx context.Context, req *authv1.CreateTokenRequest) (*authv1.CreateTokenResponse, error) {
-	token, tokenTTL := s.auth.CreateToken(req.Email, req.Remember)
+	token, tokenTTL, err := s.auth.CreateToken(ctx, req.Email, req.Remember)
+	if err != nil {
+		if err, ok := accountStatusError(err); ok {
+			return nil, err
+		}
+		if errors.Is(err, auth.ErrPasswordResetRequired) {
+			return nil, status.Error(codes.FailedPrecondition, "password reset required")
+		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Error(codes.Internal, "failed to create token")
+	}
 	return &authv1.CreateTokenResponse{Token: token, TokenTTL: int64(tokenTTL.Minutes())}, nil
 }
 
 func (s *serverAPI) GetUserEmail(ctx context.Context, req *authv1.GetUserEmailRequest) (*authv1.GetUserEmailResponse, error) {
-	email := s.auth.GetUserEmail(req.Token)
+	email := s.auth.GetUserEmail(ctx, req.Token)
 	return &authv1.GetUserEmailResponse{Email: email}, nil
 }
+
+// oauthProvider reads the provider name the frontend sends along with an
+// OAuth call. It is only used for reporting.
+func oauthProvider(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-oauth-provider"); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return "unknown"
+}
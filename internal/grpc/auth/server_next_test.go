@@ -0,0 +1,306 @@
+//go:build protosnext
+
+package grpcauth
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/notifier"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+var maxTime = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// queuedLink returns the token in the link of the only queued notification
+// of template.
+func (f *fixture) queuedLink(t *testing.T, template string) string {
+	t.Helper()
+	due, err := f.db.DueNotifications(context.Background(), maxTime, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, n := range due {
+		if n.Template == template {
+			links = append(links, n.Data["Link"])
+		}
+	}
+	if len(links) != 1 {
+		t.Fatalf("queued %d %s notifications, want 1", len(links), template)
+	}
+	link, err := url.Parse(links[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link.Query().Get("token")
+}
+
+func TestReportLoginAndResetPassword(t *testing.T) {
+	f := newFixture(t, auth.WithNotifications("en"), auth.WithDeviceTracking(config.DevicesConfig{
+		Enabled:             true,
+		IPv4Prefix:          24,
+		IPv6Prefix:          48,
+		InactivityThreshold: 24 * time.Hour,
+		ReportURL:           "https://example.com/report",
+		ReportTTL:           time.Hour,
+		ResetURL:            "https://example.com/reset",
+		ResetTTL:            time.Hour,
+	}))
+	ctx := context.Background()
+	f.register(t, "jane@example.com")
+	if _, err := f.client.Login(ctx, &authv1.LoginRequest{Email: "jane@example.com", Password: testPassword, IP: "198.51.100.7"}); err != nil {
+		t.Fatal(err)
+	}
+	reportToken := f.queuedLink(t, notifier.TemplateNewDevice)
+
+	reports := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"empty token", "", codes.InvalidArgument},
+		{"unknown token", "nope", codes.NotFound},
+		{"emailed token", reportToken, codes.OK},
+	}
+	for _, tt := range reports {
+		t.Run("report/"+tt.name, func(t *testing.T) {
+			_, err := f.client.ReportLogin(ctx, &authv1.ReportLoginRequest{Token: tt.token})
+			wantCode(t, err, tt.want)
+		})
+	}
+
+	_, err := f.client.Login(ctx, &authv1.LoginRequest{Email: "jane@example.com", Password: testPassword, IP: "192.0.2.1"})
+	wantCode(t, err, codes.FailedPrecondition)
+	resetToken := f.queuedLink(t, notifier.TemplatePasswordReset)
+
+	resets := []struct {
+		name string
+		req  *authv1.ResetPasswordRequest
+		want codes.Code
+	}{
+		{"empty token", &authv1.ResetPasswordRequest{Password: "a brand new horse battery 12"}, codes.InvalidArgument},
+		{"unknown token", &authv1.ResetPasswordRequest{Token: "nope", Password: "a brand new horse battery 12"}, codes.NotFound},
+		{"weak password", &authv1.ResetPasswordRequest{Token: resetToken, Password: "short"}, codes.InvalidArgument},
+		{"reused password", &authv1.ResetPasswordRequest{Token: resetToken, Password: testPassword}, codes.InvalidArgument},
+		{"emailed token", &authv1.ResetPasswordRequest{Token: resetToken, Password: "a brand new horse battery 12"}, codes.OK},
+		{"token used twice", &authv1.ResetPasswordRequest{Token: resetToken, Password: "yet another horse battery 13"}, codes.NotFound},
+	}
+	for _, tt := range resets {
+		t.Run("reset/"+tt.name, func(t *testing.T) {
+			resp, err := f.client.ResetPassword(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && resp.Token == "" {
+				t.Error("no token after the reset")
+			}
+		})
+	}
+}
+
+func TestCheckPermission(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	f.register(t, "jane@example.com")
+	user := f.register(t, "bob@example.com")
+	err := f.auth.SeedRoles(ctx, config.RBACConfig{
+		Roles:  map[string]config.RoleConfig{"support": {Permissions: []string{"users.read"}}},
+		Grants: map[string][]string{"support": {"jane@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sign in again so the session carries the seeded role
+	resp, err := f.client.Login(ctx, &authv1.LoginRequest{Email: "jane@example.com", Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := resp.Token
+
+	tests := []struct {
+		name      string
+		req       *authv1.CheckPermissionRequest
+		want      codes.Code
+		wantAllow bool
+		wantEmail string
+	}{
+		{"granted", &authv1.CheckPermissionRequest{Token: admin, Permission: "users.read"}, codes.OK, true, "jane@example.com"},
+		{"not granted", &authv1.CheckPermissionRequest{Token: admin, Permission: "users.write"}, codes.OK, false, "jane@example.com"},
+		{"no roles", &authv1.CheckPermissionRequest{Token: user, Permission: "users.read"}, codes.OK, false, "bob@example.com"},
+		{"unknown token", &authv1.CheckPermissionRequest{Token: "nope", Permission: "users.read"}, codes.Unauthenticated, false, ""},
+		{"empty permission", &authv1.CheckPermissionRequest{Token: admin}, codes.InvalidArgument, false, ""},
+		{"empty token", &authv1.CheckPermissionRequest{Permission: "users.read"}, codes.InvalidArgument, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.CheckPermission(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && (resp.Allowed != tt.wantAllow || resp.Email != tt.wantEmail) {
+				t.Errorf("response = %+v, want allowed %v for %s", resp, tt.wantAllow, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	f := newFixture(t, auth.WithNotifications("en"), auth.WithAccountDeletion(config.DeletionConfig{
+		GracePeriod: 24 * time.Hour,
+		Mode:        "anonymize",
+		CancelURL:   "https://example.com/restore",
+	}))
+	ctx := context.Background()
+	token := f.register(t, "jane@example.com")
+
+	tests := []struct {
+		name string
+		req  *authv1.DeleteAccountRequest
+		want codes.Code
+	}{
+		{"empty token", &authv1.DeleteAccountRequest{Password: testPassword}, codes.InvalidArgument},
+		{"unknown token", &authv1.DeleteAccountRequest{Token: "nope", Password: testPassword}, codes.Unauthenticated},
+		{"wrong password", &authv1.DeleteAccountRequest{Token: token, Password: "not my password 1"}, codes.InvalidArgument},
+		{"confirmed", &authv1.DeleteAccountRequest{Token: token, Password: testPassword}, codes.OK},
+		{"session ended", &authv1.DeleteAccountRequest{Token: token, Password: testPassword}, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.DeleteAccount(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && resp.PurgeAt <= time.Now().Unix() {
+				t.Errorf("PurgeAt = %d, want a time in the future", resp.PurgeAt)
+			}
+		})
+	}
+
+	cancelToken := f.queuedLink(t, notifier.TemplateAccountDeletion)
+	cancels := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"empty token", "", codes.InvalidArgument},
+		{"unknown token", "nope", codes.NotFound},
+		{"emailed token", cancelToken, codes.OK},
+		{"token used twice", cancelToken, codes.NotFound},
+	}
+	for _, tt := range cancels {
+		t.Run("cancel/"+tt.name, func(t *testing.T) {
+			_, err := f.client.CancelAccountDeletion(ctx, &authv1.CancelAccountDeletionRequest{Token: tt.token})
+			wantCode(t, err, tt.want)
+		})
+	}
+	if _, err := f.client.Login(ctx, &authv1.LoginRequest{Email: "jane@example.com", Password: testPassword}); err != nil {
+		t.Errorf("Login after the restore: %v", err)
+	}
+}
+
+func TestExportUserData(t *testing.T) {
+	f := newFixture(t, auth.WithDataExport(config.ExportConfig{RateLimit: 1, RateWindow: time.Hour}))
+	ctx := context.Background()
+	token := f.register(t, "jane@example.com")
+
+	tests := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"empty token", "", codes.InvalidArgument},
+		{"unknown token", "nope", codes.Unauthenticated},
+		{"first export", token, codes.OK},
+		{"over the limit", token, codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.ExportUserData(ctx, &authv1.ExportUserDataRequest{Token: tt.token})
+			wantCode(t, err, tt.want)
+			if err == nil && (len(resp.Archive) == 0 || resp.FileName == "") {
+				t.Errorf("response has no archive: %q", resp.FileName)
+			}
+		})
+	}
+}
+
+func TestBalance(t *testing.T) {
+	f := newFixture(t)
+	f.register(t, "jane@example.com")
+	f.register(t, "bob@example.com")
+	f.register(t, "eve@example.com")
+	if err := f.auth.DisableUser(context.Background(), "eve@example.com", "abuse", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-service-token", testServiceToken)
+
+	credits := []struct {
+		name string
+		req  *authv1.CreditRequest
+		want codes.Code
+	}{
+		{"valid", &authv1.CreditRequest{Email: "jane@example.com", Amount: 500, Currency: "usd", IdempotencyKey: "c1"}, codes.OK},
+		{"retry", &authv1.CreditRequest{Email: "jane@example.com", Amount: 500, Currency: "usd", IdempotencyKey: "c1"}, codes.OK},
+		{"key reused", &authv1.CreditRequest{Email: "jane@example.com", Amount: 700, Currency: "usd", IdempotencyKey: "c1"}, codes.AlreadyExists},
+		{"zero amount", &authv1.CreditRequest{Email: "jane@example.com", Currency: "usd", IdempotencyKey: "c2"}, codes.InvalidArgument},
+		{"bad currency", &authv1.CreditRequest{Email: "jane@example.com", Amount: 1, Currency: "dollars", IdempotencyKey: "c3"}, codes.InvalidArgument},
+		{"no key", &authv1.CreditRequest{Email: "jane@example.com", Amount: 1, Currency: "usd"}, codes.InvalidArgument},
+		{"malformed email", &authv1.CreditRequest{Email: "jane", Amount: 1, Currency: "usd", IdempotencyKey: "c4"}, codes.InvalidArgument},
+		{"unknown user", &authv1.CreditRequest{Email: "ann@example.com", Amount: 1, Currency: "usd", IdempotencyKey: "c5"}, codes.NotFound},
+		{"disabled account", &authv1.CreditRequest{Email: "eve@example.com", Amount: 1, Currency: "usd", IdempotencyKey: "c6"}, codes.PermissionDenied},
+	}
+	for _, tt := range credits {
+		t.Run("credit/"+tt.name, func(t *testing.T) {
+			_, err := f.client.Credit(ctx, tt.req)
+			wantCode(t, err, tt.want)
+		})
+	}
+
+	debits := []struct {
+		name string
+		req  *authv1.DebitRequest
+		want codes.Code
+	}{
+		{"valid", &authv1.DebitRequest{Email: "jane@example.com", Amount: 200, Currency: "USD", IdempotencyKey: "d1"}, codes.OK},
+		{"insufficient funds", &authv1.DebitRequest{Email: "jane@example.com", Amount: 1000, Currency: "USD", IdempotencyKey: "d2"}, codes.FailedPrecondition},
+		{"other currency", &authv1.DebitRequest{Email: "jane@example.com", Amount: 1, Currency: "EUR", IdempotencyKey: "d3"}, codes.FailedPrecondition},
+	}
+	for _, tt := range debits {
+		t.Run("debit/"+tt.name, func(t *testing.T) {
+			_, err := f.client.Debit(ctx, tt.req)
+			wantCode(t, err, tt.want)
+		})
+	}
+
+	transfers := []struct {
+		name string
+		req  *authv1.TransferRequest
+		want codes.Code
+	}{
+		{"valid", &authv1.TransferRequest{FromEmail: "jane@example.com", ToEmail: "bob@example.com", Amount: 100, Currency: "USD", IdempotencyKey: "t1"}, codes.OK},
+		{"to self", &authv1.TransferRequest{FromEmail: "jane@example.com", ToEmail: "jane@example.com", Amount: 1, Currency: "USD", IdempotencyKey: "t2"}, codes.InvalidArgument},
+		{"insufficient funds", &authv1.TransferRequest{FromEmail: "bob@example.com", ToEmail: "jane@example.com", Amount: 1000, Currency: "USD", IdempotencyKey: "t3"}, codes.FailedPrecondition},
+		{"to disabled account", &authv1.TransferRequest{FromEmail: "jane@example.com", ToEmail: "eve@example.com", Amount: 1, Currency: "USD", IdempotencyKey: "t4"}, codes.PermissionDenied},
+		{"malformed recipient", &authv1.TransferRequest{FromEmail: "jane@example.com", ToEmail: "bob", Amount: 1, Currency: "USD", IdempotencyKey: "t5"}, codes.InvalidArgument},
+	}
+	for _, tt := range transfers {
+		t.Run("transfer/"+tt.name, func(t *testing.T) {
+			_, err := f.client.Transfer(ctx, tt.req)
+			wantCode(t, err, tt.want)
+		})
+	}
+
+	for email, want := range map[string]int64{"jane@example.com": 200, "bob@example.com": 100} {
+		resp, err := f.client.GetBalance(ctx, &authv1.GetBalanceRequest{Email: email, Currency: "USD"})
+		if err != nil {
+			t.Fatalf("GetBalance(%q): %v", email, err)
+		}
+		if resp.Amount != want || resp.Currency != "USD" {
+			t.Errorf("balance of %s = %d %s, want %d USD", email, resp.Amount, resp.Currency, want)
+		}
+	}
+
+	_, err := f.client.GetBalance(context.Background(), &authv1.GetBalanceRequest{Email: "jane@example.com", Currency: "USD"})
+	wantCode(t, err, codes.Unauthenticated)
+}
@@ -0,0 +1,368 @@
+package grpcauth
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/password"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+	authv1 "github.com/GosMachine/protos/gen/go/auth"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	testPassword     = "correct horse battery staple 9"
+	testServiceToken = "billing-token"
+)
+
+// fixture is the Auth service on in-memory storage with a password policy,
+// served over bufconn with the interceptors the app installs.
+type fixture struct {
+	client authv1.AuthClient
+	auth   *auth.Auth
+	db     *memory.Database
+}
+
+func newFixture(t *testing.T, opts ...auth.Option) *fixture {
+	t.Helper()
+	log := zap.NewNop()
+	db := memory.NewDatabase(nil)
+	policy := password.NewPolicy(config.PasswordConfig{MinLength: 10, MaxLength: 72, History: 3, Breached: config.BreachedConfig{Mode: "off"}}, nil)
+	opts = append([]auth.Option{auth.WithPasswordPolicy(policy)}, opts...)
+	svc := auth.New(log, db, memory.NewRedis(db, log, nil), memory.NewSessions(nil), time.Hour, 24*time.Hour, opts...)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		UnaryServerInterceptor(),
+		ServiceTokenInterceptor(log, map[string]string{"billing": testServiceToken}),
+	))
+	RegisterAuthServer(srv, svc)
+	go srv.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+	return &fixture{client: authv1.NewAuthClient(conn), auth: svc, db: db}
+}
+
+// register signs a user up with testPassword and returns their token.
+func (f *fixture) register(t *testing.T, email string) string {
+	t.Helper()
+	resp, err := f.client.Register(context.Background(), &authv1.RegisterRequest{
+		Email: email, Password: testPassword, IP: "192.0.2.1",
+	})
+	if err != nil {
+		t.Fatalf("Register(%q): %v", email, err)
+	}
+	return resp.Token
+}
+
+func wantCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Errorf("code = %v, want %v (%v)", got, want, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	f.register(t, "jane@example.com")
+	f.register(t, "bob@example.com")
+	if err := f.auth.DisableUser(ctx, "bob@example.com", "abuse", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  *authv1.LoginRequest
+		want codes.Code
+	}{
+		{"valid", &authv1.LoginRequest{Email: "jane@example.com", Password: testPassword}, codes.OK},
+		{"email is normalized", &authv1.LoginRequest{Email: " Jane@Example.com ", Password: testPassword}, codes.OK},
+		{"wrong password", &authv1.LoginRequest{Email: "jane@example.com", Password: "nope"}, codes.InvalidArgument},
+		{"unknown user", &authv1.LoginRequest{Email: "ann@example.com", Password: testPassword}, codes.InvalidArgument},
+		{"malformed email", &authv1.LoginRequest{Email: "jane", Password: testPassword}, codes.InvalidArgument},
+		{"empty password", &authv1.LoginRequest{Email: "jane@example.com"}, codes.InvalidArgument},
+		{"password too long", &authv1.LoginRequest{Email: "jane@example.com", Password: strings.Repeat("a", maxPasswordBytes+1)}, codes.InvalidArgument},
+		{"disabled account", &authv1.LoginRequest{Email: "bob@example.com", Password: testPassword}, codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.Login(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && (resp.Token == "" || resp.TokenTTL <= 0) {
+				t.Errorf("response = %+v, want a token and its TTL", resp)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	f.register(t, "jane@example.com")
+
+	tests := []struct {
+		name string
+		req  *authv1.RegisterRequest
+		want codes.Code
+	}{
+		{"valid", &authv1.RegisterRequest{Email: "bob@example.com", Password: testPassword}, codes.OK},
+		{"taken", &authv1.RegisterRequest{Email: "jane@example.com", Password: testPassword}, codes.AlreadyExists},
+		{"taken in other case", &authv1.RegisterRequest{Email: "JANE@example.com", Password: testPassword}, codes.AlreadyExists},
+		{"malformed email", &authv1.RegisterRequest{Email: "ann@", Password: testPassword}, codes.InvalidArgument},
+		{"weak password", &authv1.RegisterRequest{Email: "ann@example.com", Password: "short"}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.Register(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && resp.Token == "" {
+				t.Error("no token")
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	token := f.register(t, "jane@example.com")
+
+	tests := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"signed in", token, codes.OK},
+		{"already signed out", token, codes.OK},
+		{"unknown token", "nope", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.client.Logout(ctx, &authv1.LogoutRequest{Token: tt.token})
+			wantCode(t, err, tt.want)
+		})
+	}
+	resp, err := f.client.GetUserEmail(ctx, &authv1.GetUserEmailRequest{Token: token})
+	if err != nil || resp.Email != "" {
+		t.Errorf("GetUserEmail after Logout = %+v, %v, want no email", resp, err)
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	f.register(t, "jane@example.com")
+	f.register(t, "bob@example.com")
+	if err := f.auth.BanUser(ctx, "bob@example.com", "fraud", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	f.register(t, "ann@example.com")
+	if err := f.auth.RequirePasswordReset(ctx, "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  *authv1.OAuthRequest
+		want codes.Code
+	}{
+		{"new user", &authv1.OAuthRequest{Email: "new@example.com"}, codes.OK},
+		{"existing user", &authv1.OAuthRequest{Email: "jane@example.com"}, codes.OK},
+		{"malformed email", &authv1.OAuthRequest{Email: "not an email"}, codes.InvalidArgument},
+		{"banned account", &authv1.OAuthRequest{Email: "bob@example.com"}, codes.PermissionDenied},
+		{"password reset pending", &authv1.OAuthRequest{Email: "ann@example.com"}, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(ctx, "x-oauth-provider", "google")
+			resp, err := f.client.OAuth(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && resp.Token == "" {
+				t.Error("no token")
+			}
+		})
+	}
+}
+
+func TestChangePass(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	token := f.register(t, "jane@example.com")
+
+	tests := []struct {
+		name string
+		req  *authv1.ChangePassRequest
+		want codes.Code
+	}{
+		{"malformed email", &authv1.ChangePassRequest{Email: "jane", Password: "another good passphrase 7"}, codes.InvalidArgument},
+		{"weak password", &authv1.ChangePassRequest{Email: "jane@example.com", Password: "short"}, codes.InvalidArgument},
+		{"reused password", &authv1.ChangePassRequest{Email: "jane@example.com", Password: testPassword}, codes.InvalidArgument},
+		{"valid", &authv1.ChangePassRequest{Email: "jane@example.com", Password: "another good passphrase 7", OldToken: token}, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.ChangePass(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && resp.Token == "" {
+				t.Error("no token")
+			}
+		})
+	}
+	if _, err := f.client.Login(ctx, &authv1.LoginRequest{Email: "jane@example.com", Password: "another good passphrase 7"}); err != nil {
+		t.Errorf("Login with the new password: %v", err)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	f.register(t, "jane@example.com")
+
+	tests := []struct {
+		name   string
+		verify string
+		email  string
+		want   bool
+	}{
+		{"not yet verified", "", "jane@example.com", false},
+		{"verified", "jane@example.com", "jane@example.com", true},
+		{"verified twice", "jane@example.com", "jane@example.com", true},
+		{"unknown user", "ann@example.com", "ann@example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.verify != "" {
+				_, err := f.client.EmailVerify(ctx, &authv1.EmailVerifyRequest{Email: tt.verify})
+				wantCode(t, err, codes.OK)
+			}
+			resp, err := f.client.EmailVerified(ctx, &authv1.EmailVerifiedRequest{Email: tt.email})
+			if err != nil {
+				if status.Code(err) != codes.NotFound || tt.want {
+					t.Fatalf("EmailVerified: %v", err)
+				}
+				return
+			}
+			if resp.EmailVerified != tt.want {
+				t.Errorf("EmailVerified = %v, want %v", resp.EmailVerified, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	token := f.register(t, "jane@example.com")
+	f.register(t, "bob@example.com")
+
+	tests := []struct {
+		name string
+		req  *authv1.ChangeEmailRequest
+		want codes.Code
+	}{
+		{"malformed new email", &authv1.ChangeEmailRequest{Email: "jane@example.com", NewEmail: "jane"}, codes.InvalidArgument},
+		{"taken", &authv1.ChangeEmailRequest{Email: "jane@example.com", NewEmail: "bob@example.com"}, codes.AlreadyExists},
+		{"valid", &authv1.ChangeEmailRequest{Email: "jane@example.com", NewEmail: "jane@example.org", OldToken: token}, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.ChangeEmail(ctx, tt.req)
+			wantCode(t, err, tt.want)
+			if err == nil && resp.Token == "" {
+				t.Error("no token")
+			}
+		})
+	}
+	resp, err := f.client.GetUserEmail(ctx, &authv1.GetUserEmailRequest{Token: token})
+	if err != nil || resp.Email != "" {
+		t.Errorf("old token still signed in: %+v, %v", resp, err)
+	}
+}
+
+func TestCreateToken(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	f.register(t, "jane@example.com")
+	f.register(t, "bob@example.com")
+	if err := f.auth.DisableUser(ctx, "bob@example.com", "abuse", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	f.register(t, "ann@example.com")
+	if err := f.auth.RequirePasswordReset(ctx, "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		email string
+		want  codes.Code
+	}{
+		{"active", "jane@example.com", codes.OK},
+		{"unknown user", "nobody@example.com", codes.NotFound},
+		{"disabled account", "bob@example.com", codes.PermissionDenied},
+		{"password reset pending", "ann@example.com", codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.CreateToken(ctx, &authv1.CreateTokenRequest{Email: tt.email})
+			wantCode(t, err, tt.want)
+			if err != nil {
+				return
+			}
+			got, err := f.client.GetUserEmail(ctx, &authv1.GetUserEmailRequest{Token: resp.Token})
+			if err != nil || got.Email != tt.email {
+				t.Errorf("GetUserEmail = %+v, %v, want %s", got, err, tt.email)
+			}
+		})
+	}
+}
+
+func TestGetUserEmail(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	token := f.register(t, "jane@example.com")
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"signed in", token, "jane@example.com"},
+		{"unknown token", "nope", ""},
+		{"no token", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := f.client.GetUserEmail(ctx, &authv1.GetUserEmailRequest{Token: tt.token})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Email != tt.want {
+				t.Errorf("email = %q, want %q", resp.Email, tt.want)
+			}
+		})
+	}
+}
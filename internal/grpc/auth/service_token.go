@@ -0,0 +1,65 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"path"
+
+	"github.com/GosMachine/ServiceAuth/internal/logger"
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const serviceTokenHeader = "x-service-token"
+
+// serviceMethods are the Auth RPCs only other services may call, with a
+// token from GRPCConfig.ServiceTokens. They are matched by name alone, no
+// other service has methods called the same.
+var serviceMethods = map[string]bool{
+	"Credit":     true,
+	"Debit":      true,
+	"Transfer":   true,
+	"GetBalance": true,
+}
+
+// ServiceTokenInterceptor refuses calls to serviceMethods unless they carry
+// one of tokens, keyed by the name of the service it was issued to, in the
+// x-service-token header. That name is the actor of the call. With no
+// tokens configured the methods can't be called at all.
+func ServiceTokenInterceptor(log *zap.Logger, tokens map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !serviceMethods[path.Base(info.FullMethod)] {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(serviceTokenHeader)
+		if len(values) == 0 || values[0] == "" {
+			return nil, status.Error(codes.Unauthenticated, "missing service token")
+		}
+		log := logger.FromContext(ctx, log)
+		service := serviceFor(tokens, values[0])
+		if service == "" {
+			log.Warn("invalid service token")
+			return nil, status.Error(codes.Unauthenticated, "invalid service token")
+		}
+		ctx = logger.WithContext(ctx, log.With(zap.String("service", service)))
+		return handler(auth.WithActor(ctx, "service:"+service), req)
+	}
+}
+
+// serviceFor returns the service token was issued to. Every token is
+// compared, in constant time, so the time taken doesn't tell which one
+// came close.
+func serviceFor(tokens map[string]string, token string) string {
+	var found string
+	for service, t := range tokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = service
+		}
+	}
+	return found
+}
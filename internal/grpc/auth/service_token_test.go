@@ -0,0 +1,43 @@
+package grpcauth
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServiceTokenInterceptor(t *testing.T) {
+	tokens := map[string]string{"billing": "billing-token"}
+	tests := []struct {
+		name   string
+		tokens map[string]string
+		method string
+		token  string
+		want   codes.Code
+	}{
+		{"valid token", tokens, "/auth.Auth/Credit", "billing-token", codes.OK},
+		{"missing token", tokens, "/auth.Auth/Debit", "", codes.Unauthenticated},
+		{"wrong token", tokens, "/auth.Auth/Transfer", "guess", codes.Unauthenticated},
+		{"no tokens configured", nil, "/auth.Auth/GetBalance", "billing-token", codes.Unauthenticated},
+		{"other method", nil, "/auth.Auth/Login", "", codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(serviceTokenHeader, tt.token))
+			}
+			intercept := ServiceTokenInterceptor(zap.NewNop(), tt.tokens)
+			_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("code = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
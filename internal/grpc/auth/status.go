@@ -0,0 +1,38 @@
+package grpcauth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	auth "github.com/GosMachine/ServiceAuth/internal/services"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// accountStatusError turns a sign-in refused for the account's status into
+// PermissionDenied with an ErrorInfo whose reason is ACCOUNT_ and the
+// status, e.g. ACCOUNT_BANNED. Its metadata carries the status reason and,
+// for temporary ones, when it lifts as unix seconds. ok is false for other
+// errors.
+func accountStatusError(err error) (_ error, ok bool) {
+	var statusErr *auth.AccountStatusError
+	if !errors.As(err, &statusErr) {
+		return nil, false
+	}
+	info := &errdetails.ErrorInfo{
+		Reason:   "ACCOUNT_" + strings.ToUpper(statusErr.Status),
+		Domain:   "auth",
+		Metadata: map[string]string{"reason": statusErr.Reason},
+	}
+	if !statusErr.Until.IsZero() {
+		info.Metadata["until"] = strconv.FormatInt(statusErr.Until.Unix(), 10)
+	}
+	msg := "account is " + strings.ReplaceAll(statusErr.Status, "_", " ")
+	st, detailsErr := status.New(codes.PermissionDenied, msg).WithDetails(info)
+	if detailsErr != nil {
+		return status.Error(codes.PermissionDenied, msg), true
+	}
+	return st.Err(), true
+}
@@ -0,0 +1,79 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	requestIDHeader = "x-request-id"
+	callerHeader    = "x-caller"
+)
+
+// UnaryServerInterceptor attaches a logger to every request context carrying
+// the request id, method, peer and caller, and echoes the request id back in
+// the response headers. The id is taken from x-request-id when the client
+// sends one.
+func UnaryServerInterceptor(log *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		requestID := first(md, requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, requestID))
+
+		fields := []zap.Field{
+			zap.String("request_id", requestID),
+			zap.String("method", info.FullMethod),
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			fields = append(fields, zap.String("peer", p.Addr.String()))
+			if caller := peerCaller(p); caller != "" {
+				fields = append(fields, zap.String("caller", caller))
+			}
+		}
+		if caller := first(md, callerHeader); caller != "" {
+			fields = append(fields, zap.String("caller", caller))
+		}
+		return handler(WithContext(ctx, log.With(fields...)), req)
+	}
+}
+
+// peerCaller returns the common name of the client certificate, if the peer
+// authenticated with one.
+func peerCaller(p *peer.Peer) string {
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ""
+	}
+	var chain []*x509.Certificate
+	if len(tlsInfo.State.VerifiedChains) > 0 {
+		chain = tlsInfo.State.VerifiedChains[0]
+	}
+	if len(chain) == 0 {
+		return ""
+	}
+	return chain[0].Subject.CommonName
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New builds the service logger from cfg. The returned level can be changed
+// while the logger is in use.
+func New(cfg config.LogConfig) (*zap.Logger, zap.AtomicLevel, error) {
+	level, err := zap.ParseAtomicLevel(cfg.Level)
+	if err != nil {
+		return nil, level, fmt.Errorf("parse level: %w", err)
+	}
+
+	encoder := zap.NewProductionEncoderConfig()
+	if cfg.Format == "console" {
+		encoder = zap.NewDevelopmentEncoderConfig()
+	}
+	encoder.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		enc.AppendString(t.Format("2006-01-02 15:04:05"))
+	}
+
+	zcfg := zap.Config{
+		Encoding:          cfg.Format,
+		DisableStacktrace: true,
+		Level:             level,
+		OutputPaths:       cfg.OutputPaths,
+		ErrorOutputPaths:  []string{"stderr"},
+		EncoderConfig:     encoder,
+	}
+	if cfg.Sampling.Initial > 0 {
+		zcfg.Sampling = &zap.SamplingConfig{
+			Initial:    cfg.Sampling.Initial,
+			Thereafter: cfg.Sampling.Thereafter,
+		}
+	}
+
+	policy := Policy{
+		Email:    cfg.Redaction.Email,
+		IP:       cfg.Redaction.IP,
+		HashSalt: cfg.Redaction.HashSalt,
+	}
+	log, err := zcfg.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return NewRedactingCore(core, policy)
+	}))
+	if err != nil {
+		return nil, level, err
+	}
+	return log, level, nil
+}
@@ -0,0 +1,186 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const redacted = "[redacted]"
+
+// Fields are classified by key. Anything under a secret key is never
+// written, emails and IPs are rewritten according to the Policy. caller
+// counts as an email, clients put whatever names them in x-caller.
+// Errors and the text keys are free text, the emails and tokens found in
+// them are rewritten.
+var (
+	secretKeys = map[string]bool{"token": true, "oldToken": true, "old_token": true, "password": true, "pass": true, "passHash": true}
+	emailKeys  = map[string]bool{"email": true, "newEmail": true, "new_email": true, "caller": true}
+	ipKeys     = map[string]bool{"ip": true, "peer": true}
+	textKeys   = map[string]bool{"error": true, "subject": true}
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// tokenPattern matches the tokens the service hands out, 32 or more
+	// characters of base64url or hex.
+	tokenPattern = regexp.MustCompile(`[A-Za-z0-9_\-]{32,}`)
+)
+
+// Policy says how personal data is written to the logs.
+type Policy struct {
+	// Email is "plain", "mask" (j***@example.com) or "hash".
+	Email string
+	// IP is "plain", "truncate" (/24 for IPv4, /48 for IPv6) or "drop".
+	IP string
+	// HashSalt is mixed into email hashes so they can't be reversed with a
+	// list of known addresses.
+	HashSalt string
+}
+
+// NewRedactingCore wraps core so that every field, including the ones added
+// with Logger.With, goes through policy before it is encoded.
+func NewRedactingCore(core zapcore.Core, policy Policy) zapcore.Core {
+	return &redactingCore{Core: core, policy: policy}
+}
+
+type redactingCore struct {
+	zapcore.Core
+	policy Policy
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.policy.apply(fields)), policy: c.policy}
+}
+
+func (c *redactingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *redactingCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, c.policy.apply(fields))
+}
+
+func (p Policy) apply(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		replaced, ok := p.redact(f)
+		if !ok {
+			if out != nil {
+				out = append(out, f)
+			}
+			continue
+		}
+		if out == nil {
+			out = make([]zapcore.Field, i, len(fields))
+			copy(out, fields[:i])
+		}
+		if replaced.Key != "" {
+			out = append(out, replaced)
+		}
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// redact returns the replacement for f and true if f needs one. A replacement
+// with an empty key means the field is dropped.
+func (p Policy) redact(f zapcore.Field) (zapcore.Field, bool) {
+	switch {
+	case secretKeys[f.Key]:
+		return zap.String(f.Key, redacted), true
+	case emailKeys[f.Key]:
+		if f.Type != zapcore.StringType {
+			return zap.String(f.Key, redacted), true
+		}
+		return zap.String(f.Key, p.email(f.String)), p.Email != "plain"
+	case ipKeys[f.Key]:
+		if f.Type != zapcore.StringType {
+			return zap.String(f.Key, redacted), true
+		}
+		if p.IP == "drop" {
+			return zapcore.Field{}, true
+		}
+		return zap.String(f.Key, truncateIP(f.String)), p.IP != "plain"
+	case f.Type == zapcore.ErrorType:
+		err, ok := f.Interface.(error)
+		if !ok {
+			return f, false
+		}
+		return zap.String(f.Key, p.text(err.Error())), true
+	case textKeys[f.Key]:
+		if f.Type != zapcore.StringType {
+			return zap.String(f.Key, redacted), true
+		}
+		text := p.text(f.String)
+		return zap.String(f.Key, text), text != f.String
+	}
+	return f, false
+}
+
+// text rewrites the emails and tokens in free text.
+func (p Policy) text(s string) string {
+	s = tokenPattern.ReplaceAllLiteralString(s, redacted)
+	if p.Email == "plain" {
+		return s
+	}
+	return emailPattern.ReplaceAllStringFunc(s, p.email)
+}
+
+func (p Policy) email(email string) string {
+	switch p.Email {
+	case "plain":
+		return email
+	case "hash":
+		return HashEmail(email, p.HashSalt)
+	}
+	return MaskEmail(email)
+}
+
+// MaskEmail keeps the first character of the local part and the domain.
+func MaskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return redacted
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// HashEmail gives a stable pseudonym for email, so one user's lines can still
+// be followed without the address being readable.
+func HashEmail(email, salt string) string {
+	sum := sha256.Sum256([]byte(salt + strings.ToLower(email)))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// truncateIP zeroes the host part of addr. Anything that doesn't parse as an
+// IP is dropped entirely.
+func truncateIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return redacted
+	}
+	bits := 48
+	if ip.Is4() || ip.Is4In6() {
+		ip, bits = ip.Unmap(), 24
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return redacted
+	}
+	return prefix.String()
+}
@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// testToken looks like the tokens the service hands out.
+const testToken = "q8Yv2sVx0bQ3kLm9TzR1wNc4HdF7gJp5eUa6iOy_-Xs"
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		field  zap.Field
+		want   string
+	}{
+		{"token", Policy{Email: "plain", IP: "plain"}, zap.String("token", "abc"), `"token":"[redacted]"`},
+		{"password", Policy{Email: "plain", IP: "plain"}, zap.String("password", "hunter2"), `"password":"[redacted]"`},
+		{"masked email", Policy{Email: "mask"}, zap.String("email", "jane@example.com"), `"email":"j***@example.com"`},
+		{"hashed email", Policy{Email: "hash", HashSalt: "s"}, zap.String("email", "jane@example.com"), `"email":"` + HashEmail("jane@example.com", "s") + `"`},
+		{"plain email", Policy{Email: "plain"}, zap.String("newEmail", "jane@example.com"), `"newEmail":"jane@example.com"`},
+		{"email that is no string", Policy{Email: "plain"}, zap.Int("email", 1), `"email":"[redacted]"`},
+		{"caller address", Policy{Email: "mask"}, zap.String("caller", "jane@example.com"), `"caller":"j***@example.com"`},
+		{"caller name", Policy{Email: "mask"}, zap.String("caller", "billing"), `"caller":"[redacted]"`},
+		{"truncated ipv4", Policy{IP: "truncate"}, zap.String("ip", "192.0.2.77"), `"ip":"192.0.2.0/24"`},
+		{"truncated peer", Policy{IP: "truncate"}, zap.String("peer", "[2001:db8:1:2::7]:443"), `"peer":"2001:db8:1::/48"`},
+		{"not an ip", Policy{IP: "truncate"}, zap.String("ip", "localhost"), `"ip":"[redacted]"`},
+		{"other keys", Policy{Email: "mask", IP: "drop"}, zap.String("role", "admin"), `"role":"admin"`},
+		{"email in an error", Policy{Email: "mask"}, zap.Error(errors.New("user jane@example.com not found")), `"error":"user j***@example.com not found"`},
+		{"token in an error", Policy{Email: "plain"}, zap.Error(errors.New("no session for " + testToken)), `"error":"no session for [redacted]"`},
+		{"named error", Policy{Email: "hash", HashSalt: "s"}, zap.NamedError("cause", errors.New("jane@example.com")), `"cause":"` + HashEmail("jane@example.com", "s") + `"`},
+		{"plain error", Policy{Email: "mask"}, zap.Error(errors.New("connection refused")), `"error":"connection refused"`},
+		{"error text", Policy{Email: "mask"}, zap.String("error", "POST https://partner.example.com/hook?token="+testToken), `"error":"POST https://partner.example.com/hook?token=[redacted]"`},
+		{"subject", Policy{Email: "mask"}, zap.String("subject", "Your email was changed to janet@example.com"), `"subject":"Your email was changed to j***@example.com"`},
+		{"subject that is no string", Policy{Email: "mask"}, zap.Int("subject", 1), `"subject":"[redacted]"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := logLine(tt.policy, tt.field)
+			if !strings.Contains(line, tt.want) {
+				t.Errorf("logged %s, want it to contain %s", line, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactDroppedIP(t *testing.T) {
+	line := logLine(Policy{IP: "drop"}, zap.String("ip", "192.0.2.77"))
+	if strings.Contains(line, `"ip"`) {
+		t.Errorf("logged %s, want no ip", line)
+	}
+}
+
+func TestRedactWith(t *testing.T) {
+	var buf bytes.Buffer
+	log := zap.New(NewRedactingCore(jsonCore(&buf), Policy{Email: "mask"}))
+	log.With(zap.String("email", "jane@example.com")).Info("test")
+	if strings.Contains(buf.String(), "jane@") {
+		t.Errorf("logged %s, want the email masked", buf.String())
+	}
+}
+
+func logLine(policy Policy, field zap.Field) string {
+	var buf bytes.Buffer
+	zap.New(NewRedactingCore(jsonCore(&buf), policy)).Info("test", field)
+	return buf.String()
+}
+
+func jsonCore(buf *bytes.Buffer) zapcore.Core {
+	return zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(buf), zapcore.DebugLevel)
+}
@@ -0,0 +1,106 @@
+// Package mailaddr parses and checks the email addresses accounts use.
+package mailaddr
+
+import (
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Error codes, stable so clients can show their own messages.
+const (
+	CodeInvalid          = "invalid"
+	CodeTooLong          = "too_long"
+	CodeInvalidDomain    = "invalid_domain"
+	CodeDisposable       = "disposable"
+	CodeDomainNotAllowed = "domain_not_allowed"
+	CodeDomainDenied     = "domain_denied"
+)
+
+// Limits from RFC 5321, in octets.
+const (
+	maxLength       = 254
+	maxLocalLength  = 64
+	maxDomainLength = 253
+)
+
+// Error is returned for an address that can't be used, Code says why.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "invalid email: " + e.Message
+}
+
+func invalid(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+var profile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.VerifyDNSLength(true),
+	idna.StrictDomainName(true),
+)
+
+// Normalize checks that addr is a bare address, such as "user@example.com",
+// and returns it in the form it is stored in: lower case, with the domain
+// in its ASCII (punycode) form. Display names, comments, quoted local parts
+// and IP literal domains are rejected.
+func Normalize(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if len(addr) > 4*maxLength {
+		return "", invalid(CodeTooLong, "is longer than 254 bytes")
+	}
+	if !utf8.ValidString(addr) {
+		return "", invalid(CodeInvalid, "is not an email address")
+	}
+	parsed, err := mail.ParseAddress(addr)
+	// anything but the bare address, like a display name, was dropped
+	if err != nil || parsed.Name != "" || parsed.Address != addr {
+		return "", invalid(CodeInvalid, "is not an email address")
+	}
+	at := strings.LastIndexByte(addr, '@')
+	local, domain := strings.ToLower(addr[:at]), addr[at+1:]
+	if len(local) > maxLocalLength {
+		return "", invalid(CodeTooLong, "has a local part longer than 64 bytes")
+	}
+	domain, err = normalizeDomain(domain)
+	if err != nil {
+		return "", err
+	}
+	addr = local + "@" + domain
+	if len(addr) > maxLength {
+		return "", invalid(CodeTooLong, "is longer than 254 bytes")
+	}
+	return addr, nil
+}
+
+// normalizeDomain returns the lower case ASCII form of an internet domain
+// name with at least two labels.
+func normalizeDomain(domain string) (string, error) {
+	ascii, err := profile.ToASCII(domain)
+	if err != nil || len(ascii) > maxDomainLength {
+		return "", invalid(CodeInvalidDomain, "has an invalid domain")
+	}
+	labels := strings.Split(ascii, ".")
+	tld := labels[len(labels)-1]
+	if len(labels) < 2 || strings.Trim(tld, "0123456789") == "" {
+		return "", invalid(CodeInvalidDomain, "has an invalid domain")
+	}
+	for _, label := range labels {
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", invalid(CodeInvalidDomain, "has an invalid domain")
+		}
+	}
+	return ascii, nil
+}
+
+// Domain returns the domain of a normalized address.
+func Domain(addr string) string {
+	return addr[strings.LastIndexByte(addr, '@')+1:]
+}
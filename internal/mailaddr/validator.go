@@ -0,0 +1,114 @@
+package mailaddr
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+)
+
+// Validator decides which addresses new accounts may use. Listed domains
+// match their subdomains too.
+type Validator struct {
+	allow      domainSet
+	deny       domainSet
+	disposable domainSet
+}
+
+// NewValidator builds a Validator from cfg, loading the disposable domain
+// list if one is configured.
+func NewValidator(cfg config.EmailConfig) (*Validator, error) {
+	v := &Validator{}
+	var err error
+	if v.allow, err = newDomainSet(cfg.AllowDomains); err != nil {
+		return nil, fmt.Errorf("allow_domains: %w", err)
+	}
+	if v.deny, err = newDomainSet(cfg.DenyDomains); err != nil {
+		return nil, fmt.Errorf("deny_domains: %w", err)
+	}
+	if cfg.DisposableList != "" {
+		if v.disposable, err = loadDomainList(cfg.DisposableList); err != nil {
+			return nil, fmt.Errorf("disposable_list: %w", err)
+		}
+	}
+	return v, nil
+}
+
+// Validate normalizes addr, see Normalize, and checks its domain against
+// the lists. A nil Validator only normalizes.
+func (v *Validator) Validate(addr string) (string, error) {
+	addr, err := Normalize(addr)
+	if err != nil || v == nil {
+		return addr, err
+	}
+	domain := Domain(addr)
+	switch {
+	case len(v.allow) > 0 && !v.allow.contains(domain):
+		return "", invalid(CodeDomainNotAllowed, "uses a domain that is not allowed")
+	case v.deny.contains(domain):
+		return "", invalid(CodeDomainDenied, "uses a domain that is not allowed")
+	case v.disposable.contains(domain):
+		return "", invalid(CodeDisposable, "uses a disposable email service")
+	}
+	return addr, nil
+}
+
+// domainSet holds normalized domain names.
+type domainSet map[string]struct{}
+
+func newDomainSet(domains []string) (domainSet, error) {
+	set := domainSet{}
+	for _, domain := range domains {
+		if err := set.add(domain); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// loadDomainList reads a file with a domain per line, ignoring blank lines
+// and # comments.
+func loadDomainList(path string) (domainSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	set := domainSet{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		domain, _, _ := strings.Cut(scanner.Text(), "#")
+		if domain = strings.TrimSpace(domain); domain == "" {
+			continue
+		}
+		if err := set.add(domain); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return set, scanner.Err()
+}
+
+func (s domainSet) add(domain string) error {
+	ascii, err := normalizeDomain(strings.TrimSpace(domain))
+	if err != nil {
+		return fmt.Errorf("invalid domain %q", domain)
+	}
+	s[ascii] = struct{}{}
+	return nil
+}
+
+// contains tells whether domain or one of its parent domains is in s.
+func (s domainSet) contains(domain string) bool {
+	for {
+		if _, ok := s[domain]; ok {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return false
+		}
+		domain = parent
+	}
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const namespace = "auth"
+
+// Metrics holds every collector the service exports. All methods are safe to
+// call on a nil *Metrics, so instrumented code works with metrics disabled.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	rpcRequests   *prometheus.CounterVec
+	rpcDuration   *prometheus.HistogramVec
+	logins        *prometheus.CounterVec
+	registrations *prometheus.CounterVec
+	oauthLogins   *prometheus.CounterVec
+	lockouts      prometheus.Counter
+	tokens        prometheus.Counter
+	logouts       prometheus.Counter
+	hashDuration  *prometheus.HistogramVec
+	dbDuration    *prometheus.HistogramVec
+	redisDuration *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		rpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "grpc", Name: "requests_total",
+			Help: "gRPC requests handled, by method and status code.",
+		}, []string{"method", "code"}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "grpc", Name: "request_duration_seconds",
+			Help:    "gRPC request latency, by method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "logins_total",
+			Help: "Password logins, by outcome.",
+		}, []string{"outcome"}),
+		registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "registrations_total",
+			Help: "Registrations, by outcome.",
+		}, []string{"outcome"}),
+		oauthLogins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "oauth_logins_total",
+			Help: "OAuth logins, by provider and outcome.",
+		}, []string{"provider", "outcome"}),
+		lockouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "lockouts_total",
+			Help: "Sign-ins refused because the account is disabled, suspended or banned.",
+		}),
+		tokens: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "tokens_created_total",
+			Help: "Session tokens issued.",
+		}),
+		logouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "logouts_total",
+			Help: "Sessions ended by logout.",
+		}),
+		hashDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "password_hash_duration_seconds",
+			Help:    "Time spent hashing or comparing passwords, by algorithm and operation.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"algorithm", "operation"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "postgres", Name: "query_duration_seconds",
+			Help:    "Postgres statement latency, by connection, operation and result.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"db", "operation", "result"}),
+		redisDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "redis", Name: "command_duration_seconds",
+			Help:    "Redis command latency, by command and result.",
+			Buckets: []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1},
+		}, []string{"command", "result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.rpcRequests, m.rpcDuration,
+		m.logins, m.registrations, m.oauthLogins, m.lockouts, m.tokens, m.logouts,
+		m.hashDuration, m.dbDuration, m.redisDuration,
+	)
+	return m
+}
+
+// Serve exposes /metrics on addr until ctx is done.
+func (m *Metrics) Serve(ctx context.Context, log *zap.Logger, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Info("metrics server is running", zap.String("addr", addr))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("metrics server failed", zap.Error(err))
+	}
+}
+
+func (m *Metrics) Login(outcome string) {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) Registration(outcome string) {
+	if m == nil {
+		return
+	}
+	m.registrations.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) OAuthLogin(provider, outcome string) {
+	if m == nil {
+		return
+	}
+	m.oauthLogins.WithLabelValues(provider, outcome).Inc()
+}
+
+func (m *Metrics) Lockout() {
+	if m == nil {
+		return
+	}
+	m.lockouts.Inc()
+}
+
+func (m *Metrics) TokenCreated() {
+	if m == nil {
+		return
+	}
+	m.tokens.Inc()
+}
+
+func (m *Metrics) Logout() {
+	if m == nil {
+		return
+	}
+	m.logouts.Inc()
+}
+
+func (m *Metrics) ObserveHash(algorithm, operation string, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.hashDuration.WithLabelValues(algorithm, operation).Observe(took.Seconds())
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Auth event types.
+const (
+	EventLogin          = "login"
+	EventOAuthLogin     = "oauth_login"
+	EventLogout         = "logout"
+	EventRegister       = "register"
+	EventPasswordChange = "password_change"
+	EventEmailChange    = "email_change"
+	EventEmailVerify    = "email_verify"
+	EventAdminAction    = "admin_action"
+	EventNewDevice      = "new_device"
+	EventLoginReported  = "login_reported"
+	EventAccountDelete  = "account_delete"
+	EventDataExport     = "data_export"
+)
+
+// Auth event results.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// AuthEvent is one entry of the security audit log. Rows are only inserted,
+// never updated, and go away once they are older than the retention period.
+type AuthEvent struct {
+	ID   int64  `gorm:"primaryKey;index:idx_auth_events_subject,priority:2"`
+	Type string `gorm:"size:32;not null;index"`
+	// Actor is who did it, Subject the account it was done to. They only
+	// differ for admin actions.
+	Actor     string `gorm:"size:255"`
+	Subject   string `gorm:"size:255;index:idx_auth_events_subject,priority:1"`
+	IP        string `gorm:"size:64"`
+	Country   string `gorm:"size:2"`
+	City      string `gorm:"size:128"`
+	ASN       uint
+	UserAgent string `gorm:"size:512"`
+	Result    string `gorm:"size:16;not null"`
+	// Reason says why the action failed, or adds detail such as the OAuth
+	// provider.
+	Reason    string    `gorm:"size:255"`
+	CreatedAt time.Time `gorm:"not null;index"`
+}
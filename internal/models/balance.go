@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Kinds of balance transactions.
+const (
+	TxCredit      = "credit"
+	TxDebit       = "debit"
+	TxTransferIn  = "transfer_in"
+	TxTransferOut = "transfer_out"
+	// TxOpening carries over the balance a user had in users.balance
+	// before the ledger existed.
+	TxOpening = "opening"
+)
+
+// BalanceTransaction is one entry of the balance ledger. Amounts are in
+// minor units of Currency, e.g. cents, positive for money coming in and
+// negative for money going out. Rows are only inserted, never updated or
+// deleted, a mistake is corrected with another transaction.
+type BalanceTransaction struct {
+	ID       int64  `gorm:"primaryKey"`
+	UserID   int    `gorm:"not null;index:idx_balance_transactions_user,priority:1;uniqueIndex:idx_balance_transactions_caller_key,priority:3"`
+	Currency string `gorm:"size:3;not null"`
+	Amount   int64  `gorm:"not null"`
+	Kind     string `gorm:"size:16;not null"`
+	// Caller is who made the transaction, the service for the balance RPCs.
+	// Idempotency keys are only unique per caller.
+	Caller string `gorm:"size:64;not null;default:'';uniqueIndex:idx_balance_transactions_caller_key,priority:1"`
+	// IdempotencyKey is chosen by the caller, both sides of a transfer
+	// share it.
+	IdempotencyKey string `gorm:"size:128;not null;uniqueIndex:idx_balance_transactions_caller_key,priority:2"`
+	// CounterpartyID is the other user of a transfer.
+	CounterpartyID *int
+	Description    string `gorm:"size:255"`
+	// BalanceAfter is the balance in Currency once the transaction was
+	// applied.
+	BalanceAfter int64     `gorm:"not null"`
+	CreatedAt    time.Time `gorm:"not null;index:idx_balance_transactions_user,priority:2"`
+}
+
+// Balance caches the sum of a user's ledger in one currency. It is written
+// in the transaction that adds to the ledger, the reconcile-balances
+// command checks that the two agree.
+type Balance struct {
+	UserID    int    `gorm:"primaryKey;autoIncrement:false"`
+	Currency  string `gorm:"primaryKey;size:3"`
+	Amount    int64  `gorm:"not null"`
+	UpdatedAt time.Time
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// AccountDeletion is a deletion a user asked for. The account is purged at
+// PurgeAt unless the user follows the cancel link before. Only the SHA-256
+// of the link token is stored.
+type AccountDeletion struct {
+	ID        int64  `gorm:"primaryKey"`
+	UserID    int    `gorm:"not null;uniqueIndex"`
+	TokenHash string `gorm:"size:64;not null;uniqueIndex"`
+	CreatedAt time.Time
+	PurgeAt   time.Time `gorm:"not null;index"`
+}
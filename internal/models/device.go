@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// KnownDevice is a device a user has signed in from before. Fingerprint is
+// derived from the network prefix and the user agent, so a new address in
+// the same network or a browser update still counts as the same device.
+type KnownDevice struct {
+	ID          int64  `gorm:"primaryKey"`
+	UserID      int    `gorm:"not null;uniqueIndex:idx_known_devices_user,priority:1"`
+	Fingerprint string `gorm:"size:64;not null;uniqueIndex:idx_known_devices_user,priority:2"`
+	IPPrefix    string `gorm:"size:64"`
+	UserAgent   string `gorm:"size:512"`
+	Country     string `gorm:"size:2"`
+	ASN         uint
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+}
+
+// LoginAlert backs the "this wasn't me" link sent for a suspicious sign-in.
+// Only the SHA-256 of the link token is stored.
+type LoginAlert struct {
+	ID        int64  `gorm:"primaryKey"`
+	TokenHash string `gorm:"size:64;not null;uniqueIndex"`
+	UserID    int    `gorm:"not null;index"`
+	DeviceID  int64
+	Reasons   string `gorm:"size:255"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UsedAt    *time.Time
+}
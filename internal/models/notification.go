@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Notification statuses.
+const (
+	NotificationPending = "pending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// Notification is a message queued for a user. It is rendered from Template
+// in Locale when it is sent, so template fixes apply to queued messages too.
+type Notification struct {
+	ID            int64             `gorm:"primaryKey"`
+	To            string            `gorm:"size:255;not null"`
+	Template      string            `gorm:"size:64;not null"`
+	Locale        string            `gorm:"size:16;not null"`
+	Data          map[string]string `gorm:"serializer:json;type:jsonb"`
+	Status        string            `gorm:"size:16;not null;index"`
+	Attempts      int               `gorm:"not null;default:0"`
+	NextAttemptAt time.Time         `gorm:"not null;index"`
+	LastError     string            `gorm:"size:1024"`
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// OutboxEvent is a domain event waiting to be published. It is written in
+// the same transaction as the change it describes and picked up by the
+// relay, so an event exists if and only if the change was committed.
+type OutboxEvent struct {
+	ID int64 `gorm:"primaryKey"`
+	// EventID is what consumers deduplicate on, it stays the same across
+	// delivery attempts.
+	EventID       string `gorm:"size:32;not null;uniqueIndex"`
+	Type          string `gorm:"size:64;not null"`
+	Version       int    `gorm:"not null"`
+	Payload       []byte `gorm:"type:jsonb;not null"`
+	CreatedAt     time.Time
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `gorm:"not null;index"`
+	LastError     string     `gorm:"size:1024"`
+	PublishedAt   *time.Time `gorm:"index"`
+}
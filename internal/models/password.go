@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// PasswordHistory holds the bcrypt hashes of passwords a user has had, so
+// they can't be set again. Only the newest few per user are kept.
+type PasswordHistory struct {
+	ID        int64  `gorm:"primaryKey"`
+	UserID    int    `gorm:"not null;index"`
+	PassHash  []byte `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
+
+// PasswordResetToken lets a user who signed in with the right password but
+// has to change it do only that. Only the SHA-256 of the token is stored.
+type PasswordResetToken struct {
+	ID        int64  `gorm:"primaryKey"`
+	TokenHash string `gorm:"size:64;not null;uniqueIndex"`
+	UserID    int    `gorm:"not null;index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UsedAt    *time.Time
+}
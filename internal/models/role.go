@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Role is a named set of permissions. Roles and their permissions are
+// defined in the config and written here at startup.
+type Role struct {
+	ID          int64        `gorm:"primaryKey"`
+	Name        string       `gorm:"size:64;not null;uniqueIndex"`
+	Description string       `gorm:"size:255"`
+	Permissions []Permission `gorm:"many2many:role_permissions"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Permission is something a role allows, such as "users.read".
+type Permission struct {
+	ID   int64  `gorm:"primaryKey"`
+	Name string `gorm:"size:128;not null;uniqueIndex"`
+}
+
+// UserRole assigns a role to a user.
+type UserRole struct {
+	UserID int   `gorm:"primaryKey"`
+	RoleID int64 `gorm:"primaryKey;index"`
+	// GrantedBy is who assigned the role, "config" for grants from the
+	// config file.
+	GrantedBy string `gorm:"size:255"`
+	CreatedAt time.Time
+}
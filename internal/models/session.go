@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type Session struct {
+	// ID is the SHA-256 of the token, the token itself is never stored.
+	ID      string `gorm:"primaryKey;size:64"`
+	Email   string `gorm:"index"`
+	IP      string `gorm:"size:64"`
+	Country string `gorm:"size:2"`
+	City    string `gorm:"size:128"`
+	ASN     uint
+	// Roles and Permissions are copied from the user when AccessCheckedAt
+	// was set, see storage.Access.
+	Roles           []string `gorm:"serializer:json;type:jsonb"`
+	Permissions     []string `gorm:"serializer:json;type:jsonb"`
+	AccessCheckedAt time.Time
+	CreatedAt       time.Time
+	ExpiresAt       time.Time `gorm:"index"`
+}
@@ -5,6 +5,27 @@ import (
 	"time"
 )
 
+// User statuses. Only active users can sign in or use their sessions.
+const (
+	UserActive   = "active"
+	UserDisabled = "disabled"
+	UserBanned   = "banned"
+	// UserPendingDeletion is set when the user asked for their account to
+	// be deleted, until it is.
+	UserPendingDeletion = "pending_deletion"
+)
+
+// Why a user has to set a new password before signing in again.
+const (
+	// ResetReasonReported is set when a sign-in is reported as not the
+	// user's. Whoever made it may know the password, so it is never enough
+	// to pick a new one.
+	ResetReasonReported = "reported"
+	// ResetReasonAdmin is set by an administrator, for example after an
+	// incident.
+	ResetReasonAdmin = "admin"
+)
+
 type User struct {
 	gorm.Model
 	ID            int    `gorm:"primary_key"`
@@ -13,6 +34,36 @@ type User struct {
 	PassHash      []byte
 	IpCreated     string
 	LastLoginIp   string
+	// Balance is the float balance other services used to write directly.
+	// The migrate-balances command carries it into the ledger as an
+	// opening entry, nothing else reads it.
 	Balance       float64
 	LastLoginDate time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	// PasswordChangedAt is when the password was last set, it drives the
+	// maximum password age.
+	PasswordChangedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	// PasswordResetRequired blocks password logins until the password is
+	// changed. PasswordResetReason says who asked for it, see the
+	// ResetReason constants.
+	PasswordResetRequired bool   `gorm:"not null;default:false"`
+	PasswordResetReason   string `gorm:"size:16"`
+	// Status is one of the User constants, see StatusAt for the one in
+	// effect.
+	Status string `gorm:"size:16;not null;default:active;index"`
+	// StatusReason is why the status was changed.
+	StatusReason string `gorm:"size:255"`
+	// StatusUntil ends a temporary status, nil keeps it until it is
+	// changed.
+	StatusUntil *time.Time
+	// Version is bumped on every optimistic update, see database.UpdateUser.
+	Version int `gorm:"not null;default:1"`
+}
+
+// StatusAt returns the status in effect at now, which is active once a
+// temporary status has run out.
+func (u User) StatusAt(now time.Time) string {
+	if u.StatusUntil != nil && !now.Before(*u.StatusUntil) {
+		return UserActive
+	}
+	return u.Status
 }
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// Webhook delivery statuses. Dead deliveries ran out of attempts and stay
+// around until someone redelivers them.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookSubscription is a partner endpoint that receives events over HTTP.
+// An empty EventTypes means every event.
+type WebhookSubscription struct {
+	ID         int64    `gorm:"primaryKey"`
+	URL        string   `gorm:"size:2048;not null"`
+	Secret     string   `gorm:"size:128;not null"`
+	EventTypes []string `gorm:"serializer:json;type:jsonb"`
+	Active     bool     `gorm:"not null;default:true"`
+	// ConsecutiveFailures counts failed attempts since the last success, the
+	// subscription is switched off once it reaches the configured limit.
+	ConsecutiveFailures int    `gorm:"not null;default:0"`
+	DisabledReason      string `gorm:"size:255"`
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// Wants reports whether the subscription takes events of eventType.
+func (s WebhookSubscription) Wants(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event on its way to one subscription.
+type WebhookDelivery struct {
+	ID             int64     `gorm:"primaryKey"`
+	SubscriptionID int64     `gorm:"not null;index"`
+	EventID        string    `gorm:"size:32;not null"`
+	EventType      string    `gorm:"size:64;not null"`
+	Payload        []byte    `gorm:"type:jsonb;not null"`
+	Status         string    `gorm:"size:16;not null;index"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"not null;index"`
+	LastStatusCode int
+	LastError      string `gorm:"size:1024"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+// WebhookAttempt logs a single HTTP request made for a delivery.
+type WebhookAttempt struct {
+	ID         int64 `gorm:"primaryKey"`
+	DeliveryID int64 `gorm:"not null;index"`
+	StatusCode int
+	Error      string `gorm:"size:1024"`
+	DurationMs int64
+	CreatedAt  time.Time
+}
@@ -0,0 +1,34 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileNotifier writes every message as an .eml file into a directory, which
+// is handy for looking at real messages during development.
+type FileNotifier struct {
+	dir  string
+	from string
+	seq  atomic.Int64
+}
+
+func NewFileNotifier(dir, from string) (*FileNotifier, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &FileNotifier{dir: dir, from: from}, nil
+}
+
+func (n *FileNotifier) Send(ctx context.Context, msg Message) error {
+	raw, err := build(n.from, msg)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102-150405"), n.seq.Add(1))
+	return os.WriteFile(filepath.Join(n.dir, name), raw, 0o640)
+}
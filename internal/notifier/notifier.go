@@ -0,0 +1,37 @@
+// Package notifier sends messages to users. Messages are queued in the
+// database and sent by a Queue worker, so a slow mail server never holds
+// up a request.
+package notifier
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// Message is a rendered message ready to be sent.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Notifier delivers a single message.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogNotifier only logs that a message would have been sent.
+type LogNotifier struct {
+	log *zap.Logger
+}
+
+func NewLogNotifier(log *zap.Logger) *LogNotifier {
+	return &LogNotifier{log: log}
+}
+
+func (n *LogNotifier) Send(ctx context.Context, msg Message) error {
+	n.log.Info("notification", zap.String("email", msg.To), zap.String("subject", msg.Subject))
+	return nil
+}
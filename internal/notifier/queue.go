@@ -0,0 +1,170 @@
+package notifier
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+const (
+	pruneInterval = time.Hour
+	maxErrorLen   = 1024
+)
+
+// Enqueue queues a message for to through db. Pass a transaction to send it
+// only if the change it reports is committed.
+func Enqueue(ctx context.Context, db database.NotificationStore, to, template, locale string, data map[string]string) error {
+	return db.AddNotification(ctx, &models.Notification{
+		To:            to,
+		Template:      template,
+		Locale:        locale,
+		Data:          data,
+		Status:        models.NotificationPending,
+		NextAttemptAt: time.Now(),
+	})
+}
+
+// Queue sends queued notifications. It leases the ones it takes for
+// cfg.Lease and sends them without holding a transaction, so several
+// queues can run side by side. Failed sends are retried with backoff until
+// cfg.MaxAttempts, after which the notification is marked failed.
+type Queue struct {
+	db        database.Database
+	notifier  Notifier
+	templates *Templates
+	log       *zap.Logger
+	cfg       config.NotifierConfig
+}
+
+func NewQueue(db database.Database, notifier Notifier, templates *Templates, log *zap.Logger, cfg config.NotifierConfig) *Queue {
+	return &Queue{db: db, notifier: notifier, templates: templates, log: log, cfg: cfg}
+}
+
+// Run sends due notifications every cfg.PollInterval until ctx is done.
+func (q *Queue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.cfg.PollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			n, err := q.SendBatch(ctx)
+			if err != nil {
+				q.log.Error("failed to send notifications", zap.Error(err))
+				break
+			}
+			if n < q.cfg.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+		if time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			q.prune(ctx)
+		}
+	}
+}
+
+// SendBatch sends up to cfg.BatchSize due notifications and returns how many
+// it handled.
+func (q *Queue) SendBatch(ctx context.Context) (int, error) {
+	due, err := q.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, n := range due {
+		q.send(ctx, &n)
+		if err := q.db.UpdateNotification(ctx, n); err != nil {
+			return len(due), err
+		}
+	}
+	return len(due), nil
+}
+
+// claim leases up to cfg.BatchSize due notifications by moving their next
+// attempt cfg.Lease ahead, which keeps other queues off them. A queue that
+// dies before recording the outcome leaves them to fall due again.
+func (q *Queue) claim(ctx context.Context) ([]models.Notification, error) {
+	var due []models.Notification
+	err := q.db.WithTx(ctx, func(tx database.Database) error {
+		now := time.Now()
+		var err error
+		due, err = tx.DueNotifications(ctx, now, q.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, n := range due {
+			n.NextAttemptAt = now.Add(q.cfg.Lease)
+			if err := tx.UpdateNotification(ctx, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return due, err
+}
+
+// send tries to deliver n once. The data of a notification that was sent or
+// given up on is dropped, it can hold one-time links with raw tokens.
+func (q *Queue) send(ctx context.Context, n *models.Notification) {
+	n.Attempts++
+	err := q.deliver(ctx, n)
+	now := time.Now()
+	if err == nil {
+		n.Status = models.NotificationSent
+		n.SentAt = &now
+		n.LastError = ""
+		n.Data = nil
+		return
+	}
+	n.LastError = err.Error()
+	if len(n.LastError) > maxErrorLen {
+		n.LastError = n.LastError[:maxErrorLen]
+	}
+	if n.Attempts >= q.cfg.MaxAttempts {
+		n.Status = models.NotificationFailed
+		n.Data = nil
+	} else {
+		n.NextAttemptAt = now.Add(events.Backoff(n.Attempts, q.cfg.MaxBackoff))
+	}
+	q.log.Warn("failed to send notification",
+		zap.Int64("notification_id", n.ID),
+		zap.String("template", n.Template),
+		zap.Int("attempts", n.Attempts),
+		zap.Error(err),
+	)
+}
+
+func (q *Queue) deliver(ctx context.Context, n *models.Notification) error {
+	data := map[string]string{"Product": q.cfg.Product}
+	for k, v := range n.Data {
+		data[k] = v
+	}
+	msg, err := q.templates.Render(n.Template, n.Locale, data)
+	if err != nil {
+		return err
+	}
+	msg.To = n.To
+	return q.notifier.Send(ctx, msg)
+}
+
+// prune drops notifications that were sent or given up on more than
+// cfg.Retention ago.
+func (q *Queue) prune(ctx context.Context) {
+	pruned, err := q.db.PruneNotifications(ctx, time.Now().Add(-q.cfg.Retention))
+	if err != nil {
+		q.log.Error("failed to prune notifications", zap.Error(err))
+		return
+	}
+	if pruned > 0 {
+		q.log.Info("old notifications pruned", zap.Int64("count", pruned))
+	}
+}
@@ -0,0 +1,156 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+)
+
+// SMTP sends mail through one server and keeps the connection open between
+// messages. An idle connection is dropped after cfg.IdleTimeout and any
+// error closes it, the next Send dials again.
+type SMTP struct {
+	cfg  config.SMTPConfig
+	from string
+
+	mu       sync.Mutex
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func NewSMTP(from string, cfg config.SMTPConfig) *SMTP {
+	return &SMTP{cfg: cfg, from: from}
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	raw, err := build(s.from, msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.send(client, msg.To, raw); err != nil {
+		s.close()
+		return err
+	}
+	s.lastUsed = time.Now()
+	return nil
+}
+
+// Close ends the current connection, if there is one.
+func (s *SMTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+	return nil
+}
+
+func (s *SMTP) send(client *smtp.Client, to string, raw []byte) error {
+	if err := s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		return err
+	}
+	if err := client.Mail(envelopeAddress(s.from)); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		_ = client.Reset()
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// connect returns the open connection if it is still usable, or dials a new
+// one: implicit TLS or STARTTLS as configured, then AUTH when a username is
+// set.
+func (s *SMTP) connect(ctx context.Context) (*smtp.Client, error) {
+	if s.client != nil {
+		if time.Since(s.lastUsed) < s.cfg.IdleTimeout {
+			_ = s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+			if s.client.Noop() == nil {
+				return s.client, nil
+			}
+		}
+		s.close()
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host, InsecureSkipVerify: s.cfg.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: s.cfg.Timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.cfg.TLS == "implicit" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := s.handshake(client, tlsConfig); err != nil {
+		client.Close()
+		return nil, err
+	}
+	s.conn, s.client = conn, client
+	return client, nil
+}
+
+func (s *SMTP) handshake(client *smtp.Client, tlsConfig *tls.Config) error {
+	if s.cfg.TLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username == "" {
+		return nil
+	}
+	return client.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host))
+}
+
+func (s *SMTP) close() {
+	if s.client != nil {
+		_ = s.client.Quit()
+		_ = s.client.Close()
+	}
+	s.conn, s.client = nil, nil
+}
+
+// envelopeAddress strips a display name: "Name <a@b>" becomes "a@b".
+func envelopeAddress(from string) string {
+	if addr, err := parseAddress(from); err == nil {
+		return addr
+	}
+	return from
+}
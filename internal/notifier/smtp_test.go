@@ -0,0 +1,315 @@
+package notifier
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+	"go.uber.org/zap"
+)
+
+// fakeSMTP is just enough of an SMTP server for the client in net/smtp:
+// no TLS, no AUTH, and it keeps every message it accepts.
+type fakeSMTP struct {
+	ln net.Listener
+	// reject makes RCPT fail for these addresses.
+	reject map[string]bool
+	// onData runs before a message is accepted.
+	onData func()
+
+	mu       sync.Mutex
+	messages []fakeMessage
+	conns    int
+}
+
+type fakeMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, reject: map[string]bool{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// config points an SMTPConfig at the server.
+func (s *fakeSMTP) config() config.SMTPConfig {
+	addr := s.ln.Addr().(*net.TCPAddr)
+	return config.SMTPConfig{
+		Host:        "127.0.0.1",
+		Port:        addr.Port,
+		TLS:         "none",
+		Timeout:     5 * time.Second,
+		IdleTimeout: time.Minute,
+	}
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...any) {
+		_ = tp.PrintfLine(format, args...)
+	}
+	reply("220 localhost ESMTP fake")
+	var msg fakeMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg = fakeMessage{from: trimPath(arg)}
+			reply("250 ok")
+		case "RCPT":
+			to := trimPath(arg)
+			if s.reject[to] {
+				reply("550 no such user")
+				continue
+			}
+			msg.to = append(msg.to, to)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			if s.onData != nil {
+				s.onData()
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "RSET":
+			msg = fakeMessage{}
+			reply("250 ok")
+		case "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// trimPath turns "FROM:<a@b>" into "a@b".
+func trimPath(arg string) string {
+	_, path, _ := strings.Cut(arg, ":")
+	path, _, _ = strings.Cut(path, " ")
+	return strings.Trim(path, "<>")
+}
+
+func (s *fakeSMTP) received() []fakeMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeMessage(nil), s.messages...)
+}
+
+func TestSMTPSend(t *testing.T) {
+	srv := newFakeSMTP(t)
+	s := NewSMTP("ServiceAuth <no-reply@example.com>", srv.config())
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, to := range []string{"jane@example.com", "bob@example.org"} {
+		msg := Message{To: to, Subject: "Привет", Text: "hello " + to, HTML: "<p>hello</p>"}
+		if err := s.Send(ctx, msg); err != nil {
+			t.Fatalf("Send(%s): %v", to, err)
+		}
+	}
+
+	got := srv.received()
+	if len(got) != 2 {
+		t.Fatalf("server got %d messages, want 2", len(got))
+	}
+	first := got[0]
+	if first.from != "no-reply@example.com" || len(first.to) != 1 || first.to[0] != "jane@example.com" {
+		t.Errorf("envelope = %s -> %v", first.from, first.to)
+	}
+	for _, want := range []string{"To: jane@example.com", "Subject: =?utf-8?q?", "multipart/alternative", "hello jane@example.com"} {
+		if !strings.Contains(first.data, want) {
+			t.Errorf("message lacks %q:\n%s", want, first.data)
+		}
+	}
+	srv.mu.Lock()
+	conns := srv.conns
+	srv.mu.Unlock()
+	if conns != 1 {
+		t.Errorf("dialed %d times, want the connection kept open", conns)
+	}
+}
+
+func TestSMTPRejectedRecipient(t *testing.T) {
+	srv := newFakeSMTP(t)
+	srv.reject["gone@example.com"] = true
+	s := NewSMTP("no-reply@example.com", srv.config())
+	defer s.Close()
+
+	err := s.Send(context.Background(), Message{To: "gone@example.com", Subject: "hi", Text: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("Send = %v, want the 550", err)
+	}
+	if err := s.Send(context.Background(), Message{To: "jane@example.com", Subject: "hi", Text: "hi"}); err != nil {
+		t.Fatalf("Send after a rejection: %v", err)
+	}
+}
+
+func newTestQueue(t *testing.T, srv *fakeSMTP, db *memory.Database, maxAttempts int) *Queue {
+	t.Helper()
+	templates, err := LoadTemplates("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSMTP("no-reply@example.com", srv.config())
+	t.Cleanup(func() { s.Close() })
+	return NewQueue(db, s, templates, zap.NewNop(), config.NotifierConfig{
+		Product:     "ServiceAuth",
+		BatchSize:   10,
+		MaxAttempts: maxAttempts,
+		MaxBackoff:  time.Minute,
+		Lease:       time.Minute,
+	})
+}
+
+func TestQueueSendsThroughSMTP(t *testing.T) {
+	srv := newFakeSMTP(t)
+	db := memory.NewDatabase(nil)
+	q := newTestQueue(t, srv, db, 3)
+	ctx := context.Background()
+	var claimedByOther int
+	srv.onData = func() {
+		// with the database held this would deadlock
+		other, _ := newTestQueue(t, srv, db, 3).claim(ctx)
+		claimedByOther = len(other)
+	}
+	if err := Enqueue(ctx, db, "jane@example.com", TemplateVerifyEmail, "en", map[string]string{"Link": "https://example.com/verify?t=1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := sendBatch(t, q)
+	if err != nil || n != 1 {
+		t.Fatalf("SendBatch = %d, %v, want 1", n, err)
+	}
+	if claimedByOther != 0 {
+		t.Errorf("a second queue took %d leased notifications, want none", claimedByOther)
+	}
+	got := srv.received()
+	if len(got) != 1 || !strings.Contains(got[0].data, "Confirm your email for ServiceAuth") ||
+		!strings.Contains(got[0].data, "https://example.com/verify?t=3D1") {
+		t.Fatalf("server got %+v", got)
+	}
+	if due, _ := db.DueNotifications(ctx, time.Now().Add(time.Hour), 10); len(due) != 0 {
+		t.Errorf("%d notifications still due", len(due))
+	}
+}
+
+func TestQueueDropsDataOnceSent(t *testing.T) {
+	srv := newFakeSMTP(t)
+	q := newTestQueue(t, srv, memory.NewDatabase(nil), 3)
+	n := models.Notification{
+		To:       "jane@example.com",
+		Template: TemplateAccountDeletion,
+		Locale:   "en",
+		Data:     map[string]string{"Link": "https://example.com/restore?token=secret"},
+		Status:   models.NotificationPending,
+	}
+	q.send(context.Background(), &n)
+	if n.Status != models.NotificationSent {
+		t.Fatalf("status = %q (%s), want sent", n.Status, n.LastError)
+	}
+	if n.Data != nil {
+		t.Errorf("data = %v, want it dropped once sent", n.Data)
+	}
+	if got := srv.received(); len(got) != 1 || !strings.Contains(got[0].data, "token=3Dsecret") {
+		t.Errorf("the message lacks the link: %+v", got)
+	}
+}
+
+func TestQueueRetriesThenGivesUp(t *testing.T) {
+	srv := newFakeSMTP(t)
+	srv.reject["gone@example.com"] = true
+	db := memory.NewDatabase(nil)
+	q := newTestQueue(t, srv, db, 2)
+	ctx := context.Background()
+	link := map[string]string{"Link": "https://example.com/verify?t=1"}
+	if err := Enqueue(ctx, db, "gone@example.com", TemplateVerifyEmail, "en", link); err != nil {
+		t.Fatal(err)
+	}
+
+	sendBatch(t, q)
+	due, _ := db.DueNotifications(ctx, time.Now().Add(time.Hour), 10)
+	if len(due) != 1 || due[0].Attempts != 1 || !strings.Contains(due[0].LastError, "550") {
+		t.Fatalf("due = %+v, want a retry after the 550", due)
+	}
+	if wait := time.Until(due[0].NextAttemptAt); wait <= 0 || wait > time.Second {
+		t.Errorf("next attempt in %v, want the first backoff", wait)
+	}
+	if due[0].Data["Link"] == "" {
+		t.Error("the link was dropped before the last attempt")
+	}
+
+	n := due[0]
+	q.send(ctx, &n)
+	if n.Status != models.NotificationFailed {
+		t.Errorf("status after %d attempts = %q, want failed", n.Attempts, n.Status)
+	}
+	if n.Data != nil {
+		t.Errorf("data = %v, want it dropped once given up on", n.Data)
+	}
+}
+
+// sendBatch runs SendBatch but gives up instead of hanging when the server
+// deadlocks on the database.
+func sendBatch(t *testing.T, q *Queue) (int, error) {
+	t.Helper()
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := q.SendBatch(context.Background())
+		done <- result{n, err}
+	}()
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendBatch did not return")
+		return 0, nil
+	}
+}
@@ -0,0 +1,104 @@
+package notifier
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Template names. Each lives in templates/<locale>/<name>.tmpl and defines
+// "subject", "text" and "html".
+const (
+	TemplateVerifyEmail     = "verify_email"
+	TemplatePasswordReset   = "password_reset"
+	TemplatePasswordChanged = "password_changed"
+	TemplateEmailChanged    = "email_changed"
+	TemplateNewDevice       = "new_device"
+	TemplateAccountDeletion = "account_deletion"
+)
+
+var ErrUnknownTemplate = errors.New("unknown template")
+
+//go:embed templates
+var templateFS embed.FS
+
+// Templates renders the embedded templates. A template missing in the
+// requested locale falls back to the default one.
+type Templates struct {
+	defaultLocale string
+	text          map[string]*texttemplate.Template
+	html          map[string]*htmltemplate.Template
+}
+
+func LoadTemplates(defaultLocale string) (*Templates, error) {
+	t := &Templates{
+		defaultLocale: defaultLocale,
+		text:          map[string]*texttemplate.Template{},
+		html:          map[string]*htmltemplate.Template{},
+	}
+	err := fs.WalkDir(templateFS, "templates", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) != ".tmpl" {
+			return err
+		}
+		src, err := templateFS.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		locale := path.Base(path.Dir(p))
+		key := locale + "/" + strings.TrimSuffix(path.Base(p), ".tmpl")
+		text, err := texttemplate.New(key).Option("missingkey=zero").Parse(string(src))
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", p, err)
+		}
+		html, err := htmltemplate.New(key).Option("missingkey=zero").Parse(string(src))
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", p, err)
+		}
+		t.text[key], t.html[key] = text, html
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.text[defaultLocale+"/"+TemplatePasswordChanged]; !ok {
+		return nil, fmt.Errorf("no templates for default locale %q", defaultLocale)
+	}
+	return t, nil
+}
+
+// Render fills the template name for locale with data. The message has no
+// recipient yet.
+func (t *Templates) Render(name, locale string, data any) (Message, error) {
+	key := locale + "/" + name
+	if _, ok := t.text[key]; !ok {
+		key = t.defaultLocale + "/" + name
+	}
+	text, ok := t.text[key]
+	if !ok {
+		return Message{}, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+	}
+	var msg Message
+	var buf bytes.Buffer
+	for _, part := range []struct {
+		name string
+		dst  *string
+	}{{"subject", &msg.Subject}, {"text", &msg.Text}} {
+		buf.Reset()
+		if err := text.ExecuteTemplate(&buf, part.name, data); err != nil {
+			return Message{}, err
+		}
+		*part.dst = strings.TrimSpace(buf.String())
+	}
+	buf.Reset()
+	if err := t.html[key].ExecuteTemplate(&buf, "html", data); err != nil {
+		return Message{}, err
+	}
+	msg.HTML = strings.TrimSpace(buf.String())
+	return msg, nil
+}
@@ -0,0 +1,176 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Breached tells whether a password is known from a data breach.
+type Breached interface {
+	Contains(password string) (bool, error)
+}
+
+// filterMagic starts every filter file, the digit is the format version.
+var filterMagic = [8]byte{'B', 'R', 'E', 'A', 'C', 'H', 'F', '1'}
+
+// Filter is a Bloom filter over the SHA-1 hashes of breached passwords. It
+// has no false negatives, and false positives at the rate it was built for.
+//
+// The file is the magic, then the number of hash functions (uint32), the
+// number of bits (uint64) and the number of hashes added (uint64), all big
+// endian, then the bits.
+type Filter struct {
+	k    uint32
+	m    uint64
+	n    uint64
+	bits []byte
+}
+
+// NewFilter returns an empty filter sized for n hashes at false positive
+// rate p.
+func NewFilter(n uint64, p float64) *Filter {
+	if n == 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = (m + 7) / 8 * 8
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &Filter{k: k, m: m, bits: make([]byte, m/8)}
+}
+
+// LoadFilter reads a filter written by WriteTo.
+func LoadFilter(path string) (*Filter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var header struct {
+		Magic [8]byte
+		K     uint32
+		M, N  uint64
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("read filter header: %w", err)
+	}
+	if header.Magic != filterMagic || header.K == 0 || header.M == 0 || header.M%8 != 0 {
+		return nil, errors.New("not a breached password filter")
+	}
+	filter := &Filter{k: header.K, m: header.M, n: header.N, bits: make([]byte, header.M/8)}
+	if _, err := io.ReadFull(r, filter.bits); err != nil {
+		return nil, fmt.Errorf("read filter: %w", err)
+	}
+	return filter, nil
+}
+
+// Add adds the SHA-1 hash of a password.
+func (f *Filter) Add(sum [sha1.Size]byte) {
+	h1, h2 := split(sum)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+	f.n++
+}
+
+func (f *Filter) Contains(password string) (bool, error) {
+	h1, h2 := split(sha1.Sum([]byte(password)))
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Len is the number of hashes added.
+func (f *Filter) Len() uint64 {
+	return f.n
+}
+
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	header := struct {
+		Magic [8]byte
+		K     uint32
+		M, N  uint64
+	}{filterMagic, f.k, f.m, f.n}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return 0, err
+	}
+	n, err := w.Write(f.bits)
+	return int64(binary.Size(header) + n), err
+}
+
+// split derives the two hashes for double hashing from a SHA-1, which is
+// already uniformly distributed.
+func split(sum [sha1.Size]byte) (uint64, uint64) {
+	// an odd step visits different bits for every i
+	return binary.BigEndian.Uint64(sum[0:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
+
+// ParseHashLine parses a "HASH:COUNT" line of the Pwned Passwords SHA-1
+// dump, a bare HASH counts once. ok is false for lines that aren't one.
+func ParseHashLine(line string) (sum [sha1.Size]byte, count int, ok bool) {
+	hash, rest, _ := strings.Cut(strings.TrimSpace(line), ":")
+	if len(hash) != hex.EncodedLen(sha1.Size) {
+		return sum, 0, false
+	}
+	if _, err := hex.Decode(sum[:], []byte(hash)); err != nil {
+		return sum, 0, false
+	}
+	if rest == "" {
+		return sum, 1, true
+	}
+	count, err := strconv.Atoi(rest)
+	return sum, count, err == nil
+}
+
+// RangeDir looks passwords up in a directory of Pwned Passwords range
+// files, as written by the official downloader: one file per 5 character
+// hash prefix, named like 1E4C9.txt, holding "SUFFIX:COUNT" lines.
+type RangeDir struct {
+	dir string
+}
+
+func NewRangeDir(dir string) (*RangeDir, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &RangeDir{dir: dir}, nil
+}
+
+func (d *RangeDir) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	data, err := os.ReadFile(filepath.Join(d.dir, hash[:5]+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	suffix := []byte(hash[5:])
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) > len(suffix) && bytes.EqualFold(line[:len(suffix)], suffix) && line[len(suffix)] == ':' {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,181 @@
+// Package password decides whether a new password is acceptable.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/nbutton23/zxcvbn-go"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Violation codes, stable so clients can show their own messages.
+const (
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeMissingLower  = "missing_lower"
+	CodeMissingUpper  = "missing_upper"
+	CodeMissingDigit  = "missing_digit"
+	CodeMissingSymbol = "missing_symbol"
+	CodeTooWeak       = "too_weak"
+	CodeContainsEmail = "contains_email"
+	CodeReused        = "reused"
+	CodeBreached      = "breached"
+)
+
+// Violation is one rule a password breaks.
+type Violation struct {
+	Code    string
+	Message string
+}
+
+// PolicyError is returned for a password that breaks at least one rule.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	codes := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		codes[i] = v.Code
+	}
+	return "password rejected: " + strings.Join(codes, ", ")
+}
+
+// Candidate is a password together with what it is checked against.
+type Candidate struct {
+	Password string
+	Email    string
+	// Previous are bcrypt hashes of earlier passwords, newest first.
+	Previous [][]byte
+}
+
+type Policy struct {
+	cfg config.PasswordConfig
+	// breached is nil when cfg.Breached.Mode is "off".
+	breached Breached
+}
+
+func NewPolicy(cfg config.PasswordConfig, breached Breached) *Policy {
+	if cfg.Breached.Mode == "off" {
+		breached = nil
+	}
+	return &Policy{cfg: cfg, breached: breached}
+}
+
+// OpenBreached loads the breached password data cfg names, nil when the
+// check is off.
+func OpenBreached(cfg config.BreachedConfig) (Breached, error) {
+	switch {
+	case cfg.Mode == "off":
+		return nil, nil
+	case cfg.Filter != "":
+		return LoadFilter(cfg.Filter)
+	default:
+		return NewRangeDir(cfg.RangeDir)
+	}
+}
+
+// MaxLength is the longest password accepted, in bytes.
+func (p *Policy) MaxLength() int {
+	return p.cfg.MaxLength
+}
+
+// History is how many passwords, the current one included, are compared
+// against.
+func (p *Policy) History() int {
+	return p.cfg.History
+}
+
+// Check returns a *PolicyError listing every rule c breaks. Rules that only
+// warn, such as the breach check in "warn" mode, come back as warnings. Any
+// other error means the breach data couldn't be read.
+func (p *Policy) Check(c Candidate) (warnings []Violation, err error) {
+	var violations []Violation
+	add := func(code, format string, args ...any) {
+		violations = append(violations, Violation{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n := utf8.RuneCountInString(c.Password); n < p.cfg.MinLength {
+		add(CodeTooShort, "must be at least %d characters long", p.cfg.MinLength)
+	}
+	// bcrypt ignores everything past 72 bytes, so the limit is in bytes
+	if len(c.Password) > p.cfg.MaxLength {
+		add(CodeTooLong, "must be at most %d bytes long", p.cfg.MaxLength)
+		return nil, &PolicyError{Violations: violations}
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range c.Password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireLower && !lower {
+		add(CodeMissingLower, "must contain a lower case letter")
+	}
+	if p.cfg.RequireUpper && !upper {
+		add(CodeMissingUpper, "must contain an upper case letter")
+	}
+	if p.cfg.RequireDigit && !digit {
+		add(CodeMissingDigit, "must contain a digit")
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		add(CodeMissingSymbol, "must contain a symbol or space")
+	}
+
+	local, domain, _ := strings.Cut(strings.ToLower(c.Email), "@")
+	if p.cfg.RejectEmail && len(local) >= 3 && strings.Contains(strings.ToLower(c.Password), local) {
+		add(CodeContainsEmail, "must not contain your email address")
+	}
+	if p.cfg.MinScore > 0 {
+		inputs := []string{local, strings.Split(domain, ".")[0]}
+		if score := zxcvbn.PasswordStrength(c.Password, inputs).Score; score < p.cfg.MinScore {
+			add(CodeTooWeak, "is too easy to guess")
+		}
+	}
+
+	for i, hash := range c.Previous {
+		if i == p.cfg.History {
+			break
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(c.Password)) == nil {
+			if p.cfg.History == 1 {
+				add(CodeReused, "must differ from your current password")
+			} else {
+				add(CodeReused, "must differ from your last %d passwords", p.cfg.History)
+			}
+			break
+		}
+	}
+
+	if p.breached != nil {
+		breached, err := p.breached.Contains(c.Password)
+		if err != nil {
+			return nil, fmt.Errorf("check breached passwords: %w", err)
+		}
+		if breached {
+			v := Violation{Code: CodeBreached, Message: "has appeared in a data breach"}
+			if p.cfg.Breached.Mode == "reject" {
+				violations = append(violations, v)
+			} else {
+				warnings = append(warnings, v)
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return warnings, &PolicyError{Violations: violations}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+// ListUsers returns one page of users matching filter, oldest first, and the
+// token for the next page, which is empty on the last one.
+func (a *Auth) ListUsers(ctx context.Context, filter database.UserFilter, pageToken string) (users []models.User, nextPageToken string, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ListUsers")
+	defer func() { endSpan(span, err) }()
+	if pageToken != "" {
+		id, err := decodePageToken(pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		filter.AfterID = int(id)
+	}
+	pageSize := pageLimit(filter.Limit)
+	filter.Limit = pageSize + 1
+	users, err = a.db.Users(ctx, filter)
+	if err != nil {
+		a.logger(ctx).Error("failed to list users", zap.Error(err))
+		return nil, "", err
+	}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		nextPageToken = encodePageToken(int64(users[pageSize-1].ID))
+	}
+	a.auditAdmin(ctx, "", "users listed")
+	return users, nextPageToken, nil
+}
+
+// GetUser returns the user with the names of their roles.
+func (a *Auth) GetUser(ctx context.Context, email string) (user models.User, roles []string, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.GetUser")
+	defer func() { endSpan(span, err) }()
+	user, err = a.db.User(ctx, email)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			a.logger(ctx).Error("failed to get user", zap.String("email", email), zap.Error(err))
+		}
+		return models.User{}, nil, err
+	}
+	userRoles, err := a.db.UserRoles(ctx, email)
+	if err != nil {
+		a.logger(ctx).Error("failed to get roles", zap.String("email", email), zap.Error(err))
+		return models.User{}, nil, err
+	}
+	for _, role := range userRoles {
+		roles = append(roles, role.Name)
+	}
+	a.auditAdmin(ctx, email, "user viewed")
+	return user, roles, nil
+}
+
+// DisableUser keeps the user from signing in and ends their sessions until
+// until, or until EnableUser is called when until is zero.
+func (a *Auth) DisableUser(ctx context.Context, email, reason string, until time.Time) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.DisableUser")
+	defer func() { endSpan(span, err) }()
+	return a.suspend(ctx, email, models.UserDisabled, reason, until)
+}
+
+// BanUser is DisableUser for abuse, the status tells the user apart from
+// one disabled for other reasons.
+func (a *Auth) BanUser(ctx context.Context, email, reason string, until time.Time) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.BanUser")
+	defer func() { endSpan(span, err) }()
+	return a.suspend(ctx, email, models.UserBanned, reason, until)
+}
+
+func (a *Auth) suspend(ctx context.Context, email, status, reason string, until time.Time) error {
+	if err := a.setStatus(ctx, email, status, reason, until); err != nil {
+		return err
+	}
+	if err := a.sessions.DeleteAll(ctx, email); err != nil {
+		// sessions of a suspended user are rejected when they are used
+		a.logger(ctx).Error("failed to revoke sessions", zap.String("email", email), zap.Error(err))
+	}
+	action := "user " + status
+	if !until.IsZero() {
+		action += " until " + until.UTC().Format(time.RFC3339)
+	}
+	if reason != "" {
+		action += ": " + reason
+	}
+	a.auditAdmin(ctx, email, action)
+	return nil
+}
+
+// EnableUser lets a disabled or banned user sign in again.
+func (a *Auth) EnableUser(ctx context.Context, email string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.EnableUser")
+	defer func() { endSpan(span, err) }()
+	if err = a.setStatus(ctx, email, models.UserActive, "", time.Time{}); err != nil {
+		return err
+	}
+	a.auditAdmin(ctx, email, "user enabled")
+	return nil
+}
+
+// setStatus changes the status of the user, a zero until keeps it until it
+// is changed again.
+func (a *Auth) setStatus(ctx context.Context, email, status, reason string, until time.Time) error {
+	_, err := a.modifyUser(ctx, a.db, email, func(user *models.User) {
+		user.Status = status
+		user.StatusReason = truncate(reason, 255)
+		user.StatusUntil = nil
+		if !until.IsZero() {
+			user.StatusUntil = &until
+		}
+	}, "status", "status_reason", "status_until")
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		a.logger(ctx).Error("failed to set user status", zap.String("email", email), zap.String("status", status), zap.Error(err))
+	}
+	return err
+}
+
+// ForceLogout ends every session of the user.
+func (a *Auth) ForceLogout(ctx context.Context, email string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ForceLogout")
+	defer func() { endSpan(span, err) }()
+	if _, err = a.db.User(ctx, email); err != nil {
+		return err
+	}
+	if err = a.sessions.DeleteAll(ctx, email); err != nil {
+		a.logger(ctx).Error("failed to revoke sessions", zap.String("email", email), zap.Error(err))
+		return err
+	}
+	a.auditAdmin(ctx, email, "sessions revoked")
+	return nil
+}
+
+// SetEmailVerified overrides whether the email of the user is verified.
+func (a *Auth) SetEmailVerified(ctx context.Context, email string, verified bool) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.SetEmailVerified")
+	defer func() { endSpan(span, err) }()
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		var was bool
+		_, err := a.modifyUser(ctx, tx, email, func(user *models.User) {
+			was = user.EmailVerified
+			user.EmailVerified = verified
+		}, "email_verified")
+		if err != nil || was || !verified {
+			return err
+		}
+		return emit(ctx, tx, events.TypeEmailVerified, events.EmailVerified{Email: email})
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			a.logger(ctx).Error("failed to set email verified", zap.String("email", email), zap.Error(err))
+		}
+		return err
+	}
+	if err := a.redis.SetEmailVerifiedCache(ctx, email, verified); err != nil {
+		a.logger(ctx).Error("error set email verified", zap.String("email", email), zap.Error(err))
+	}
+	if verified {
+		a.auditAdmin(ctx, email, "email marked verified")
+	} else {
+		a.auditAdmin(ctx, email, "email marked unverified")
+	}
+	return nil
+}
+
+// DeleteUser purges the user right away, without the grace period of
+// DeleteAccount.
+func (a *Auth) DeleteUser(ctx context.Context, email string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.DeleteUser")
+	defer func() { endSpan(span, err) }()
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		user, err := tx.User(ctx, email)
+		if err != nil {
+			return err
+		}
+		return a.purgeUser(ctx, tx, user)
+	})
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			a.logger(ctx).Error("failed to delete user", zap.String("email", email), zap.Error(err))
+		}
+		return err
+	}
+	a.afterPurge(ctx, email)
+	a.auditAdmin(ctx, email, "user deleted")
+	return nil
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type (
+	userAgentKey struct{}
+	actorKey     struct{}
+)
+
+// WithUserAgent returns a copy of ctx carrying the client's user agent, which
+// ends up in the audit log.
+func WithUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, userAgent)
+}
+
+func userAgentFrom(ctx context.Context) string {
+	userAgent, _ := ctx.Value(userAgentKey{}).(string)
+	return userAgent
+}
+
+// WithActor returns a copy of ctx carrying who the request was authorized
+// for, the email of an administrator or "service:<name>" for another
+// service. It is recorded as the actor of admin actions and scopes the
+// idempotency keys of ledger operations.
+func WithActor(ctx context.Context, email string) context.Context {
+	return context.WithValue(ctx, actorKey{}, email)
+}
+
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// audit records event in the audit log. A failed write is logged but never
+// fails the request that caused it.
+func (a *Auth) audit(ctx context.Context, event models.AuthEvent) {
+	if event.Actor == "" {
+		event.Actor = event.Subject
+	}
+	event.UserAgent = truncate(userAgentFrom(ctx), 512)
+	if event.IP != "" {
+		loc := a.geo.Lookup(event.IP)
+		event.Country, event.City, event.ASN = loc.Country, loc.City, loc.ASN
+	}
+	if err := a.db.RecordAuthEvent(ctx, &event); err != nil {
+		a.logger(ctx).Error("failed to record auth event", zap.String("type", event.Type), zap.Error(err))
+	}
+}
+
+// ListAuditEvents returns one page of events matching filter, newest first,
+// and the token for the next page, which is empty on the last one.
+func (a *Auth) ListAuditEvents(ctx context.Context, filter database.AuthEventFilter, pageToken string) (events []models.AuthEvent, nextPageToken string, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ListAuditEvents")
+	defer func() { endSpan(span, err) }()
+
+	if pageToken != "" {
+		filter.BeforeID, err = decodePageToken(pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	pageSize := pageLimit(filter.Limit)
+	filter.Limit = pageSize + 1
+
+	events, err = a.db.AuthEvents(ctx, filter)
+	if err != nil {
+		a.logger(ctx).Error("failed to list auth events", zap.Error(err))
+		return nil, "", err
+	}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		nextPageToken = encodePageToken(events[pageSize-1].ID)
+	}
+	return events, nextPageToken, nil
+}
+
+// PruneAuditLog deletes events older than retention, and expired sign-in
+// alert links and password reset tokens, every interval until ctx is done.
+func (a *Auth) PruneAuditLog(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := a.db.PruneAuthEvents(ctx, time.Now().Add(-retention))
+			if err != nil {
+				a.log.Error("failed to prune auth events", zap.Error(err))
+				continue
+			}
+			if pruned > 0 {
+				a.log.Info("old auth events pruned", zap.Int64("count", pruned))
+			}
+			if _, err := a.db.PruneLoginAlerts(ctx, time.Now()); err != nil {
+				a.log.Error("failed to prune login alerts", zap.Error(err))
+			}
+			if _, err := a.db.PrunePasswordResetTokens(ctx, time.Now()); err != nil {
+				a.log.Error("failed to prune password reset tokens", zap.Error(err))
+			}
+		}
+	}
+}
+
+// auditAdmin records an administrative action on the account subject, empty
+// for actions that don't concern one. Actions taken without an actor in ctx,
+// such as those of the maintenance commands, are recorded with the actor
+// "internal".
+func (a *Auth) auditAdmin(ctx context.Context, subject, action string) {
+	actor := actorFrom(ctx)
+	if actor == "" {
+		actor = "internal"
+	}
+	a.audit(ctx, models.AuthEvent{
+		Type:    models.EventAdminAction,
+		Actor:   actor,
+		Subject: subject,
+		Result:  models.ResultSuccess,
+		Reason:  truncate(action, 255),
+	})
+}
+
+// pageLimit turns a requested page size into the one actually used.
+func pageLimit(requested int) int {
+	if requested <= 0 {
+		return defaultPageSize
+	}
+	return min(requested, maxPageSize)
+}
+
+func encodePageToken(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodePageToken(token string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrInvalidPageToken
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidPageToken
+	}
+	return id, nil
+}
+
+func succeeded(eventType, subject, ip string) models.AuthEvent {
+	return models.AuthEvent{Type: eventType, Subject: subject, IP: ip, Result: models.ResultSuccess}
+}
+
+func failed(eventType, subject, ip, reason string) models.AuthEvent {
+	return models.AuthEvent{Type: eventType, Subject: subject, IP: ip, Result: models.ResultFailure, Reason: reason}
+}
@@ -1,146 +1,400 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/geoip"
+	"github.com/GosMachine/ServiceAuth/internal/logger"
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"github.com/GosMachine/ServiceAuth/internal/metrics"
 	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/password"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
 	"github.com/GosMachine/ServiceAuth/internal/storage/database"
 	"github.com/GosMachine/ServiceAuth/internal/storage/redis"
+	"github.com/GosMachine/ServiceAuth/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var ErrInvalidCredentials = errors.New("invalid credentials")
 
+const (
+	maxUpdateAttempts    = 3
+	defaultResetTokenTTL = 15 * time.Minute
+)
+
 type Auth struct {
 	log                *zap.Logger
 	db                 database.Database
 	tokenTTL           time.Duration
 	rememberMeTokenTTL time.Duration
 	redis              redis.Service
+	sessions           storage.SessionStore
+	metrics            *metrics.Metrics
+	tracer             trace.Tracer
+	allowHTTPWebhooks  bool
+	notify             bool
+	locale             string
+	// devices is nil when device tracking is off.
+	devices       *config.DevicesConfig
+	geo           *geoip.DB
+	countries     geoip.Policy
+	blockLogin    bool
+	blockRegister bool
+	passwords     *password.Policy
+	emails        *mailaddr.Validator
+	// maxPasswordAge is zero when passwords don't expire.
+	maxPasswordAge time.Duration
+	resetTokenTTL  time.Duration
+	accessRefresh  time.Duration
+	deletion       config.DeletionConfig
+	export         config.ExportConfig
+}
+
+// Option configures the optional parts of Auth.
+type Option func(a *Auth)
+
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(a *Auth) {
+		a.metrics = m
+	}
 }
 
-func New(log *zap.Logger, db database.Database, redis redis.Service, tokenTTL, rememberMeTokenTTL time.Duration) *Auth {
-	return &Auth{
+// WithTracerProvider replaces the global tracer provider, tests use it to
+// record spans in memory.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(a *Auth) {
+		a.tracer = tp.Tracer(tracing.Instrumentation)
+	}
+}
+
+func New(log *zap.Logger, db database.Database, redis redis.Service, sessions storage.SessionStore, tokenTTL, rememberMeTokenTTL time.Duration, opts ...Option) *Auth {
+	a := &Auth{
 		log:                log,
 		db:                 db,
 		redis:              redis,
+		sessions:           sessions,
 		tokenTTL:           tokenTTL,
 		rememberMeTokenTTL: rememberMeTokenTTL,
+		resetTokenTTL:      defaultResetTokenTTL,
+		accessRefresh:      defaultAccessRefresh,
+		tracer:             otel.Tracer(tracing.Instrumentation),
 	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
 }
 
 // oauth for google, github, etc.
-func (a *Auth) OAuth(email, ip string) (string, time.Duration, error) {
-	log := a.log.With(
+func (a *Auth) OAuth(ctx context.Context, email, ip, provider string) (token string, tokenTTL time.Duration, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.OAuth")
+	defer func() { endSpan(span, err) }()
+	normalized, err := mailaddr.Normalize(email)
+	if err != nil {
+		a.logger(ctx).Info("email rejected", zap.String("email", email), zap.Error(err))
+		a.metrics.OAuthLogin(provider, "invalid_email")
+		a.audit(ctx, failed(models.EventOAuthLogin, email, ip, "invalid_email"))
+		return "", 0, err
+	}
+	email = normalized
+	log := a.logger(ctx).With(
 		zap.String("email", email),
 		zap.String("ip", ip),
+		zap.String("provider", provider),
 	)
 	log.Info("attempting to OAuth")
 
-	user, err := a.db.User(email)
+	user, err := a.db.User(ctx, email)
+	if client := a.client(ip); a.countryBlocked(client, err != nil) {
+		log.Warn("OAuth from blocked country", zap.String("country", client.Country))
+		a.metrics.OAuthLogin(provider, "country_blocked")
+		a.audit(ctx, failed(models.EventOAuthLogin, email, ip, "country_blocked"))
+		return "", 0, ErrCountryBlocked
+	}
 	if err != nil {
-		err = a.db.CreateUser(email, ip, []byte{}, true)
+		// the domain lists only keep new accounts out
+		if _, err := a.validateEmail(ctx, email); err != nil {
+			a.metrics.OAuthLogin(provider, "invalid_email")
+			a.audit(ctx, failed(models.EventOAuthLogin, email, ip, "invalid_email"))
+			return "", 0, err
+		}
+		err = a.db.WithTx(ctx, func(tx database.Database) error {
+			if err := tx.CreateUser(ctx, email, ip, []byte{}, true); err != nil {
+				return err
+			}
+			return emit(ctx, tx, events.TypeUserRegistered, events.UserRegistered{
+				Email:         email,
+				EmailVerified: true,
+				Provider:      provider,
+			})
+		})
 		if err != nil {
 			log.Error("failed to create user", zap.Error(err))
+			a.metrics.OAuthLogin(provider, "error")
+			a.audit(ctx, failed(models.EventOAuthLogin, email, ip, "internal_error"))
 			return "", 0, err
 		}
+		a.rememberDevice(ctx, email, ip)
 	} else {
-		user.EmailVerified = true
-		if err = a.updateUser(user, ip); err != nil {
+		if err := accountStatus(user); err != nil {
+			log.Info("account is not active", zap.Error(err))
+			a.metrics.OAuthLogin(provider, "inactive")
+			a.metrics.Lockout()
+			a.audit(ctx, failed(models.EventOAuthLogin, email, ip, "account_"+user.Status))
+			return "", 0, err
+		}
+		if user.PasswordResetRequired {
+			log.Info("password reset required")
+			a.metrics.OAuthLogin(provider, "password_reset_required")
+			a.audit(ctx, failed(models.EventOAuthLogin, email, ip, "password_reset_required"))
+			return "", 0, ErrPasswordResetRequired
+		}
+		err = a.db.WithTx(ctx, func(tx database.Database) error {
+			if !user.EmailVerified {
+				if err := tx.EmailVerify(ctx, email); err != nil {
+					return err
+				}
+				if err := emit(ctx, tx, events.TypeEmailVerified, events.EmailVerified{Email: email}); err != nil {
+					return err
+				}
+			}
+			return tx.UpdateLastLogin(ctx, user.ID, ip, time.Now())
+		})
+		if err != nil {
 			log.Error("failed to update user", zap.Error(err))
+			a.metrics.OAuthLogin(provider, "error")
+			a.audit(ctx, failed(models.EventOAuthLogin, email, ip, "internal_error"))
 			return "", 0, err
 		}
+		a.checkDevice(ctx, user, ip, user.LastLoginDate)
 	}
-	token, tokenTTL := a.createToken(email, "on")
+	token, tokenTTL = a.createToken(ctx, email, ip, "on")
 	if token == "" {
 		log.Error("failed to generate token", zap.Error(err))
+		a.metrics.OAuthLogin(provider, "error")
+		a.audit(ctx, failed(models.EventOAuthLogin, email, ip, "internal_error"))
 		return "", 0, fmt.Errorf("failed to generate token")
 	}
 
+	a.metrics.OAuthLogin(provider, "success")
+	event := succeeded(models.EventOAuthLogin, email, ip)
+	event.Reason = provider
+	a.audit(ctx, event)
 	log.Info("OAuth successfully")
 	return token, tokenTTL, nil
 }
 
-func (a *Auth) Login(email, password, ip, rememberMe string) (string, time.Duration, error) {
-	log := a.log.With(
+func (a *Auth) Login(ctx context.Context, email, password, ip, rememberMe string) (token string, tokenTTL time.Duration, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.Login")
+	defer func() { endSpan(span, err) }()
+	log := a.logger(ctx).With(
 		zap.String("email", email),
 		zap.String("ip", ip),
 	)
 	log.Info("attempting to login user")
+	if client := a.client(ip); a.countryBlocked(client, false) {
+		log.Warn("login from blocked country", zap.String("country", client.Country))
+		a.metrics.Login("country_blocked")
+		a.audit(ctx, failed(models.EventLogin, email, ip, "country_blocked"))
+		return "", 0, ErrCountryBlocked
+	}
 
-	user, err := a.db.User(email)
+	user, err := a.db.User(ctx, email)
 	if err != nil {
 		log.Error("failed to get user", zap.Error(err))
+		a.metrics.Login("invalid_credentials")
+		a.audit(ctx, failed(models.EventLogin, email, ip, "unknown_user"))
 		return "", 0, ErrInvalidCredentials
 	}
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
+	if err := a.comparePassword(ctx, user.PassHash, password); err != nil {
 		log.Info("passwords do not match", zap.Error(err))
+		a.metrics.Login("invalid_credentials")
+		a.audit(ctx, failed(models.EventLogin, email, ip, "invalid_password"))
 		return "", 0, ErrInvalidCredentials
 	}
-	if err = a.updateUser(user, ip); err != nil {
+	if err := accountStatus(user); err != nil {
+		log.Info("account is not active", zap.Error(err))
+		a.metrics.Login("inactive")
+		a.metrics.Lockout()
+		a.audit(ctx, failed(models.EventLogin, email, ip, "account_"+user.Status))
+		return "", 0, err
+	}
+	if reason := a.resetReason(user); reason != "" {
+		return "", 0, a.requireReset(ctx, user, ip, reason)
+	}
+	if err = a.db.UpdateLastLogin(ctx, user.ID, ip, time.Now()); err != nil {
 		log.Error("failed to update user", zap.Error(err))
+		a.metrics.Login("error")
+		a.audit(ctx, failed(models.EventLogin, email, ip, "internal_error"))
 		return "", 0, err
 	}
-	token, tokenTTL := a.createToken(email, rememberMe)
+	token, tokenTTL = a.createToken(ctx, email, ip, rememberMe)
 	if token == "" {
 		log.Error("failed to generate token", zap.Error(err))
+		a.metrics.Login("error")
+		a.audit(ctx, failed(models.EventLogin, email, ip, "internal_error"))
 		return "", 0, fmt.Errorf("failed to generate token")
 	}
 
+	a.metrics.Login("success")
+	a.audit(ctx, succeeded(models.EventLogin, email, ip))
+	a.checkDevice(ctx, user, ip, user.LastLoginDate)
 	log.Info("user logged in successfully")
 	return token, tokenTTL, nil
 }
 
-func (a *Auth) Register(email, pass, ip, rememberMe string) (string, time.Duration, error) {
-	log := a.log.With(
+func (a *Auth) Register(ctx context.Context, email, pass, ip, rememberMe string) (token string, tokenTTL time.Duration, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.Register")
+	defer func() { endSpan(span, err) }()
+	normalized, err := a.validateEmail(ctx, email)
+	if err != nil {
+		a.metrics.Registration("invalid_email")
+		a.audit(ctx, failed(models.EventRegister, email, ip, "invalid_email"))
+		return "", 0, err
+	}
+	email = normalized
+	log := a.logger(ctx).With(
 		zap.String("email", email),
 		zap.String("ip", ip),
 	)
 	log.Info("registering user")
+	if client := a.client(ip); a.countryBlocked(client, true) {
+		log.Warn("registration from blocked country", zap.String("country", client.Country))
+		a.metrics.Registration("country_blocked")
+		a.audit(ctx, failed(models.EventRegister, email, ip, "country_blocked"))
+		return "", 0, ErrCountryBlocked
+	}
+	if err := a.checkPassword(ctx, email, pass); err != nil {
+		reason := passwordFailure(err)
+		if reason == "password_policy" {
+			a.metrics.Registration("weak_password")
+		} else {
+			a.metrics.Registration("error")
+		}
+		a.audit(ctx, failed(models.EventRegister, email, ip, reason))
+		return "", 0, err
+	}
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	passHash, err := a.hashPassword(ctx, pass)
 	if err != nil {
 		log.Error("failed to generate password hash", zap.Error(err))
+		a.metrics.Registration("error")
+		a.audit(ctx, failed(models.EventRegister, email, ip, "internal_error"))
 		return "", 0, err
 	}
-	err = a.db.CreateUser(email, ip, passHash, false)
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		if err := tx.CreateUser(ctx, email, ip, passHash, false); err != nil {
+			return err
+		}
+		return emit(ctx, tx, events.TypeUserRegistered, events.UserRegistered{Email: email})
+	})
 	if err != nil {
 		log.Error("failed to create user", zap.Error(err))
+		if errors.Is(err, storage.ErrUserExists) {
+			a.metrics.Registration("exists")
+			a.audit(ctx, failed(models.EventRegister, email, ip, "user_exists"))
+		} else {
+			a.metrics.Registration("error")
+			a.audit(ctx, failed(models.EventRegister, email, ip, "internal_error"))
+		}
 		return "", 0, err
 	}
-	token, tokenTTL := a.createToken(email, rememberMe)
+	token, tokenTTL = a.createToken(ctx, email, ip, rememberMe)
 	if token == "" {
 		log.Error("failed to generate token", zap.Error(err))
+		a.metrics.Registration("error")
+		a.audit(ctx, failed(models.EventRegister, email, ip, "internal_error"))
 		return "", 0, fmt.Errorf("failed to generate token")
 	}
 
+	a.metrics.Registration("success")
+	a.audit(ctx, succeeded(models.EventRegister, email, ip))
+	a.rememberDevice(ctx, email, ip)
 	log.Info("user register successfully")
 	return token, tokenTTL, nil
 }
 
-func (a *Auth) Logout(token string) error {
-	err := a.redis.DeleteToken(token)
+func (a *Auth) Logout(ctx context.Context, token string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.Logout")
+	defer func() { endSpan(span, err) }()
+	// The session is only read to know whose it was for the audit log.
+	session, _ := a.sessions.Get(ctx, token)
+	err = a.sessions.Delete(ctx, token)
 	if err != nil {
-		a.log.Error("error delete token", zap.String("token", token))
+		a.logger(ctx).Error("error delete token", zap.Error(err), zap.String("session", storage.SessionID(token)))
+		return err
 	}
-	return err
+	a.metrics.Logout()
+	if session.Email != "" {
+		a.audit(ctx, succeeded(models.EventLogout, session.Email, ""))
+	}
+	return nil
 }
 
-func (a *Auth) updateUser(user models.User, ip string) error {
-	user.LastLoginDate = time.Now()
-	if ip != "" {
-		user.LastLoginIp = ip
+// modifyUser reads the user, applies fn and writes back the given columns,
+// starting over when another request changed the user in between.
+func (a *Auth) modifyUser(ctx context.Context, db database.Database, email string, fn func(user *models.User), columns ...string) (models.User, error) {
+	for attempt := 1; ; attempt++ {
+		user, err := db.User(ctx, email)
+		if err != nil {
+			return models.User{}, err
+		}
+		fn(&user)
+		err = db.UpdateUser(ctx, user, columns...)
+		if errors.Is(err, storage.ErrVersionConflict) && attempt < maxUpdateAttempts {
+			a.logger(ctx).Warn("user update conflict, retrying", zap.Int("attempt", attempt))
+			continue
+		}
+		return user, err
 	}
-	return a.db.UpdateUser(user)
 }
 
-func (a *Auth) createToken(email, rememberMe string) (string, time.Duration) {
+func (a *Auth) createToken(ctx context.Context, email, ip, rememberMe string) (string, time.Duration) {
 	tokenTTL := a.tokenTTL
 	if rememberMe == "on" {
 		tokenTTL = a.rememberMeTokenTTL
 	}
-	return a.redis.CreateToken(email, tokenTTL), tokenTTL
+	access, err := a.access(ctx, email)
+	switch {
+	case errors.Is(err, ErrAccountInactive), errors.Is(err, storage.ErrUserNotFound):
+		a.logger(ctx).Info("no session for inactive user", zap.String("email", email), zap.Error(err))
+		return "", tokenTTL
+	case err != nil:
+		// a session without a check time gets its roles on first use
+		a.logger(ctx).Error("failed to get roles", zap.Error(err))
+		access = storage.Access{}
+	}
+	token, err := a.sessions.Create(ctx, email, tokenTTL, a.client(ip), access)
+	if err != nil {
+		a.logger(ctx).Error("failed to create session", zap.Error(err))
+		return "", tokenTTL
+	}
+	a.metrics.TokenCreated()
+	return token, tokenTTL
+}
+
+// logger returns the service logger annotated with the trace of ctx.
+func (a *Auth) logger(ctx context.Context) *zap.Logger {
+	return logger.FromContext(ctx, a.log).With(tracing.LogFields(ctx)...)
+}
+
+func (a *Auth) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return a.tracer.Start(ctx, name)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+func TestLogin(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	register(t, a, "ann@example.com")
+	if err := a.DisableUser(ctx, "ann@example.com", "abuse", maxTime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		remember string
+		wantErr  error
+		wantTTL  time.Duration
+	}{
+		{"signed in", "jane@example.com", testPassword, "", nil, time.Hour},
+		{"remember me", "jane@example.com", testPassword, "on", nil, 24 * time.Hour},
+		{"wrong password", "jane@example.com", "not the password", "", ErrInvalidCredentials, 0},
+		{"unknown user", "bob@example.com", testPassword, "", ErrInvalidCredentials, 0},
+		{"disabled user", "ann@example.com", testPassword, "", ErrAccountInactive, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, ttl, err := a.Login(ctx, tt.email, tt.password, "192.0.2.1", tt.remember)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Login = %v, want %v", err, tt.wantErr)
+			}
+			if ttl != tt.wantTTL {
+				t.Errorf("ttl = %v, want %v", ttl, tt.wantTTL)
+			}
+			if got := a.GetUserEmail(ctx, token); tt.wantErr == nil && got != tt.email {
+				t.Errorf("session of %q, want %q", got, tt.email)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		email       string
+		wantErr     error
+		wantInvalid bool
+		wantEmail   string
+	}{
+		{"new user", "jane@example.com", nil, false, "jane@example.com"},
+		{"normalized", "  Ann@Example.COM ", nil, false, "ann@example.com"},
+		{"taken", "jane@example.com", storage.ErrUserExists, false, ""},
+		{"taken after normalizing", "JANE@example.com", storage.ErrUserExists, false, ""},
+		{"not an email", "jane", nil, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, _, err := a.Register(ctx, tt.email, testPassword, "192.0.2.1", "")
+			var invalid *mailaddr.Error
+			if gotInvalid := errors.As(err, &invalid); gotInvalid != tt.wantInvalid {
+				t.Fatalf("Register = %v, want an invalid email error %v", err, tt.wantInvalid)
+			}
+			if !tt.wantInvalid && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register = %v, want %v", err, tt.wantErr)
+			}
+			if got := a.GetUserEmail(ctx, token); got != tt.wantEmail {
+				t.Errorf("session of %q, want %q", got, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	a, db := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	register(t, a, "ann@example.com")
+	if err := a.BanUser(ctx, "ann@example.com", "abuse", maxTime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		email       string
+		wantErr     error
+		wantInvalid bool
+	}{
+		{"existing user", "jane@example.com", nil, false},
+		{"new user", "bob@example.com", nil, false},
+		{"new user again", "bob@example.com", nil, false},
+		{"banned user", "ann@example.com", ErrAccountInactive, false},
+		{"not an email", "bob", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, ttl, err := a.OAuth(ctx, tt.email, "192.0.2.1", "google")
+			var invalid *mailaddr.Error
+			if gotInvalid := errors.As(err, &invalid); gotInvalid != tt.wantInvalid {
+				t.Fatalf("OAuth = %v, want an invalid email error %v", err, tt.wantInvalid)
+			}
+			if !tt.wantInvalid && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OAuth = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if ttl != 24*time.Hour {
+				t.Errorf("ttl = %v, want the remember me ttl", ttl)
+			}
+			if got := a.GetUserEmail(ctx, token); got != tt.email {
+				t.Errorf("session of %q, want %q", got, tt.email)
+			}
+			// the provider checked the address
+			if verified, err := db.EmailVerified(ctx, tt.email); err != nil || !verified {
+				t.Errorf("EmailVerified = %v, %v, want true", verified, err)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	token := register(t, a, "jane@example.com")
+	other := login(t, a, "jane@example.com", testPassword, "192.0.2.1")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"signed in", token},
+		{"again", token},
+		{"unknown session", "not-a-session"},
+		{"no session", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.Logout(ctx, tt.token); err != nil {
+				t.Fatalf("Logout: %v", err)
+			}
+			if got := a.GetUserEmail(ctx, tt.token); got != "" {
+				t.Errorf("session of %q after Logout", got)
+			}
+		})
+	}
+	if got := a.GetUserEmail(ctx, other); got != "jane@example.com" {
+		t.Errorf("other session of %q, want it kept", got)
+	}
+}
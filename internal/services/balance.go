@@ -0,0 +1,375 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidAmount         = errors.New("amount must be positive")
+	ErrInvalidCurrency       = errors.New("currency must be a three letter ISO 4217 code")
+	ErrInvalidIdempotencyKey = errors.New("idempotency key must be 1 to 128 bytes")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+	// ErrIdempotencyConflict is returned when a key is used again for an
+	// operation other than the one it was first used for.
+	ErrIdempotencyConflict = errors.New("idempotency key was used for a different operation")
+	ErrSelfTransfer        = errors.New("cannot transfer to the same account")
+)
+
+// legacyBatchSize is how many users MigrateLegacyBalances reads at a time.
+const legacyBatchSize = 100
+
+// migrationCaller is the caller of the opening entries, so their keys can't
+// collide with those of the services, whose callers are "service:<name>".
+const migrationCaller = "migrate-balances"
+
+// maxLedgerAttempts bounds the retries of a ledger transaction that lost a
+// serialization conflict.
+const maxLedgerAttempts = 5
+
+// ledgerEntry is one side of a ledger operation, amount is negative for
+// money going out.
+type ledgerEntry struct {
+	email  string
+	amount int64
+	kind   string
+}
+
+// Credit adds amount minor units of currency to the user's balance. Called
+// again with the same idempotency key it returns the first transaction
+// without applying it twice.
+func (a *Auth) Credit(ctx context.Context, email string, amount int64, currency, idempotencyKey, description string) (tx models.BalanceTransaction, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.Credit")
+	defer func() { endSpan(span, err) }()
+	if amount <= 0 {
+		return models.BalanceTransaction{}, ErrInvalidAmount
+	}
+	txs, err := a.applyLedger(ctx, currency, idempotencyKey, description,
+		ledgerEntry{email: email, amount: amount, kind: models.TxCredit})
+	if err != nil {
+		return models.BalanceTransaction{}, err
+	}
+	return txs[0], nil
+}
+
+// Debit takes amount minor units of currency from the user's balance, or
+// fails with ErrInsufficientFunds when the balance is lower. It is
+// idempotent like Credit.
+func (a *Auth) Debit(ctx context.Context, email string, amount int64, currency, idempotencyKey, description string) (tx models.BalanceTransaction, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.Debit")
+	defer func() { endSpan(span, err) }()
+	if amount <= 0 {
+		return models.BalanceTransaction{}, ErrInvalidAmount
+	}
+	txs, err := a.applyLedger(ctx, currency, idempotencyKey, description,
+		ledgerEntry{email: email, amount: -amount, kind: models.TxDebit})
+	if err != nil {
+		return models.BalanceTransaction{}, err
+	}
+	return txs[0], nil
+}
+
+// Transfer moves amount minor units of currency from one user to another
+// in a single transaction. It fails like Debit and is idempotent like
+// Credit.
+func (a *Auth) Transfer(ctx context.Context, from, to string, amount int64, currency, idempotencyKey, description string) (debit, credit models.BalanceTransaction, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.Transfer")
+	defer func() { endSpan(span, err) }()
+	if amount <= 0 {
+		return models.BalanceTransaction{}, models.BalanceTransaction{}, ErrInvalidAmount
+	}
+	if from == to {
+		return models.BalanceTransaction{}, models.BalanceTransaction{}, ErrSelfTransfer
+	}
+	txs, err := a.applyLedger(ctx, currency, idempotencyKey, description,
+		ledgerEntry{email: from, amount: -amount, kind: models.TxTransferOut},
+		ledgerEntry{email: to, amount: amount, kind: models.TxTransferIn})
+	if err != nil {
+		return models.BalanceTransaction{}, models.BalanceTransaction{}, err
+	}
+	return txs[0], txs[1], nil
+}
+
+// GetBalance returns the user's balance in currency, zero when they never
+// had one.
+func (a *Auth) GetBalance(ctx context.Context, email, currency string) (balance models.Balance, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.GetBalance")
+	defer func() { endSpan(span, err) }()
+	currency, err = normalizeCurrency(currency)
+	if err != nil {
+		return models.Balance{}, err
+	}
+	user, err := a.db.User(ctx, email)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			a.logger(ctx).Error("failed to get user", zap.String("email", email), zap.Error(err))
+		}
+		return models.Balance{}, err
+	}
+	balance, err = a.db.Balance(ctx, user.ID, currency)
+	if err != nil {
+		a.logger(ctx).Error("failed to get balance", zap.String("email", email), zap.Error(err))
+		return models.Balance{}, err
+	}
+	return balance, nil
+}
+
+// ReconcileBalances returns every cached balance that differs from the sum
+// of its ledger. There should be none, a mismatch means the balances table
+// was written around the ledger.
+func (a *Auth) ReconcileBalances(ctx context.Context) (mismatches []database.BalanceMismatch, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ReconcileBalances")
+	defer func() { endSpan(span, err) }()
+	mismatches, err = a.db.BalanceMismatches(ctx)
+	if err != nil {
+		a.logger(ctx).Error("failed to reconcile balances", zap.Error(err))
+		return nil, err
+	}
+	for _, m := range mismatches {
+		a.logger(ctx).Error("balance does not match ledger",
+			zap.Int("user_id", m.UserID),
+			zap.String("currency", m.Currency),
+			zap.Int64("cached", m.Cached),
+			zap.Int64("ledger", m.Ledger),
+		)
+	}
+	return mismatches, nil
+}
+
+// MigrateLegacyBalances gives every user with a float users.balance an
+// opening ledger entry of that balance, in currency with scale decimal
+// places, e.g. 2 for cents. Users who already have their opening entry are
+// skipped, so it can run again after a failure. The old column is left as
+// it is. It returns how many entries it made.
+func (a *Auth) MigrateLegacyBalances(ctx context.Context, currency string, scale int) (migrated int, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.MigrateLegacyBalances")
+	defer func() { endSpan(span, err) }()
+	currency, err = normalizeCurrency(currency)
+	if err != nil {
+		return 0, err
+	}
+	if scale < 0 || scale > 8 {
+		return 0, errors.New("scale must be between 0 and 8")
+	}
+	log := a.logger(ctx).With(zap.String("currency", currency))
+	afterID := 0
+	for {
+		users, err := a.db.LegacyBalances(ctx, afterID, legacyBatchSize)
+		if err != nil {
+			log.Error("failed to get legacy balances", zap.Error(err))
+			return migrated, err
+		}
+		for _, user := range users {
+			afterID = user.ID
+			ok, err := a.openBalance(ctx, user, currency, scale)
+			if err != nil {
+				log.Error("failed to migrate legacy balance", zap.Int("user_id", user.ID), zap.Error(err))
+				return migrated, err
+			}
+			if ok {
+				migrated++
+			}
+		}
+		if len(users) < legacyBatchSize {
+			log.Info("legacy balances migrated", zap.Int("migrated", migrated))
+			return migrated, nil
+		}
+	}
+}
+
+// openBalance records the opening entry of user, false if they have one.
+func (a *Auth) openBalance(ctx context.Context, user models.User, currency string, scale int) (bool, error) {
+	minor := math.Round(user.Balance * math.Pow10(scale))
+	if math.IsNaN(minor) || minor >= math.MaxInt64 || minor <= math.MinInt64 {
+		return false, fmt.Errorf("balance %v does not fit the ledger", user.Balance)
+	}
+	amount := int64(minor)
+	key := "opening-" + strconv.Itoa(user.ID)
+	var opened bool
+	err := a.db.WithSerializableTx(ctx, func(db database.Database) error {
+		existing, err := db.BalanceTransactions(ctx, migrationCaller, key)
+		if err != nil || len(existing) > 0 {
+			return err
+		}
+		balance, err := db.Balance(ctx, user.ID, currency)
+		if err != nil {
+			return err
+		}
+		if (amount > 0 && balance.Amount > math.MaxInt64-amount) ||
+			(amount < 0 && balance.Amount < math.MinInt64-amount) {
+			return ErrInvalidAmount
+		}
+		balance.Amount += amount
+		err = db.AddBalanceTransaction(ctx, &models.BalanceTransaction{
+			UserID:         user.ID,
+			Currency:       currency,
+			Amount:         amount,
+			Kind:           models.TxOpening,
+			Caller:         migrationCaller,
+			IdempotencyKey: key,
+			Description:    "opening balance from users.balance",
+			BalanceAfter:   balance.Amount,
+		})
+		if err != nil {
+			return err
+		}
+		opened = true
+		return db.SaveBalance(ctx, balance)
+	})
+	return opened, err
+}
+
+// applyLedger records entries and updates the balances they touch in one
+// serializable transaction, retried when it conflicts with another. Every
+// user must be active, an account that is disabled, banned or pending
+// deletion has its balance frozen. When
+// the caller, the actor of ctx, used the idempotency key before nothing is
+// applied and the transactions of the first call are returned instead.
+func (a *Auth) applyLedger(ctx context.Context, currency, idempotencyKey, description string, entries ...ledgerEntry) ([]models.BalanceTransaction, error) {
+	currency, err := normalizeCurrency(currency)
+	if err != nil {
+		return nil, err
+	}
+	if idempotencyKey == "" || len(idempotencyKey) > 128 {
+		return nil, ErrInvalidIdempotencyKey
+	}
+	caller := actorFrom(ctx)
+	log := a.logger(ctx).With(
+		zap.String("caller", caller),
+		zap.String("idempotency_key", idempotencyKey),
+		zap.String("currency", currency),
+	)
+	for attempt := 1; ; attempt++ {
+		var (
+			txs      []models.BalanceTransaction
+			replayed bool
+		)
+		err := a.db.WithSerializableTx(ctx, func(db database.Database) error {
+			users := make([]models.User, len(entries))
+			for i, entry := range entries {
+				user, err := db.User(ctx, entry.email)
+				if err != nil {
+					return err
+				}
+				if err := accountStatus(user); err != nil {
+					return err
+				}
+				users[i] = user
+			}
+			existing, err := db.BalanceTransactions(ctx, caller, idempotencyKey)
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				if !sameOperation(existing, users, entries, currency) {
+					return ErrIdempotencyConflict
+				}
+				txs, replayed = existing, true
+				return nil
+			}
+			for i, entry := range entries {
+				balance, err := db.Balance(ctx, users[i].ID, currency)
+				if err != nil {
+					return err
+				}
+				if entry.amount < 0 && balance.Amount < -entry.amount {
+					return ErrInsufficientFunds
+				}
+				if entry.amount > 0 && balance.Amount > math.MaxInt64-entry.amount {
+					return ErrInvalidAmount
+				}
+				balance.Amount += entry.amount
+				tx := models.BalanceTransaction{
+					UserID:         users[i].ID,
+					Currency:       currency,
+					Amount:         entry.amount,
+					Kind:           entry.kind,
+					Caller:         caller,
+					IdempotencyKey: idempotencyKey,
+					Description:    truncate(description, 255),
+					BalanceAfter:   balance.Amount,
+				}
+				if len(users) == 2 {
+					other := users[1-i].ID
+					tx.CounterpartyID = &other
+				}
+				if err := db.AddBalanceTransaction(ctx, &tx); err != nil {
+					return err
+				}
+				if err := db.SaveBalance(ctx, balance); err != nil {
+					return err
+				}
+				txs = append(txs, tx)
+			}
+			return nil
+		})
+		retry := errors.Is(err, storage.ErrSerializationFailure) || errors.Is(err, storage.ErrDuplicateTransaction)
+		if retry && attempt < maxLedgerAttempts {
+			log.Warn("ledger transaction conflict, retrying", zap.Int("attempt", attempt))
+			continue
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrInsufficientFunds), errors.Is(err, ErrInvalidAmount),
+			errors.Is(err, ErrIdempotencyConflict), errors.Is(err, storage.ErrUserNotFound),
+			errors.Is(err, ErrAccountInactive):
+			log.Info("ledger operation refused", zap.Error(err))
+			return nil, err
+		default:
+			log.Error("failed to apply ledger operation", zap.Error(err))
+			return nil, err
+		}
+		if replayed {
+			log.Info("ledger operation replayed")
+		} else {
+			for _, tx := range txs {
+				log.Info("balance changed",
+					zap.Int("user_id", tx.UserID),
+					zap.String("kind", tx.Kind),
+					zap.Int64("amount", tx.Amount),
+					zap.Int64("balance", tx.BalanceAfter),
+				)
+			}
+		}
+		return txs, nil
+	}
+}
+
+// sameOperation reports whether the transactions recorded for an
+// idempotency key are the ones entries would have made.
+func sameOperation(existing []models.BalanceTransaction, users []models.User, entries []ledgerEntry, currency string) bool {
+	if len(existing) != len(entries) {
+		return false
+	}
+	for i, tx := range existing {
+		if tx.UserID != users[i].ID || tx.Amount != entries[i].amount ||
+			tx.Kind != entries[i].kind || tx.Currency != currency {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeCurrency upper-cases an ISO 4217 code. Which codes exist is up
+// to the callers, the ledger keeps any three letters apart.
+func normalizeCurrency(currency string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if len(currency) != 3 {
+		return "", ErrInvalidCurrency
+	}
+	for _, c := range currency {
+		if c < 'A' || c > 'Z' {
+			return "", ErrInvalidCurrency
+		}
+	}
+	return currency, nil
+}
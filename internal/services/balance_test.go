@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+func TestLedgerRefusesInactiveAccounts(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	register(t, a, "bob@example.com")
+	if _, err := a.Credit(ctx, "jane@example.com", 500, "EUR", "seed", ""); err != nil {
+		t.Fatalf("Credit: %v", err)
+	}
+	if err := a.DisableUser(ctx, "bob@example.com", "chargeback", time.Time{}); err != nil {
+		t.Fatalf("DisableUser: %v", err)
+	}
+
+	if _, err := a.Credit(ctx, "bob@example.com", 100, "EUR", "credit-bob", ""); !errors.Is(err, ErrAccountInactive) {
+		t.Errorf("Credit to disabled account: err = %v, want ErrAccountInactive", err)
+	}
+	if _, err := a.Debit(ctx, "bob@example.com", 100, "EUR", "debit-bob", ""); !errors.Is(err, ErrAccountInactive) {
+		t.Errorf("Debit from disabled account: err = %v, want ErrAccountInactive", err)
+	}
+	if _, _, err := a.Transfer(ctx, "jane@example.com", "bob@example.com", 100, "EUR", "to-bob", ""); !errors.Is(err, ErrAccountInactive) {
+		t.Errorf("Transfer to disabled account: err = %v, want ErrAccountInactive", err)
+	}
+	balance, err := a.GetBalance(ctx, "jane@example.com", "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Amount != 500 {
+		t.Errorf("sender balance = %d, want 500 after the refused transfer", balance.Amount)
+	}
+}
+
+func TestMigrateLegacyBalances(t *testing.T) {
+	a, db := newTestAuth(t)
+	ctx := context.Background()
+	legacy := map[string]float64{"jane@example.com": 19.99, "bob@example.com": -5, "ann@example.com": 0}
+	for email, amount := range legacy {
+		register(t, a, email)
+		user, err := db.User(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Balance = amount
+		if err := db.UpdateUser(ctx, user, "balance"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for run := 1; run <= 2; run++ {
+		migrated, err := a.MigrateLegacyBalances(ctx, "eur", 2)
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		want := 2
+		if run == 2 {
+			want = 0
+		}
+		if migrated != want {
+			t.Errorf("run %d migrated %d balances, want %d", run, migrated, want)
+		}
+	}
+	for email, want := range map[string]int64{"jane@example.com": 1999, "bob@example.com": -500, "ann@example.com": 0} {
+		balance, err := a.GetBalance(ctx, email, "EUR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance.Amount != want {
+			t.Errorf("%s balance = %d, want %d", email, balance.Amount, want)
+		}
+	}
+	mismatches, err := a.ReconcileBalances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("balances differ from the ledger: %+v", mismatches)
+	}
+}
+
+func TestIdempotencyKeysAreScopedPerCaller(t *testing.T) {
+	a, db := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	user, err := db.User(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Balance = 1
+	if err := db.UpdateUser(ctx, user, "balance"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.MigrateLegacyBalances(ctx, "EUR", 2); err != nil {
+		t.Fatal(err)
+	}
+	openingKey := "opening-" + strconv.Itoa(user.ID)
+
+	billing := WithActor(ctx, "service:billing")
+	shop := WithActor(ctx, "service:shop")
+	if _, err := a.Credit(billing, "jane@example.com", 100, "EUR", openingKey, ""); err != nil {
+		t.Fatalf("Credit with the key of the opening entry: %v", err)
+	}
+	if _, err := a.Credit(shop, "jane@example.com", 200, "EUR", openingKey, ""); err != nil {
+		t.Fatalf("Credit with the key another service used: %v", err)
+	}
+	if _, err := a.Credit(shop, "jane@example.com", 300, "EUR", openingKey, ""); !errors.Is(err, ErrIdempotencyConflict) {
+		t.Errorf("Credit reusing the key for another amount = %v, want ErrIdempotencyConflict", err)
+	}
+	balance, err := a.GetBalance(ctx, "jane@example.com", "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Amount != 400 {
+		t.Errorf("balance = %d, want 400", balance.Amount)
+	}
+}
+
+func TestLedgerOperations(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	register(t, a, "bob@example.com")
+
+	credit := func(email string, amount int64, currency, key string) func() error {
+		return func() error {
+			_, err := a.Credit(ctx, email, amount, currency, key, "")
+			return err
+		}
+	}
+	debit := func(email string, amount int64, currency, key string) func() error {
+		return func() error {
+			_, err := a.Debit(ctx, email, amount, currency, key, "")
+			return err
+		}
+	}
+	transfer := func(from, to string, amount int64, key string) func() error {
+		return func() error {
+			_, _, err := a.Transfer(ctx, from, to, amount, "EUR", key, "")
+			return err
+		}
+	}
+	// the steps run in order, each one sees the balances the ones before
+	// left behind
+	steps := []struct {
+		name     string
+		op       func() error
+		wantErr  error
+		wantJane int64
+		wantBob  int64
+	}{
+		{"credit", credit("jane@example.com", 500, "eur", "c1"), nil, 500, 0},
+		{"credit replayed", credit("jane@example.com", 500, "EUR", "c1"), nil, 500, 0},
+		{"key reused for another amount", credit("jane@example.com", 600, "EUR", "c1"), ErrIdempotencyConflict, 500, 0},
+		{"key reused for another user", credit("bob@example.com", 500, "EUR", "c1"), ErrIdempotencyConflict, 500, 0},
+		{"key reused for another currency", credit("jane@example.com", 500, "USD", "c1"), ErrIdempotencyConflict, 500, 0},
+		{"key reused for a debit", debit("jane@example.com", 500, "EUR", "c1"), ErrIdempotencyConflict, 500, 0},
+		{"zero amount", credit("jane@example.com", 0, "EUR", "c2"), ErrInvalidAmount, 500, 0},
+		{"bad currency", credit("jane@example.com", 1, "euro", "c3"), ErrInvalidCurrency, 500, 0},
+		{"no key", credit("jane@example.com", 1, "EUR", ""), ErrInvalidIdempotencyKey, 500, 0},
+		{"unknown user", credit("ann@example.com", 1, "EUR", "c4"), storage.ErrUserNotFound, 500, 0},
+		{"debit", debit("jane@example.com", 200, "EUR", "d1"), nil, 300, 0},
+		{"debit replayed", debit("jane@example.com", 200, "EUR", "d1"), nil, 300, 0},
+		{"insufficient funds", debit("jane@example.com", 301, "EUR", "d2"), ErrInsufficientFunds, 300, 0},
+		{"nothing in the currency", debit("jane@example.com", 1, "USD", "d3"), ErrInsufficientFunds, 300, 0},
+		{"transfer", transfer("jane@example.com", "bob@example.com", 100, "t1"), nil, 200, 100},
+		{"transfer replayed", transfer("jane@example.com", "bob@example.com", 100, "t1"), nil, 200, 100},
+		{"transfer key reversed", transfer("bob@example.com", "jane@example.com", 100, "t1"), ErrIdempotencyConflict, 200, 100},
+		{"transfer to self", transfer("jane@example.com", "jane@example.com", 1, "t2"), ErrSelfTransfer, 200, 100},
+		{"transfer beyond the balance", transfer("bob@example.com", "jane@example.com", 101, "t3"), ErrInsufficientFunds, 200, 100},
+		{"transfer to unknown user", transfer("jane@example.com", "ann@example.com", 1, "t4"), storage.ErrUserNotFound, 200, 100},
+	}
+	for _, step := range steps {
+		if err := step.op(); !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: err = %v, want %v", step.name, err, step.wantErr)
+		}
+		for email, want := range map[string]int64{"jane@example.com": step.wantJane, "bob@example.com": step.wantBob} {
+			balance, err := a.GetBalance(ctx, email, "EUR")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Amount != want {
+				t.Errorf("after %s: %s has %d, want %d", step.name, email, balance.Amount, want)
+			}
+		}
+	}
+
+	mismatches, err := a.ReconcileBalances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("balances differ from the ledger: %+v", mismatches)
+	}
+}
+
+func TestLedgerReplayReturnsTheFirstTransactions(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	register(t, a, "bob@example.com")
+	if _, err := a.Credit(ctx, "jane@example.com", 500, "EUR", "seed", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	debit, credit, err := a.Transfer(ctx, "jane@example.com", "bob@example.com", 100, "EUR", "t1", "rent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if debit.Amount != -100 || debit.BalanceAfter != 400 || credit.Amount != 100 || credit.BalanceAfter != 100 {
+		t.Fatalf("transfer made %+v and %+v", debit, credit)
+	}
+	replayedDebit, replayedCredit, err := a.Transfer(ctx, "jane@example.com", "bob@example.com", 100, "EUR", "t1", "rent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayedDebit.ID != debit.ID || replayedCredit.ID != credit.ID {
+		t.Errorf("replay returned transactions %d and %d, want %d and %d", replayedDebit.ID, replayedCredit.ID, debit.ID, credit.ID)
+	}
+}
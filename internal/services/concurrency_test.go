@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+// TestModifyUserNoLostUpdates races read-modify-write cycles on the same
+// user. Every update that reports success has to be in the final row, the
+// others have to fail with a version conflict rather than be dropped.
+func TestModifyUserNoLostUpdates(t *testing.T) {
+	a, db := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "race@example.com")
+
+	const workers = 50
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.modifyUser(ctx, db, "race@example.com", func(user *models.User) {
+				user.Balance++
+			}, "balance")
+			if err != nil && !errors.Is(err, storage.ErrVersionConflict) {
+				t.Errorf("modifyUser: %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	user, err := db.User(ctx, "race@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if succeeded == 0 {
+		t.Fatal("no update succeeded")
+	}
+	if int(user.Balance) != succeeded {
+		t.Errorf("balance = %v after %d successful updates, updates were lost", user.Balance, succeeded)
+	}
+}
+
+// TestUpdateUserStaleVersion checks that a write based on an old read is
+// refused instead of overwriting the newer row.
+func TestUpdateUserStaleVersion(t *testing.T) {
+	a, db := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "stale@example.com")
+
+	stale, err := db.User(ctx, "stale@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := stale
+	fresh.PassHash = []byte("fresh")
+	if err := db.UpdateUser(ctx, fresh, "pass_hash"); err != nil {
+		t.Fatal(err)
+	}
+	stale.Balance = 10
+	if err := db.UpdateUser(ctx, stale, "balance"); !errors.Is(err, storage.ErrVersionConflict) {
+		t.Fatalf("stale update: got %v, want ErrVersionConflict", err)
+	}
+	user, _ := db.User(ctx, "stale@example.com")
+	if string(user.PassHash) != "fresh" || user.Balance != 0 {
+		t.Errorf("row = %q/%v, want the fresh write only", user.PassHash, user.Balance)
+	}
+}
+
+// TestLoginDuringPasswordChange runs logins, which touch the last login
+// columns, alongside a password change. The new password must survive.
+func TestLoginDuringPasswordChange(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	token := register(t, a, "login@example.com")
+	const newPassword = "another long passphrase 42"
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the old password stops working halfway, either outcome is fine
+			a.Login(ctx, "login@example.com", testPassword, "192.0.2.2", "")
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, _, err := a.ChangePass(ctx, "login@example.com", newPassword, "192.0.2.3", token); err != nil {
+			t.Errorf("ChangePass: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	if _, _, err := a.Login(ctx, "login@example.com", newPassword, "192.0.2.2", ""); err != nil {
+		t.Errorf("login with the new password: %v", err)
+	}
+	if _, _, err := a.Login(ctx, "login@example.com", testPassword, "192.0.2.2", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("login with the old password: got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+// TestWithTxRollsBack checks that a failing step undoes the earlier ones,
+// the user change and the outbox event alike.
+func TestWithTxRollsBack(t *testing.T) {
+	a, db := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "tx@example.com")
+	before, err := db.DueOutboxEvents(ctx, maxTime, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boom := errors.New("boom")
+	err = db.WithTx(ctx, func(tx database.Database) error {
+		if _, err := a.modifyUser(ctx, tx, "tx@example.com", func(user *models.User) {
+			user.Email = "moved@example.com"
+		}, "email"); err != nil {
+			return err
+		}
+		if err := emit(ctx, tx, events.TypeEmailChanged, events.EmailChanged{OldEmail: "tx@example.com", NewEmail: "moved@example.com"}); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("WithTx: got %v, want %v", err, boom)
+	}
+	if _, err := db.User(ctx, "tx@example.com"); err != nil {
+		t.Errorf("user lost its email after rollback: %v", err)
+	}
+	if _, err := db.User(ctx, "moved@example.com"); !errors.Is(err, storage.ErrUserNotFound) {
+		t.Errorf("rolled back email is taken: %v", err)
+	}
+	after, err := db.DueOutboxEvents(ctx, maxTime, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Errorf("outbox has %d events after rollback, want %d", len(after), len(before))
+	}
+}
+
+// TestChangeEmailToTakenAddress checks the service side of the same: the
+// email change and its event are both dropped when the address is taken.
+func TestChangeEmailToTakenAddress(t *testing.T) {
+	a, db := newTestAuth(t)
+	ctx := context.Background()
+	token := register(t, a, "first@example.com")
+	register(t, a, "second@example.com")
+	before, _ := db.DueOutboxEvents(ctx, maxTime, 100)
+
+	if _, _, err := a.ChangeEmail(ctx, "first@example.com", "second@example.com", token); !errors.Is(err, storage.ErrUserExists) {
+		t.Fatalf("ChangeEmail: got %v, want ErrUserExists", err)
+	}
+	if _, err := db.User(ctx, "first@example.com"); err != nil {
+		t.Errorf("first user lost their email: %v", err)
+	}
+	after, _ := db.DueOutboxEvents(ctx, maxTime, 100)
+	if len(after) != len(before) {
+		t.Errorf("outbox has %d events, want %d", len(after), len(before))
+	}
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/notifier"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrReauthRequired is returned to users without a password whose
+	// session is too old to confirm a deletion, they have to sign in again.
+	ErrReauthRequired       = errors.New("recent sign-in required")
+	ErrInvalidDeletionToken = errors.New("invalid or expired deletion token")
+)
+
+const (
+	defaultReauthWindow = 5 * time.Minute
+	purgeBatchSize      = 100
+)
+
+// WithAccountDeletion sets the grace period, purge mode and cancel link of
+// deletions users ask for.
+func WithAccountDeletion(cfg config.DeletionConfig) Option {
+	return func(a *Auth) {
+		a.deletion = cfg
+	}
+}
+
+// DeleteAccount closes the account signed in with token once the user
+// confirmed it with their password. Every session ends and the user is
+// emailed a link to CancelAccountDeletion, which works until the returned
+// time, when PurgeDeletedAccounts removes the account.
+func (a *Auth) DeleteAccount(ctx context.Context, token, password, ip string) (purgeAt time.Time, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.DeleteAccount")
+	defer func() { endSpan(span, err) }()
+	session, err := a.session(ctx, token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	email := session.Email
+	log := a.logger(ctx).With(
+		zap.String("email", email),
+		zap.String("ip", ip),
+	)
+	log.Info("deleting account")
+	user, err := a.db.User(ctx, email)
+	if err != nil {
+		log.Error("failed to get user", zap.Error(err))
+		return time.Time{}, err
+	}
+	if err := a.reauthenticate(ctx, user, session, password); err != nil {
+		log.Info("deletion not confirmed", zap.Error(err))
+		reason := "invalid_password"
+		if errors.Is(err, ErrReauthRequired) {
+			reason = "reauth_required"
+		}
+		a.audit(ctx, failed(models.EventAccountDelete, email, ip, reason))
+		return time.Time{}, err
+	}
+	cancelToken, err := newToken()
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	purgeAt = now.Add(a.deletion.GracePeriod)
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		_, err := a.modifyUser(ctx, tx, email, func(user *models.User) {
+			user.Status = models.UserPendingDeletion
+			user.StatusReason = "deletion requested"
+			user.StatusUntil = nil
+		}, "status", "status_reason", "status_until")
+		if err != nil {
+			return err
+		}
+		err = tx.AddAccountDeletion(ctx, &models.AccountDeletion{
+			UserID:    user.ID,
+			TokenHash: hashToken(cancelToken),
+			CreatedAt: now,
+			PurgeAt:   purgeAt,
+		})
+		if err != nil {
+			return err
+		}
+		link, err := tokenLink(a.deletion.CancelURL, cancelToken)
+		if err != nil {
+			return err
+		}
+		return a.sendNotification(ctx, tx, email, notifier.TemplateAccountDeletion, map[string]string{
+			"Date": purgeAt.UTC().Format("2 January 2006 15:04 MST"),
+			"Link": link,
+		})
+	})
+	if err != nil {
+		log.Error("failed to schedule deletion", zap.Error(err))
+		a.audit(ctx, failed(models.EventAccountDelete, email, ip, "internal_error"))
+		return time.Time{}, err
+	}
+	if err := a.sessions.DeleteAll(ctx, email); err != nil {
+		// the sessions are refused once their roles are read again
+		log.Error("failed to revoke sessions", zap.Error(err))
+	}
+	event := succeeded(models.EventAccountDelete, email, ip)
+	event.Reason = "scheduled"
+	a.audit(ctx, event)
+	return purgeAt, nil
+}
+
+// reauthenticate checks that the user behind session just proved who they
+// are: with their password, or for accounts without one, such as those
+// created through OAuth, with a session no older than the reauth window.
+func (a *Auth) reauthenticate(ctx context.Context, user models.User, session storage.Session, password string) error {
+	if len(user.PassHash) > 0 {
+		if password == "" || a.comparePassword(ctx, user.PassHash, password) != nil {
+			return ErrInvalidCredentials
+		}
+		return nil
+	}
+	window := a.deletion.ReauthWindow
+	if window <= 0 {
+		window = defaultReauthWindow
+	}
+	if time.Since(session.CreatedAt) > window {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+// CancelAccountDeletion restores an account with the token of the link
+// DeleteAccount sent. The user signs in again afterwards.
+func (a *Auth) CancelAccountDeletion(ctx context.Context, cancelToken string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.CancelAccountDeletion")
+	defer func() { endSpan(span, err) }()
+	var email string
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		deletion, err := tx.AccountDeletion(ctx, hashToken(cancelToken))
+		if errors.Is(err, storage.ErrDeletionNotFound) {
+			return ErrInvalidDeletionToken
+		}
+		if err != nil {
+			return err
+		}
+		if !time.Now().Before(deletion.PurgeAt) {
+			return ErrInvalidDeletionToken
+		}
+		user, err := tx.UserByID(ctx, deletion.UserID)
+		if err != nil {
+			return err
+		}
+		email = user.Email
+		// an account suspended since stays suspended
+		if user.Status == models.UserPendingDeletion {
+			_, err := a.modifyUser(ctx, tx, email, func(user *models.User) {
+				user.Status = models.UserActive
+				user.StatusReason = ""
+			}, "status", "status_reason")
+			if err != nil {
+				return err
+			}
+		}
+		return tx.DeleteAccountDeletion(ctx, deletion.ID)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidDeletionToken) {
+			a.logger(ctx).Info("invalid deletion token")
+			return err
+		}
+		a.logger(ctx).Error("failed to cancel deletion", zap.Error(err))
+		return err
+	}
+	event := succeeded(models.EventAccountDelete, email, "")
+	event.Reason = "cancelled"
+	a.audit(ctx, event)
+	return nil
+}
+
+// PurgeDeletedAccounts purges the accounts whose grace period ended every
+// interval until ctx is done.
+func (a *Auth) PurgeDeletedAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.purgeDue(ctx)
+		}
+	}
+}
+
+func (a *Auth) purgeDue(ctx context.Context) {
+	for {
+		due, err := a.db.DueAccountDeletions(ctx, time.Now(), purgeBatchSize)
+		if err != nil {
+			a.log.Error("failed to get due account deletions", zap.Error(err))
+			return
+		}
+		for _, deletion := range due {
+			if err := a.purgeDeletion(ctx, deletion); err != nil {
+				// a failed purge is retried on the next run
+				a.log.Error("failed to purge account", zap.Int("user_id", deletion.UserID), zap.Error(err))
+				return
+			}
+		}
+		if len(due) < purgeBatchSize {
+			return
+		}
+	}
+}
+
+func (a *Auth) purgeDeletion(ctx context.Context, deletion models.AccountDeletion) error {
+	var (
+		email  string
+		userID int
+	)
+	err := a.db.WithTx(ctx, func(tx database.Database) error {
+		deletion, err := tx.AccountDeletion(ctx, deletion.TokenHash)
+		if errors.Is(err, storage.ErrDeletionNotFound) {
+			// cancelled in the meantime
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		user, err := tx.UserByID(ctx, deletion.UserID)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return tx.DeleteAccountDeletion(ctx, deletion.ID)
+		}
+		if err != nil {
+			return err
+		}
+		email, userID = user.Email, user.ID
+		return a.purgeUser(ctx, tx, user)
+	})
+	if err != nil || email == "" {
+		return err
+	}
+	a.afterPurge(ctx, email)
+	event := succeeded(models.EventAccountDelete, anonymizedEmail(userID), "")
+	event.Reason = "purged"
+	a.audit(ctx, event)
+	return nil
+}
+
+// purgeUser removes the user and everything kept about them, or with mode
+// "anonymize" keeps the row with the personal data wiped. Either way the
+// email is replaced with one that can't be traced back to them in the audit
+// log and in events, and can be used again.
+func (a *Auth) purgeUser(ctx context.Context, tx database.Database, user models.User) error {
+	if err := tx.DeleteUserData(ctx, user.ID); err != nil {
+		return err
+	}
+	anonymized := anonymizedEmail(user.ID)
+	if err := tx.ScrubUserData(ctx, user.Email, anonymized); err != nil {
+		return err
+	}
+	if a.deletion.Mode == "delete" {
+		if err := tx.PurgeUser(ctx, user.ID); err != nil {
+			return err
+		}
+	} else {
+		_, err := a.modifyUser(ctx, tx, user.Email, func(user *models.User) {
+			user.Email = anonymized
+			user.PassHash = []byte{}
+			user.IpCreated = ""
+			user.LastLoginIp = ""
+			user.StatusReason = ""
+		}, "email", "pass_hash", "ip_created", "last_login_ip", "status_reason")
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteUser(ctx, anonymized); err != nil {
+			return err
+		}
+	}
+	return emit(ctx, tx, events.TypeUserDeleted, events.UserDeleted{UserID: user.ID})
+}
+
+func anonymizedEmail(userID int) string {
+	return "deleted-" + strconv.Itoa(userID) + "@deleted.invalid"
+}
+
+// afterPurge drops what outlives a purged user outside the database.
+func (a *Auth) afterPurge(ctx context.Context, email string) {
+	if err := a.sessions.DeleteAll(ctx, email); err != nil {
+		a.logger(ctx).Error("failed to revoke sessions", zap.String("email", email), zap.Error(err))
+	}
+	if err := a.redis.DeleteEmailVerifiedCache(ctx, email); err != nil {
+		a.logger(ctx).Error("error delete email verified", zap.String("email", email), zap.Error(err))
+	}
+}
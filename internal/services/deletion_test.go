@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+func TestPurgeLeavesNoPersonalData(t *testing.T) {
+	for _, mode := range []string{"anonymize", "delete"} {
+		t.Run(mode, func(t *testing.T) {
+			a, db := newTestAuth(t, WithNotifications("en"), WithAccountDeletion(config.DeletionConfig{
+				Mode:      mode,
+				CancelURL: "https://example.com/restore",
+			}))
+			ctx := context.Background()
+			const email = "jane@example.com"
+			token := register(t, a, email)
+			if _, err := a.DeleteAccount(ctx, token, testPassword, "192.0.2.7"); err != nil {
+				t.Fatalf("DeleteAccount: %v", err)
+			}
+			a.purgeDue(ctx)
+
+			if _, err := db.User(ctx, email); err == nil {
+				t.Error("user still found by email")
+			}
+			audit, err := db.AuthEvents(ctx, database.AuthEventFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(audit) == 0 {
+				t.Fatal("audit log is empty")
+			}
+			for _, event := range audit {
+				if event.Subject == email || event.Actor == email || event.IP != "" || event.UserAgent != "" {
+					t.Errorf("audit event %s kept personal data: %+v", event.Type, event)
+				}
+			}
+			queued, err := db.DueNotifications(ctx, maxTime, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range queued {
+				if n.To == email {
+					t.Errorf("notification %s to the user was kept", n.Template)
+				}
+			}
+			outbox, err := db.DueOutboxEvents(ctx, maxTime, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var deleted bool
+			for _, event := range outbox {
+				if bytes.Contains(event.Payload, []byte(email)) {
+					t.Errorf("event %s kept the email: %s", event.Type, event.Payload)
+				}
+				if event.Type == events.TypeUserDeleted {
+					deleted = true
+					var data events.UserDeleted
+					if err := json.Unmarshal(event.Payload, &data); err != nil || data.UserID == 0 {
+						t.Errorf("user deleted payload = %s", event.Payload)
+					}
+				}
+			}
+			if !deleted {
+				t.Error("no user deleted event")
+			}
+		})
+	}
+}
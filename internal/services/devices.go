@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/notifier"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrPasswordResetRequired = errors.New("password reset required")
+	ErrInvalidReportToken    = errors.New("invalid or expired report token")
+)
+
+// Reasons a sign-in is treated as suspicious.
+const (
+	reasonNewDevice  = "new_device"
+	reasonNewCountry = "new_country"
+	reasonNewNetwork = "new_network"
+	reasonInactive   = "inactive"
+)
+
+// versionRe matches the version numbers in a user agent, they are left out
+// of the fingerprint so a browser update is not a new device.
+var versionRe = regexp.MustCompile(`[0-9][0-9._]*`)
+
+// WithDeviceTracking makes Auth remember the devices users sign in from and
+// alert them about sign-ins from new ones.
+func WithDeviceTracking(cfg config.DevicesConfig) Option {
+	return func(a *Auth) {
+		if cfg.Enabled {
+			a.devices = &cfg
+		}
+	}
+}
+
+// fingerprint identifies the device behind ip and userAgent. prefix is the
+// network the address belongs to, empty if ip doesn't parse.
+func (a *Auth) fingerprint(ip, userAgent string) (fingerprint, prefix string) {
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		bits := a.devices.IPv6Prefix
+		if addr.Is4() || addr.Is4In6() {
+			addr, bits = addr.Unmap(), a.devices.IPv4Prefix
+		}
+		if p, err := addr.Prefix(bits); err == nil {
+			prefix = p.String()
+		}
+	}
+	agent := versionRe.ReplaceAllString(strings.ToLower(strings.TrimSpace(userAgent)), "")
+	sum := sha256.Sum256([]byte(prefix + "\n" + agent))
+	return hex.EncodeToString(sum[:]), prefix
+}
+
+// checkDevice records the device user signed in from. A sign-in from a device
+// the user hasn't used before, which also notes a new country or network, or
+// after a long time without any sign-in, is audited and the user gets an alert with a link to report it. Nothing here
+// fails the sign-in, errors are only logged.
+//
+// lastLogin is when the user signed in before this one. A user without any
+// known devices only has the device remembered, otherwise everyone would be
+// alerted on their first sign-in after tracking was turned on.
+func (a *Auth) checkDevice(ctx context.Context, user models.User, ip string, lastLogin time.Time) {
+	if a.devices == nil {
+		return
+	}
+	log := a.logger(ctx).With(zap.String("email", user.Email))
+	userAgent := userAgentFrom(ctx)
+	fingerprint, prefix := a.fingerprint(ip, userAgent)
+	client := a.client(ip)
+	now := time.Now()
+
+	var reasons []string
+	err := a.db.WithTx(ctx, func(tx database.Database) error {
+		devices, err := tx.KnownDevices(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		device := models.KnownDevice{UserID: user.ID, Fingerprint: fingerprint, FirstSeenAt: now}
+		for _, known := range devices {
+			if known.Fingerprint == fingerprint {
+				device = known
+				break
+			}
+		}
+		if device.ID == 0 && len(devices) > 0 {
+			reasons = append(reasons, reasonNewDevice)
+			reasons = append(reasons, newLocation(devices, client)...)
+		}
+		if !lastLogin.IsZero() && now.Sub(lastLogin) > a.devices.InactivityThreshold {
+			reasons = append(reasons, reasonInactive)
+		}
+		device.IPPrefix, device.UserAgent, device.LastSeenAt = prefix, truncate(userAgent, 512), now
+		device.Country, device.ASN = client.Country, client.ASN
+		if err := tx.SaveKnownDevice(ctx, &device); err != nil {
+			return err
+		}
+		if len(reasons) == 0 {
+			return nil
+		}
+		return a.alertLogin(ctx, tx, user, device, client, reasons)
+	})
+	if err != nil {
+		log.Error("failed to check sign-in device", zap.Error(err))
+		return
+	}
+	if len(reasons) > 0 {
+		log.Warn("suspicious sign-in", zap.Strings("reasons", reasons), zap.String("ip", ip))
+		event := succeeded(models.EventNewDevice, user.Email, ip)
+		event.Reason = strings.Join(reasons, ",")
+		a.audit(ctx, event)
+	}
+}
+
+// rememberDevice records the device of a sign-up, so the first regular
+// sign-in from it isn't reported.
+func (a *Auth) rememberDevice(ctx context.Context, email, ip string) {
+	if a.devices == nil {
+		return
+	}
+	user, err := a.db.User(ctx, email)
+	if err != nil {
+		a.logger(ctx).Error("failed to get user", zap.Error(err))
+		return
+	}
+	userAgent := userAgentFrom(ctx)
+	fingerprint, prefix := a.fingerprint(ip, userAgent)
+	client := a.client(ip)
+	now := time.Now()
+	err = a.db.SaveKnownDevice(ctx, &models.KnownDevice{
+		UserID:      user.ID,
+		Fingerprint: fingerprint,
+		IPPrefix:    prefix,
+		UserAgent:   truncate(userAgent, 512),
+		Country:     client.Country,
+		ASN:         client.ASN,
+		FirstSeenAt: now,
+		LastSeenAt:  now,
+	})
+	if err != nil {
+		a.logger(ctx).Error("failed to save device", zap.Error(err))
+	}
+}
+
+// newLocation tells what is unusual about client compared to the devices
+// the user signed in from before. Devices that couldn't be located don't
+// count, so nothing is reported until the first located sign-in.
+func newLocation(devices []models.KnownDevice, client storage.Client) []string {
+	var located, countrySeen, asnLocated, asnSeen bool
+	for _, device := range devices {
+		if device.Country != "" {
+			located = true
+			countrySeen = countrySeen || device.Country == client.Country
+		}
+		if device.ASN != 0 {
+			asnLocated = true
+			asnSeen = asnSeen || device.ASN == client.ASN
+		}
+	}
+	var reasons []string
+	if client.Country != "" && located && !countrySeen {
+		reasons = append(reasons, reasonNewCountry)
+	}
+	if client.ASN != 0 && asnLocated && !asnSeen {
+		reasons = append(reasons, reasonNewNetwork)
+	}
+	return reasons
+}
+
+// alertLogin stores a report link for the sign-in and queues the alert.
+func (a *Auth) alertLogin(ctx context.Context, tx database.Database, user models.User, device models.KnownDevice, client storage.Client, reasons []string) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = tx.AddLoginAlert(ctx, &models.LoginAlert{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		DeviceID:  device.ID,
+		Reasons:   strings.Join(reasons, ","),
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.devices.ReportTTL),
+	})
+	if err != nil {
+		return err
+	}
+	link, err := tokenLink(a.devices.ReportURL, token)
+	if err != nil {
+		return err
+	}
+	location := client.Country
+	if client.City != "" {
+		location = client.City + ", " + client.Country
+	}
+	return a.sendNotification(ctx, tx, user.Email, notifier.TemplateNewDevice, map[string]string{
+		"IP":       client.IP,
+		"Location": location,
+		"Device":   device.UserAgent,
+		"Link":     link,
+	})
+}
+
+// ReportLogin handles the "this wasn't me" link of a sign-in alert. The
+// device is forgotten, the user has to set a new password before signing in
+// again and is emailed a link to do so, and every session of the user ends.
+func (a *Auth) ReportLogin(ctx context.Context, token string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ReportLogin")
+	defer func() { endSpan(span, err) }()
+	log := a.logger(ctx)
+
+	var user models.User
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		alert, err := tx.LoginAlert(ctx, hashToken(token))
+		if errors.Is(err, storage.ErrLoginAlertNotFound) {
+			return ErrInvalidReportToken
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if alert.UsedAt != nil || !now.Before(alert.ExpiresAt) {
+			return ErrInvalidReportToken
+		}
+		alert.UsedAt = &now
+		if err := tx.UpdateLoginAlert(ctx, alert); err != nil {
+			return err
+		}
+		if err := tx.DeleteKnownDevice(ctx, alert.DeviceID); err != nil {
+			return err
+		}
+		if user, err = tx.UserByID(ctx, alert.UserID); err != nil {
+			return err
+		}
+		user, err = a.modifyUser(ctx, tx, user.Email, func(user *models.User) {
+			user.PasswordResetRequired = true
+			user.PasswordResetReason = models.ResetReasonReported
+		}, "password_reset_required", "password_reset_reason")
+		if err != nil {
+			return err
+		}
+		return a.sendResetLink(ctx, tx, user)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidReportToken) || errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("invalid login report token")
+			return ErrInvalidReportToken
+		}
+		log.Error("failed to report login", zap.Error(err))
+		return err
+	}
+	if err := a.sessions.DeleteAll(ctx, user.Email); err != nil {
+		log.Error("failed to revoke sessions", zap.String("email", user.Email), zap.Error(err))
+		return err
+	}
+	a.audit(ctx, succeeded(models.EventLoginReported, user.Email, ""))
+	log.Warn("sign-in reported by user, sessions revoked", zap.String("email", user.Email))
+	return nil
+}
+
+// sendResetLink emails user a link to choose a new password with, the only
+// way back in after a report.
+func (a *Auth) sendResetLink(ctx context.Context, tx database.Database, user models.User) error {
+	if a.devices == nil {
+		// tracking was switched off after the alert went out
+		a.logger(ctx).Warn("no reset link sent, device tracking is off", zap.String("email", user.Email))
+		return nil
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = tx.AddPasswordResetToken(ctx, &models.PasswordResetToken{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(a.devices.ResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+	link, err := tokenLink(a.devices.ResetURL, token)
+	if err != nil {
+		return err
+	}
+	return a.sendNotification(ctx, tx, user.Email, notifier.TemplatePasswordReset, map[string]string{"Link": link})
+}
+
+// newToken returns a random token for a link or a one-off action, only its
+// hashToken is stored.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
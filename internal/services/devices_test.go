@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/notifier"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+)
+
+var testDevices = config.DevicesConfig{
+	Enabled:             true,
+	IPv4Prefix:          24,
+	IPv6Prefix:          48,
+	InactivityThreshold: 24 * time.Hour,
+	ReportURL:           "https://example.com/report",
+	ReportTTL:           time.Hour,
+	ResetURL:            "https://example.com/reset",
+	ResetTTL:            time.Hour,
+}
+
+// queuedLink returns the token in the link of the only queued notification
+// of template.
+func queuedLink(t *testing.T, db *memory.Database, template string) string {
+	t.Helper()
+	due, err := db.DueNotifications(context.Background(), maxTime, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []models.Notification
+	for _, n := range due {
+		if n.Template == template {
+			found = append(found, n)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("queued %d %s notifications, want 1", len(found), template)
+	}
+	link, err := url.Parse(found[0].Data["Link"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link.Query().Get("token")
+}
+
+func TestReportLoginEmailsResetLink(t *testing.T) {
+	a, db := newTestAuth(t, WithDeviceTracking(testDevices), WithNotifications("en"))
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	// a sign-in from another network is reported
+	login(t, a, "jane@example.com", testPassword, "198.51.100.7")
+	reportToken := queuedLink(t, db, notifier.TemplateNewDevice)
+
+	if err := a.ReportLogin(ctx, reportToken); err != nil {
+		t.Fatalf("ReportLogin: %v", err)
+	}
+	_, _, err := a.Login(ctx, "jane@example.com", testPassword, "192.0.2.1", "")
+	var resetErr *ResetRequiredError
+	if !errors.Is(err, ErrPasswordResetRequired) || errors.As(err, &resetErr) {
+		t.Fatalf("Login after a report = %v, want ErrPasswordResetRequired without a token", err)
+	}
+
+	resetToken := queuedLink(t, db, notifier.TemplatePasswordReset)
+	if _, _, err := a.ResetPassword(ctx, resetToken, "a brand new horse battery 12", "192.0.2.1"); err != nil {
+		t.Fatalf("ResetPassword with the emailed token: %v", err)
+	}
+	login(t, a, "jane@example.com", "a brand new horse battery 12", "192.0.2.1")
+	if _, _, err := a.ResetPassword(ctx, resetToken, "yet another horse battery 13", "192.0.2.1"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("second ResetPassword = %v, want ErrInvalidResetToken", err)
+	}
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"go.uber.org/zap"
+)
+
+// WithEmailValidator makes Auth check the addresses of new accounts and
+// email changes against v's domain lists. Without it they are only
+// checked to be well formed.
+func WithEmailValidator(v *mailaddr.Validator) Option {
+	return func(a *Auth) {
+		a.emails = v
+	}
+}
+
+// validateEmail returns email in its stored form if a new account or an
+// email change may use it, and a *mailaddr.Error if not.
+func (a *Auth) validateEmail(ctx context.Context, email string) (string, error) {
+	normalized, err := a.emails.Validate(email)
+	if err != nil {
+		a.logger(ctx).Info("email rejected", zap.String("email", email), zap.Error(err))
+	}
+	return normalized, err
+}
@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+// emit writes an event to the outbox through tx, so it is published only if
+// the transaction commits.
+func emit(ctx context.Context, tx database.Database, eventType string, data any) error {
+	event, err := events.New(eventType, data)
+	if err != nil {
+		return err
+	}
+	return tx.AddOutboxEvent(ctx, &event)
+}
@@ -0,0 +1,401 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+// ErrExportRateLimited is returned by ExportUserData once the user made as
+// many exports as ExportConfig allows in its window.
+var ErrExportRateLimited = errors.New("too many data exports")
+
+// exportFormat is bumped whenever a file of the archive changes shape.
+const exportFormat = 1
+
+// WithDataExport limits the exports users make for themselves.
+func WithDataExport(cfg config.ExportConfig) Option {
+	return func(a *Auth) {
+		a.export = cfg
+	}
+}
+
+// ExportUserData builds the data export of the user signed in with token,
+// see ExportUser. Users get a few of them per window.
+func (a *Auth) ExportUserData(ctx context.Context, token, ip string) (archive []byte, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ExportUserData")
+	defer func() { endSpan(span, err) }()
+	session, err := a.session(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	email := session.Email
+	log := a.logger(ctx).With(zap.String("email", email), zap.String("ip", ip))
+	if err := a.checkExportRate(ctx, email); err != nil {
+		if errors.Is(err, ErrExportRateLimited) {
+			log.Info("data export rate limited")
+			a.audit(ctx, failed(models.EventDataExport, email, ip, "rate_limited"))
+		}
+		return nil, err
+	}
+	archive, err = a.buildExport(ctx, email)
+	if err != nil {
+		log.Error("failed to export user data", zap.Error(err))
+		a.audit(ctx, failed(models.EventDataExport, email, ip, "internal_error"))
+		return nil, err
+	}
+	log.Info("user data exported", zap.Int("bytes", len(archive)))
+	a.audit(ctx, succeeded(models.EventDataExport, email, ip))
+	return archive, nil
+}
+
+// ExportUser builds the data export of the user for a data subject access
+// request: a zip of JSON files with everything kept about them but
+// secrets, such as password and token hashes. Unlike ExportUserData it is
+// not rate limited, it is meant for operators.
+func (a *Auth) ExportUser(ctx context.Context, email string) (archive []byte, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ExportUser")
+	defer func() { endSpan(span, err) }()
+	archive, err = a.buildExport(ctx, email)
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			a.logger(ctx).Error("failed to export user data", zap.String("email", email), zap.Error(err))
+		}
+		return nil, err
+	}
+	a.logger(ctx).Info("user data exported", zap.String("email", email), zap.Int("bytes", len(archive)))
+	a.auditAdmin(ctx, email, "user data exported")
+	return archive, nil
+}
+
+// checkExportRate counts the successful exports of the user in the window.
+func (a *Auth) checkExportRate(ctx context.Context, email string) error {
+	if a.export.RateLimit <= 0 {
+		return nil
+	}
+	exports, err := a.db.AuthEvents(ctx, database.AuthEventFilter{
+		Subject: email,
+		Type:    models.EventDataExport,
+		Since:   time.Now().Add(-a.export.RateWindow),
+	})
+	if err != nil {
+		a.logger(ctx).Error("failed to count data exports", zap.String("email", email), zap.Error(err))
+		return err
+	}
+	var n int
+	for _, event := range exports {
+		if event.Result == models.ResultSuccess {
+			n++
+		}
+	}
+	if n >= a.export.RateLimit {
+		return ErrExportRateLimited
+	}
+	return nil
+}
+
+// The files of the export. Fields are listed one by one so that nothing new
+// ends up in it without a look at whether it is a secret.
+type (
+	exportManifest struct {
+		Format      int       `json:"format"`
+		Email       string    `json:"email"`
+		GeneratedAt time.Time `json:"generated_at"`
+		Files       []string  `json:"files"`
+	}
+	exportUser struct {
+		ID                    int        `json:"id"`
+		Email                 string     `json:"email"`
+		EmailVerified         bool       `json:"email_verified"`
+		HasPassword           bool       `json:"has_password"`
+		PasswordChangedAt     time.Time  `json:"password_changed_at"`
+		PasswordResetRequired bool       `json:"password_reset_required"`
+		Status                string     `json:"status"`
+		StatusReason          string     `json:"status_reason,omitempty"`
+		StatusUntil           *time.Time `json:"status_until,omitempty"`
+		Roles                 []string   `json:"roles"`
+		IPCreated             string     `json:"ip_created"`
+		LastLoginIP           string     `json:"last_login_ip"`
+		LastLoginAt           time.Time  `json:"last_login_at"`
+		CreatedAt             time.Time  `json:"created_at"`
+		UpdatedAt             time.Time  `json:"updated_at"`
+	}
+	exportIdentity struct {
+		// Provider is "password" or the OAuth provider.
+		Provider  string     `json:"provider"`
+		FirstSeen *time.Time `json:"first_seen,omitempty"`
+		LastSeen  *time.Time `json:"last_seen,omitempty"`
+	}
+	exportSession struct {
+		CreatedAt time.Time `json:"created_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+		IP        string    `json:"ip"`
+		Country   string    `json:"country,omitempty"`
+		City      string    `json:"city,omitempty"`
+	}
+	exportDevice struct {
+		IPPrefix    string    `json:"ip_prefix"`
+		UserAgent   string    `json:"user_agent"`
+		Country     string    `json:"country,omitempty"`
+		FirstSeenAt time.Time `json:"first_seen_at"`
+		LastSeenAt  time.Time `json:"last_seen_at"`
+	}
+	exportBalance struct {
+		Currency string `json:"currency"`
+		// Amount is in minor units, as are those of the transactions.
+		Amount int64 `json:"amount"`
+	}
+	exportBalanceTransaction struct {
+		Kind         string    `json:"kind"`
+		Amount       int64     `json:"amount"`
+		Currency     string    `json:"currency"`
+		BalanceAfter int64     `json:"balance_after"`
+		Description  string    `json:"description,omitempty"`
+		CreatedAt    time.Time `json:"created_at"`
+	}
+	exportEvent struct {
+		Type      string    `json:"type"`
+		Result    string    `json:"result"`
+		Reason    string    `json:"reason,omitempty"`
+		IP        string    `json:"ip,omitempty"`
+		Country   string    `json:"country,omitempty"`
+		City      string    `json:"city,omitempty"`
+		UserAgent string    `json:"user_agent,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+)
+
+func (a *Auth) buildExport(ctx context.Context, email string) ([]byte, error) {
+	user, err := a.db.User(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := a.db.UserRoles(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := a.sessions.List(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := a.db.KnownDevices(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := a.allAuthEvents(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := a.db.Balances(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := a.allBalanceTransactions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	u := exportUser{
+		ID:                    user.ID,
+		Email:                 user.Email,
+		EmailVerified:         user.EmailVerified,
+		HasPassword:           len(user.PassHash) > 0,
+		PasswordChangedAt:     user.PasswordChangedAt,
+		PasswordResetRequired: user.PasswordResetRequired,
+		Status:                user.Status,
+		StatusReason:          user.StatusReason,
+		StatusUntil:           user.StatusUntil,
+		Roles:                 []string{},
+		IPCreated:             user.IpCreated,
+		LastLoginIP:           user.LastLoginIp,
+		LastLoginAt:           user.LastLoginDate,
+		CreatedAt:             user.CreatedAt,
+		UpdatedAt:             user.UpdatedAt,
+	}
+	for _, role := range roles {
+		u.Roles = append(u.Roles, role.Name)
+	}
+	exportSessions := make([]exportSession, len(sessions))
+	for i, s := range sessions {
+		exportSessions[i] = exportSession{
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+			IP:        s.IP,
+			Country:   s.Country,
+			City:      s.City,
+		}
+	}
+	exportDevices := make([]exportDevice, len(devices))
+	for i, d := range devices {
+		exportDevices[i] = exportDevice{
+			IPPrefix:    d.IPPrefix,
+			UserAgent:   d.UserAgent,
+			Country:     d.Country,
+			FirstSeenAt: d.FirstSeenAt,
+			LastSeenAt:  d.LastSeenAt,
+		}
+	}
+	exportBalances := make([]exportBalance, len(balances))
+	for i, b := range balances {
+		exportBalances[i] = exportBalance{Currency: b.Currency, Amount: b.Amount}
+	}
+	exportLedger := make([]exportBalanceTransaction, len(ledger))
+	for i, tx := range ledger {
+		exportLedger[i] = exportBalanceTransaction{
+			Kind:         tx.Kind,
+			Amount:       tx.Amount,
+			Currency:     tx.Currency,
+			BalanceAfter: tx.BalanceAfter,
+			Description:  tx.Description,
+			CreatedAt:    tx.CreatedAt,
+		}
+	}
+	auditEvents := make([]exportEvent, len(events))
+	logins := []exportEvent{}
+	for i, event := range events {
+		auditEvents[i] = exportEvent{
+			Type:      event.Type,
+			Result:    event.Result,
+			Reason:    event.Reason,
+			IP:        event.IP,
+			Country:   event.Country,
+			City:      event.City,
+			UserAgent: event.UserAgent,
+			CreatedAt: event.CreatedAt,
+		}
+		if event.Type == models.EventLogin || event.Type == models.EventOAuthLogin {
+			logins = append(logins, auditEvents[i])
+		}
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"user.json", u},
+		{"identities.json", identities(user, events)},
+		{"sessions.json", exportSessions},
+		{"devices.json", exportDevices},
+		{"login_history.json", logins},
+		{"audit_events.json", auditEvents},
+		{"balances.json", exportBalances},
+		{"balance_transactions.json", exportLedger},
+	}
+	manifest := exportManifest{
+		Format:      exportFormat,
+		Email:       user.Email,
+		GeneratedAt: time.Now().UTC(),
+	}
+	for _, f := range files {
+		manifest.Files = append(manifest.Files, f.name)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := writeJSON(zw, "manifest.json", manifest); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if err := writeJSON(zw, f.name, f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// allAuthEvents returns every event about the user still in the audit log,
+// newest first.
+func (a *Auth) allAuthEvents(ctx context.Context, email string) ([]models.AuthEvent, error) {
+	var all []models.AuthEvent
+	filter := database.AuthEventFilter{Subject: email, Limit: maxPageSize}
+	for {
+		events, err := a.db.AuthEvents(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, events...)
+		if len(events) < maxPageSize {
+			return all, nil
+		}
+		filter.BeforeID = events[len(events)-1].ID
+	}
+}
+
+// allBalanceTransactions returns the user's whole ledger, newest first.
+func (a *Auth) allBalanceTransactions(ctx context.Context, userID int) ([]models.BalanceTransaction, error) {
+	var all []models.BalanceTransaction
+	var beforeID int64
+	for {
+		txs, err := a.db.UserBalanceTransactions(ctx, userID, beforeID, maxPageSize)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, txs...)
+		if len(txs) < maxPageSize {
+			return all, nil
+		}
+		beforeID = txs[len(txs)-1].ID
+	}
+}
+
+// identities lists how the user signs in: with a password if they have one
+// and with every OAuth provider they signed in through. Providers aren't
+// stored with the user, so they are taken from the audit log and one not
+// used within its retention is missing.
+func identities(user models.User, events []models.AuthEvent) []exportIdentity {
+	ids := []exportIdentity{}
+	if len(user.PassHash) > 0 {
+		ids = append(ids, exportIdentity{Provider: "password"})
+	}
+	type seen struct{ first, last time.Time }
+	providers := map[string]*seen{}
+	for _, event := range events {
+		if event.Type != models.EventOAuthLogin || event.Result != models.ResultSuccess || event.Reason == "" {
+			continue
+		}
+		p, ok := providers[event.Reason]
+		if !ok {
+			p = &seen{first: event.CreatedAt, last: event.CreatedAt}
+			providers[event.Reason] = p
+		}
+		if event.CreatedAt.Before(p.first) {
+			p.first = event.CreatedAt
+		}
+		if event.CreatedAt.After(p.last) {
+			p.last = event.CreatedAt
+		}
+	}
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := providers[name]
+		ids = append(ids, exportIdentity{Provider: name, FirstSeen: &p.first, LastSeen: &p.last})
+	}
+	return ids
+}
+
+func writeJSON(zw *zip.Writer, name string, v any) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
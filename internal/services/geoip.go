@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/geoip"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+var ErrCountryBlocked = errors.New("country is blocked")
+
+// WithGeoIP makes Auth locate client addresses through db and, as cfg says,
+// turn away sign-ins and sign-ups from blocked countries.
+func WithGeoIP(db *geoip.DB, cfg config.GeoIPConfig) Option {
+	return func(a *Auth) {
+		a.geo = db
+		a.countries = geoip.NewPolicy(cfg.AllowCountries, cfg.DenyCountries, cfg.BlockUnknown)
+		a.blockLogin = cfg.BlockLogin
+		a.blockRegister = cfg.BlockRegister
+	}
+}
+
+// client describes where a request from ip comes from.
+func (a *Auth) client(ip string) storage.Client {
+	loc := a.geo.Lookup(ip)
+	return storage.Client{IP: ip, Country: loc.Country, City: loc.City, ASN: loc.ASN}
+}
+
+// countryBlocked reports whether client may not sign in, or sign up when
+// register is set.
+func (a *Auth) countryBlocked(client storage.Client, register bool) bool {
+	if register && !a.blockRegister || !register && !a.blockLogin {
+		return false
+	}
+	return !a.countries.Allowed(client.Country)
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+	"go.uber.org/zap"
+)
+
+const testPassword = "correct horse battery staple 9"
+
+// newTestAuth returns an Auth on empty in-memory storage.
+func newTestAuth(t *testing.T, opts ...Option) (*Auth, *memory.Database) {
+	t.Helper()
+	log := zap.NewNop()
+	db := memory.NewDatabase(nil)
+	a := New(log, db, memory.NewRedis(db, log, nil), memory.NewSessions(nil), time.Hour, 24*time.Hour, opts...)
+	return a, db
+}
+
+// register creates a user with testPassword and returns their session.
+func register(t *testing.T, a *Auth, email string) string {
+	t.Helper()
+	token, _, err := a.Register(context.Background(), email, testPassword, "192.0.2.1", "")
+	if err != nil {
+		t.Fatalf("Register(%q): %v", email, err)
+	}
+	return token
+}
+
+// maxTime is later than any due time, for listing everything queued.
+var maxTime = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/logger"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// TestLogsLeakNothing runs the account flows with every log level enabled
+// and checks the output for passwords, tokens, addresses and IPs.
+func TestLogsLeakNothing(t *testing.T) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.Lock(zapcore.AddSync(&buf)),
+		zapcore.DebugLevel,
+	)
+	log := zap.New(logger.NewRedactingCore(core, logger.Policy{Email: "mask", IP: "truncate"}))
+	db := memory.NewDatabase(nil)
+	a := New(log, db, memory.NewRedis(db, log, nil), memory.NewSessions(nil), time.Hour, 24*time.Hour)
+	ctx := context.Background()
+
+	const (
+		email    = "jane.doe@example.com"
+		newEmail = "jane.roe@example.com"
+		ip       = "192.0.2.77"
+		newPass  = "another horse battery staple 10"
+		resetTo  = "third horse battery staple 11"
+	)
+	var secrets []string
+	keep := func(token string) string {
+		if token != "" {
+			secrets = append(secrets, token)
+		}
+		return token
+	}
+
+	token := keep(register(t, a, email))
+	if _, _, err := a.Login(ctx, email, "wrong password", ip, ""); err == nil {
+		t.Fatal("Login with a wrong password succeeded")
+	}
+	token = keep(login(t, a, email, testPassword, ip))
+	if a.GetUserEmail(ctx, token) != email {
+		t.Fatal("GetUserEmail lost the session")
+	}
+	token, _, err := a.ChangePass(ctx, email, newPass, ip, token)
+	if err != nil {
+		t.Fatalf("ChangePass: %v", err)
+	}
+	keep(token)
+	token, _, err = a.ChangeEmail(ctx, email, newEmail, token)
+	if err != nil {
+		t.Fatalf("ChangeEmail: %v", err)
+	}
+	keep(token)
+	if err := a.RequirePasswordReset(ctx, newEmail); err != nil {
+		t.Fatalf("RequirePasswordReset: %v", err)
+	}
+	_, _, err = a.Login(ctx, newEmail, newPass, ip, "")
+	var reset *ResetRequiredError
+	if !errors.As(err, &reset) {
+		t.Fatalf("Login after RequirePasswordReset = %v, want a ResetRequiredError", err)
+	}
+	keep(reset.Token)
+	token, _, err = a.ResetPassword(ctx, reset.Token, resetTo, ip)
+	if err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	keep(token)
+	if err := a.Logout(ctx, token); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+
+	out := buf.String()
+	if out == "" {
+		t.Fatal("nothing was logged")
+	}
+	for _, leak := range append(secrets, testPassword, newPass, resetTo, "wrong password", email, newEmail, ip) {
+		if strings.Contains(out, leak) {
+			t.Errorf("logs contain %q", leak)
+		}
+	}
+	if !strings.Contains(out, "j***@example.com") {
+		t.Error("logs have no masked email, was the redaction bypassed?")
+	}
+}
+
+func login(t *testing.T, a *Auth, email, password, ip string) string {
+	t.Helper()
+	token, _, err := a.Login(context.Background(), email, password, ip, "")
+	if err != nil {
+		t.Fatalf("Login(%q): %v", email, err)
+	}
+	return token
+}
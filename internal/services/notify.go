@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/GosMachine/ServiceAuth/internal/notifier"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+// WithNotifications makes Auth queue messages to users, rendered in locale
+// unless a template for it is missing. Without it nothing is sent.
+func WithNotifications(locale string) Option {
+	return func(a *Auth) {
+		a.notify = true
+		a.locale = locale
+	}
+}
+
+// sendNotification queues the template for to through tx, so the message goes
+// out only if the change it reports is committed.
+func (a *Auth) sendNotification(ctx context.Context, tx database.Database, to, template string, data map[string]string) error {
+	if !a.notify {
+		return nil
+	}
+	return notifier.Enqueue(ctx, tx, to, template, a.locale, data)
+}
+
+// tokenLink adds token to the query of base, for the one-time links in
+// messages.
+func tokenLink(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
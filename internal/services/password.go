@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/notifier"
+	"github.com/GosMachine/ServiceAuth/internal/password"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// ResetReasonExpired is the reason Login asks for a new password when the
+// current one is older than the maximum age. The others are the
+// models.ResetReason constants.
+const ResetReasonExpired = "expired"
+
+// ResetRequiredError is returned by Login when the password was right but
+// has to be changed before a session is handed out. Token only works with
+// ResetPassword.
+type ResetRequiredError struct {
+	Reason   string
+	Token    string
+	TokenTTL time.Duration
+}
+
+func (e *ResetRequiredError) Error() string {
+	return "password reset required: " + e.Reason
+}
+
+func (e *ResetRequiredError) Is(target error) bool {
+	return target == ErrPasswordResetRequired
+}
+
+// WithPasswordPolicy makes Auth reject new passwords that break policy.
+func WithPasswordPolicy(policy *password.Policy) Option {
+	return func(a *Auth) {
+		a.passwords = policy
+	}
+}
+
+// WithPasswordRotation makes passwords expire after cfg.MaxAge and sets how
+// long the reset token Login hands out instead of a session stays valid.
+func WithPasswordRotation(cfg config.PasswordConfig) Option {
+	return func(a *Auth) {
+		a.maxPasswordAge = cfg.MaxAge
+		a.resetTokenTTL = cfg.ResetTokenTTL
+	}
+}
+
+// checkPassword tells whether pass may become the password of email.
+// previous are the hashes of passwords the user had before, newest first.
+func (a *Auth) checkPassword(ctx context.Context, email, pass string, previous ...[]byte) error {
+	if a.passwords == nil {
+		return nil
+	}
+	_, span := a.startSpan(ctx, "password.policy")
+	defer span.End()
+	var hashes [][]byte
+	for _, hash := range previous {
+		// OAuth users have no password
+		if len(hash) > 0 {
+			hashes = append(hashes, hash)
+		}
+	}
+	log := a.logger(ctx).With(zap.String("email", email))
+	warnings, err := a.passwords.Check(password.Candidate{Password: pass, Email: email, Previous: hashes})
+	for _, w := range warnings {
+		log.Warn("weak password accepted", zap.String("violation", w.Code))
+	}
+	var policyErr *password.PolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		log.Info("password rejected by policy", zap.Error(err))
+	case err != nil:
+		log.Error("failed to check password", zap.Error(err))
+	}
+	return err
+}
+
+// passwordFailure is the audit reason for an error from checkPassword.
+func passwordFailure(err error) string {
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return "password_policy"
+	}
+	return "internal_error"
+}
+
+// ResetPassword sets a new password with a reset token from Login, ends
+// every other session of the user and returns a regular one.
+func (a *Auth) ResetPassword(ctx context.Context, resetToken, pass, ip string) (token string, tokenTTL time.Duration, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ResetPassword")
+	defer func() { endSpan(span, err) }()
+	log := a.logger(ctx).With(zap.String("ip", ip))
+
+	hash := hashToken(resetToken)
+	reset, err := a.db.PasswordResetToken(ctx, hash)
+	if err == nil && (reset.UsedAt != nil || !time.Now().Before(reset.ExpiresAt)) {
+		err = ErrInvalidResetToken
+	}
+	var user models.User
+	if err == nil {
+		user, err = a.db.UserByID(ctx, reset.UserID)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidResetToken) || errors.Is(err, storage.ErrResetTokenNotFound) || errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("invalid password reset token")
+			return "", 0, ErrInvalidResetToken
+		}
+		log.Error("failed to get password reset token", zap.Error(err))
+		return "", 0, err
+	}
+	email := user.Email
+	log = log.With(zap.String("email", email))
+	if err := accountStatus(user); err != nil {
+		log.Info("account is not active", zap.Error(err))
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, "account_"+user.Status))
+		return "", 0, err
+	}
+
+	previous, err := a.previousPasswords(ctx, user)
+	if err != nil {
+		log.Error("failed to get password history", zap.Error(err))
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, "internal_error"))
+		return "", 0, err
+	}
+	if err := a.checkPassword(ctx, email, pass, previous...); err != nil {
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, passwordFailure(err)))
+		return "", 0, err
+	}
+	passHash, err := a.hashPassword(ctx, pass)
+	if err != nil {
+		log.Error("failed to generate password hash", zap.Error(err))
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, "internal_error"))
+		return "", 0, err
+	}
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		reset, err := tx.PasswordResetToken(ctx, hash)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if reset.UsedAt != nil || !now.Before(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+		reset.UsedAt = &now
+		if err := tx.UpdatePasswordResetToken(ctx, reset); err != nil {
+			return err
+		}
+		return a.setPassword(ctx, tx, email, passHash, ip)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidResetToken) || errors.Is(err, storage.ErrResetTokenNotFound) {
+			log.Info("password reset token used concurrently")
+			return "", 0, ErrInvalidResetToken
+		}
+		log.Error("failed to reset password", zap.Error(err))
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, "internal_error"))
+		return "", 0, err
+	}
+	event := succeeded(models.EventPasswordChange, email, ip)
+	event.Reason = "reset"
+	a.audit(ctx, event)
+	if err := a.sessions.DeleteAll(ctx, email); err != nil {
+		log.Error("failed to revoke sessions", zap.Error(err))
+	}
+	token, tokenTTL = a.createToken(ctx, email, ip, "on")
+	if token == "" {
+		log.Error("failed to generate token")
+		return "", 0, fmt.Errorf("failed to generate token")
+	}
+	log.Info("password reset")
+	return token, tokenTTL, nil
+}
+
+// RequirePasswordReset makes the user choose a new password at the next
+// sign-in and ends all of their sessions.
+func (a *Auth) RequirePasswordReset(ctx context.Context, email string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.RequirePasswordReset")
+	defer func() { endSpan(span, err) }()
+	log := a.logger(ctx).With(zap.String("email", email))
+	_, err = a.modifyUser(ctx, a.db, email, func(user *models.User) {
+		user.PasswordResetRequired = true
+		// a reported sign-in stays the stricter reason
+		if user.PasswordResetReason != models.ResetReasonReported {
+			user.PasswordResetReason = models.ResetReasonAdmin
+		}
+	}, "password_reset_required", "password_reset_reason")
+	if err != nil {
+		if !errors.Is(err, storage.ErrUserNotFound) {
+			log.Error("failed to require password reset", zap.Error(err))
+		}
+		return err
+	}
+	if err := a.sessions.DeleteAll(ctx, email); err != nil {
+		log.Error("failed to revoke sessions", zap.Error(err))
+		return err
+	}
+	a.auditAdmin(ctx, email, "password reset required")
+	log.Info("password reset required by admin")
+	return nil
+}
+
+// resetReason tells why user has to choose a new password before signing
+// in, empty if they don't.
+func (a *Auth) resetReason(user models.User) string {
+	if user.PasswordResetRequired {
+		if user.PasswordResetReason == "" {
+			// set before reasons were recorded, only reports set it then
+			return models.ResetReasonReported
+		}
+		return user.PasswordResetReason
+	}
+	if a.maxPasswordAge > 0 && time.Since(user.PasswordChangedAt) > a.maxPasswordAge {
+		return ResetReasonExpired
+	}
+	return ""
+}
+
+// requireReset refuses a sign-in with the right password that has to be
+// changed first. Unless the sign-in was reported, whoever made it may not
+// know the password, the user gets a reset token to change it with. After
+// a report only the link ReportLogin emailed resets it.
+func (a *Auth) requireReset(ctx context.Context, user models.User, ip, reason string) error {
+	log := a.logger(ctx).With(zap.String("email", user.Email), zap.String("reason", reason))
+	failure := "password_reset_required"
+	if reason == ResetReasonExpired {
+		failure = "password_expired"
+	}
+	if reason == models.ResetReasonReported {
+		log.Info("password reset required")
+		a.metrics.Login(failure)
+		a.audit(ctx, failed(models.EventLogin, user.Email, ip, failure))
+		return ErrPasswordResetRequired
+	}
+	token, err := newToken()
+	if err == nil {
+		now := time.Now()
+		err = a.db.AddPasswordResetToken(ctx, &models.PasswordResetToken{
+			TokenHash: hashToken(token),
+			UserID:    user.ID,
+			CreatedAt: now,
+			ExpiresAt: now.Add(a.resetTokenTTL),
+		})
+	}
+	if err != nil {
+		log.Error("failed to create password reset token", zap.Error(err))
+		a.metrics.Login("error")
+		a.audit(ctx, failed(models.EventLogin, user.Email, ip, "internal_error"))
+		return err
+	}
+	log.Info("password reset required, reset token issued")
+	a.metrics.Login(failure)
+	a.audit(ctx, failed(models.EventLogin, user.Email, ip, failure))
+	return &ResetRequiredError{Reason: reason, Token: token, TokenTTL: a.resetTokenTTL}
+}
+
+// setPassword makes passHash the password of email within tx, keeps the
+// replaced one in the history and tells the user about the change.
+func (a *Auth) setPassword(ctx context.Context, tx database.Database, email string, passHash []byte, ip string) error {
+	var replaced []byte
+	user, err := a.modifyUser(ctx, tx, email, func(user *models.User) {
+		replaced = user.PassHash
+		now := time.Now()
+		user.PassHash = passHash
+		user.PasswordChangedAt = now
+		user.PasswordResetRequired = false
+		user.PasswordResetReason = ""
+		user.LastLoginDate = now
+		if ip != "" {
+			user.LastLoginIp = ip
+		}
+	}, "pass_hash", "password_changed_at", "password_reset_required", "password_reset_reason", "last_login_date", "last_login_ip")
+	if err != nil {
+		return err
+	}
+	// the current password counts towards the history as well
+	keep := max(a.passwordHistory()-1, 0)
+	if len(replaced) > 0 && keep > 0 {
+		if err := tx.AddPasswordHistory(ctx, &models.PasswordHistory{UserID: user.ID, PassHash: replaced}); err != nil {
+			return err
+		}
+	}
+	if err := tx.TrimPasswordHistory(ctx, user.ID, keep); err != nil {
+		return err
+	}
+	if err := emit(ctx, tx, events.TypePasswordChanged, events.PasswordChanged{Email: email}); err != nil {
+		return err
+	}
+	return a.sendNotification(ctx, tx, email, notifier.TemplatePasswordChanged, map[string]string{"IP": ip})
+}
+
+// previousPasswords returns the hashes a new password of user is compared
+// against, the current one first.
+func (a *Auth) previousPasswords(ctx context.Context, user models.User) ([][]byte, error) {
+	previous := [][]byte{user.PassHash}
+	if n := a.passwordHistory(); n > 1 {
+		entries, err := a.db.PasswordHistory(ctx, user.ID, n-1)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			previous = append(previous, entry.PassHash)
+		}
+	}
+	return previous, nil
+}
+
+// passwordHistory is how many passwords, the current one included, may
+// not be set again.
+func (a *Auth) passwordHistory() int {
+	if a.passwords == nil {
+		return 0
+	}
+	return a.passwords.History()
+}
+
+func (a *Auth) hashPassword(ctx context.Context, pass string) ([]byte, error) {
+	_, span := a.startSpan(ctx, "bcrypt.hash")
+	start := time.Now()
+	passHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	a.metrics.ObserveHash("bcrypt", "hash", time.Since(start))
+	endSpan(span, err)
+	return passHash, err
+}
+
+func (a *Auth) comparePassword(ctx context.Context, passHash []byte, pass string) error {
+	_, span := a.startSpan(ctx, "bcrypt.compare")
+	start := time.Now()
+	err := bcrypt.CompareHashAndPassword(passHash, []byte(pass))
+	a.metrics.ObserveHash("bcrypt", "compare", time.Since(start))
+	// a mismatch is an expected outcome, not a failed span
+	span.End()
+	return err
+}
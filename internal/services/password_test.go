@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+)
+
+func TestPasswordlessSignInsHonorReset(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	if err := a.RequirePasswordReset(ctx, "jane@example.com"); err != nil {
+		t.Fatalf("RequirePasswordReset: %v", err)
+	}
+
+	if _, _, err := a.OAuth(ctx, "jane@example.com", "192.0.2.1", "google"); !errors.Is(err, ErrPasswordResetRequired) {
+		t.Errorf("OAuth = %v, want ErrPasswordResetRequired", err)
+	}
+	if _, _, err := a.CreateToken(ctx, "jane@example.com", ""); !errors.Is(err, ErrPasswordResetRequired) {
+		t.Errorf("CreateToken = %v, want ErrPasswordResetRequired", err)
+	}
+
+	_, _, err := a.Login(ctx, "jane@example.com", testPassword, "192.0.2.1", "")
+	var resetErr *ResetRequiredError
+	if !errors.As(err, &resetErr) {
+		t.Fatalf("Login = %v, want a ResetRequiredError", err)
+	}
+	if _, _, err := a.ResetPassword(ctx, resetErr.Token, "a brand new horse battery 12", "192.0.2.1"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if _, _, err := a.OAuth(ctx, "jane@example.com", "192.0.2.1", "google"); err != nil {
+		t.Errorf("OAuth after the reset: %v", err)
+	}
+	if _, _, err := a.CreateToken(ctx, "jane@example.com", ""); err != nil {
+		t.Errorf("CreateToken after the reset: %v", err)
+	}
+}
+
+func TestPasswordlessSignInsIgnorePasswordAge(t *testing.T) {
+	a, _ := newTestAuth(t, WithPasswordRotation(config.PasswordConfig{MaxAge: time.Nanosecond, ResetTokenTTL: time.Minute}))
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	time.Sleep(time.Millisecond)
+
+	if _, _, err := a.Login(ctx, "jane@example.com", testPassword, "192.0.2.1", ""); !errors.Is(err, ErrPasswordResetRequired) {
+		t.Fatalf("Login with an expired password = %v, want ErrPasswordResetRequired", err)
+	}
+	if _, _, err := a.OAuth(ctx, "jane@example.com", "192.0.2.1", "google"); err != nil {
+		t.Errorf("OAuth with an expired password: %v", err)
+	}
+	if _, _, err := a.CreateToken(ctx, "jane@example.com", ""); err != nil {
+		t.Errorf("CreateToken with an expired password: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+const defaultAccessRefresh = time.Minute
+
+// WithAccessRefresh sets how old the roles kept with a session may get
+// before they are read again, which bounds how long a role change takes to
+// reach existing sessions.
+func WithAccessRefresh(interval time.Duration) Option {
+	return func(a *Auth) {
+		a.accessRefresh = interval
+	}
+}
+
+// SeedRoles writes the roles of cfg and their permissions, and assigns the
+// granted roles to their users. Grants for users that don't exist yet are
+// skipped, they apply once the service restarts after sign-up.
+func (a *Auth) SeedRoles(ctx context.Context, cfg config.RBACConfig) error {
+	names := make([]string, 0, len(cfg.Roles))
+	for name := range cfg.Roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return a.db.WithTx(ctx, func(tx database.Database) error {
+		roles := make(map[string]int64, len(names))
+		for _, name := range names {
+			role := models.Role{Name: name, Description: cfg.Roles[name].Description}
+			for _, permission := range cfg.Roles[name].Permissions {
+				role.Permissions = append(role.Permissions, models.Permission{Name: permission})
+			}
+			if err := tx.SaveRole(ctx, &role); err != nil {
+				return err
+			}
+			roles[name] = role.ID
+		}
+		for role, emails := range cfg.Grants {
+			for _, email := range emails {
+				user, err := tx.User(ctx, email)
+				if errors.Is(err, storage.ErrUserNotFound) {
+					a.log.Warn("role granted to unknown user", zap.String("role", role), zap.String("email", email))
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				err = tx.AssignRole(ctx, &models.UserRole{UserID: user.ID, RoleID: roles[role], GrantedBy: "config"})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// AssignRole gives the user the role, it is a no-op if they have it.
+func (a *Auth) AssignRole(ctx context.Context, email, role string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.AssignRole")
+	defer func() { endSpan(span, err) }()
+	err = a.changeRole(ctx, email, role, func(tx database.Database, userID int, roleID int64) error {
+		return tx.AssignRole(ctx, &models.UserRole{UserID: userID, RoleID: roleID, GrantedBy: actorFrom(ctx)})
+	})
+	if err != nil {
+		return err
+	}
+	a.auditAdmin(ctx, email, "role "+role+" assigned")
+	return nil
+}
+
+// RevokeRole takes the role away from the user, it is a no-op if they
+// don't have it.
+func (a *Auth) RevokeRole(ctx context.Context, email, role string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.RevokeRole")
+	defer func() { endSpan(span, err) }()
+	err = a.changeRole(ctx, email, role, func(tx database.Database, userID int, roleID int64) error {
+		return tx.RevokeRole(ctx, userID, roleID)
+	})
+	if err != nil {
+		return err
+	}
+	a.auditAdmin(ctx, email, "role "+role+" revoked")
+	return nil
+}
+
+func (a *Auth) changeRole(ctx context.Context, email, role string, fn func(tx database.Database, userID int, roleID int64) error) error {
+	err := a.db.WithTx(ctx, func(tx database.Database) error {
+		user, err := tx.User(ctx, email)
+		if err != nil {
+			return err
+		}
+		r, err := tx.Role(ctx, role)
+		if err != nil {
+			return err
+		}
+		return fn(tx, user.ID, r.ID)
+	})
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) && !errors.Is(err, storage.ErrRoleNotFound) {
+		a.logger(ctx).Error("failed to change role", zap.String("email", email), zap.String("role", role), zap.Error(err))
+	}
+	return err
+}
+
+// CheckPermission tells whether the session of token allows permission.
+// It returns storage.ErrSessionNotFound for a token that isn't signed in.
+func (a *Auth) CheckPermission(ctx context.Context, token, permission string) (allowed bool, session storage.Session, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.CheckPermission")
+	defer func() { endSpan(span, err) }()
+	session, err = a.session(ctx, token)
+	if err != nil {
+		return false, storage.Session{}, err
+	}
+	return session.Can(permission), session, nil
+}
+
+// session returns the session of token with roles no older than the refresh
+// interval. Sessions of users that were suspended or deleted since are ended
+// when their roles are read again.
+func (a *Auth) session(ctx context.Context, token string) (storage.Session, error) {
+	session, err := a.sessions.Get(ctx, token)
+	if err != nil {
+		if !errors.Is(err, storage.ErrSessionNotFound) {
+			a.logger(ctx).Error("failed to get session", zap.Error(err))
+		}
+		return storage.Session{}, err
+	}
+	if time.Since(session.CheckedAt) < a.accessRefresh {
+		return session, nil
+	}
+	access, err := a.access(ctx, session.Email)
+	if errors.Is(err, ErrAccountInactive) || errors.Is(err, storage.ErrUserNotFound) {
+		a.logger(ctx).Info("ending session of inactive user", zap.String("session", session.ID), zap.Error(err))
+		if err := a.sessions.Delete(ctx, token); err != nil {
+			a.logger(ctx).Error("error delete token", zap.String("session", session.ID), zap.Error(err))
+		}
+		return storage.Session{}, storage.ErrSessionNotFound
+	}
+	if err != nil {
+		a.logger(ctx).Error("failed to get roles", zap.String("email", session.Email), zap.Error(err))
+		return storage.Session{}, err
+	}
+	session.Access = access
+	if err := a.sessions.SetAccess(ctx, token, access); err != nil {
+		// the next check reads the roles again
+		a.logger(ctx).Warn("failed to store session roles", zap.String("session", session.ID), zap.Error(err))
+	}
+	return session, nil
+}
+
+// access reads the current roles and permissions of the user. It returns
+// an AccountStatusError for users that may not have sessions.
+func (a *Auth) access(ctx context.Context, email string) (storage.Access, error) {
+	user, err := a.db.User(ctx, email)
+	if err != nil {
+		return storage.Access{}, err
+	}
+	if err := accountStatus(user); err != nil {
+		return storage.Access{}, err
+	}
+	roles, err := a.db.UserRoles(ctx, email)
+	if err != nil {
+		return storage.Access{}, err
+	}
+	access := storage.Access{CheckedAt: time.Now()}
+	seen := map[string]bool{}
+	for _, role := range roles {
+		access.Roles = append(access.Roles, role.Name)
+		for _, permission := range role.Permissions {
+			if !seen[permission.Name] {
+				seen[permission.Name] = true
+				access.Permissions = append(access.Permissions, permission.Name)
+			}
+		}
+	}
+	sort.Strings(access.Permissions)
+	return access, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+func TestRoleChangesReachSessions(t *testing.T) {
+	a, db := newTestAuth(t, WithAccessRefresh(0))
+	ctx := WithActor(context.Background(), "admin@example.com")
+	token := register(t, a, "jane@example.com")
+	err := a.SeedRoles(ctx, config.RBACConfig{
+		Roles: map[string]config.RoleConfig{"support": {Permissions: []string{"users.read"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name      string
+		change    func() error
+		wantErr   error
+		wantAllow bool
+	}{
+		{"assign", func() error { return a.AssignRole(ctx, "jane@example.com", "support") }, nil, true},
+		{"assign again", func() error { return a.AssignRole(ctx, "jane@example.com", "support") }, nil, true},
+		{"assign unknown role", func() error { return a.AssignRole(ctx, "jane@example.com", "owner") }, storage.ErrRoleNotFound, true},
+		{"assign to unknown user", func() error { return a.AssignRole(ctx, "ann@example.com", "support") }, storage.ErrUserNotFound, true},
+		{"revoke", func() error { return a.RevokeRole(ctx, "jane@example.com", "support") }, nil, false},
+		{"revoke again", func() error { return a.RevokeRole(ctx, "jane@example.com", "support") }, nil, false},
+	}
+	for _, step := range steps {
+		if err := step.change(); !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s = %v, want %v", step.name, err, step.wantErr)
+		}
+		allowed, _, err := a.CheckPermission(ctx, token, "users.read")
+		if err != nil {
+			t.Fatalf("CheckPermission after %s: %v", step.name, err)
+		}
+		if allowed != step.wantAllow {
+			t.Errorf("allowed after %s = %v, want %v", step.name, allowed, step.wantAllow)
+		}
+	}
+
+	audit, err := db.AuthEvents(ctx, database.AuthEventFilter{Type: models.EventAdminAction})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) != 4 {
+		t.Fatalf("recorded %d admin actions, want 4", len(audit))
+	}
+	for _, event := range audit {
+		if event.Actor != "admin@example.com" || event.Subject != "jane@example.com" {
+			t.Errorf("admin action %q by %q on %q", event.Reason, event.Actor, event.Subject)
+		}
+	}
+}
+
+func TestCheckPermissionEndsSessionsOfSuspendedUsers(t *testing.T) {
+	a, _ := newTestAuth(t, WithAccessRefresh(0))
+	ctx := context.Background()
+	token := register(t, a, "jane@example.com")
+	if err := a.DisableUser(ctx, "jane@example.com", "abuse", maxTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.CheckPermission(ctx, token, "users.read"); !errors.Is(err, storage.ErrSessionNotFound) {
+		t.Errorf("CheckPermission of a disabled user = %v, want ErrSessionNotFound", err)
+	}
+}
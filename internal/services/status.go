@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+)
+
+// ErrAccountInactive is returned when a user who isn't active tries to sign
+// in. It is wrapped in an AccountStatusError saying why.
+var ErrAccountInactive = errors.New("account is not active")
+
+// AccountStatusError tells a user why they can't sign in.
+type AccountStatusError struct {
+	// Status is one of the models.User statuses other than active.
+	Status string
+	Reason string
+	// Until is when the status lifts, zero if it doesn't on its own.
+	Until time.Time
+}
+
+func (e *AccountStatusError) Error() string {
+	return "account is " + e.Status
+}
+
+func (e *AccountStatusError) Is(target error) bool {
+	return target == ErrAccountInactive
+}
+
+// accountStatus returns an AccountStatusError unless the user is active.
+func accountStatus(user models.User) error {
+	status := user.StatusAt(time.Now())
+	if status == models.UserActive {
+		return nil
+	}
+	err := &AccountStatusError{Status: status, Reason: user.StatusReason}
+	if user.StatusUntil != nil {
+		err.Until = *user.StatusUntil
+	}
+	return err
+}
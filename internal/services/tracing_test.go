@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTracedAuth(t *testing.T) (*Auth, *tracetest.SpanRecorder) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	a, _ := newTestAuth(t, WithTracerProvider(tp))
+	return a, rec
+}
+
+// named returns the spans whose name starts with prefix.
+func named(spans []sdktrace.ReadOnlySpan, prefix string) []sdktrace.ReadOnlySpan {
+	var out []sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if strings.HasPrefix(span.Name(), prefix) {
+			out = append(out, span)
+		}
+	}
+	return out
+}
+
+func TestSpans(t *testing.T) {
+	a, rec := newTracedAuth(t)
+	register(t, a, "jane@example.com")
+	ctx := context.Background()
+	if _, _, err := a.Login(ctx, "jane@example.com", testPassword, "192.0.2.1", ""); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, _, err := a.Login(ctx, "jane@example.com", "wrong password", "192.0.2.1", ""); err == nil {
+		t.Fatal("Login with a wrong password succeeded")
+	}
+
+	spans := named(rec.Ended(), "Auth.")
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	want := []string{"Auth.Register", "Auth.Login", "Auth.Login"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("spans = %v, want %v", names, want)
+	}
+	hash := named(rec.Ended(), "bcrypt.hash")
+	if len(hash) != 1 || hash[0].Parent().SpanID() != spans[0].SpanContext().SpanID() {
+		t.Error("bcrypt.hash is not a child of Auth.Register")
+	}
+	if got := spans[1].Status().Code; got != codes.Unset {
+		t.Errorf("successful login status = %v, want unset", got)
+	}
+	failed := spans[2]
+	if failed.Status().Code != codes.Error {
+		t.Errorf("failed login status = %v, want error", failed.Status().Code)
+	}
+	if len(failed.Events()) == 0 || failed.Events()[0].Name != "exception" {
+		t.Errorf("failed login events = %v, want the recorded error", failed.Events())
+	}
+}
+
+func TestSpansJoinTheCallersTrace(t *testing.T) {
+	a, rec := newTracedAuth(t)
+	ctx, parent := a.tracer.Start(context.Background(), "rpc")
+	register(t, a, "jane@example.com")
+	a.EmailVerified(ctx, "jane@example.com")
+	parent.End()
+
+	spans := named(rec.Ended(), "Auth.EmailVerified")
+	if len(spans) != 1 {
+		t.Fatalf("got %d Auth.EmailVerified spans, want 1", len(spans))
+	}
+	child := spans[0]
+	if child.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Auth.EmailVerified is not a child of the caller's span")
+	}
+	if child.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Error("Auth.EmailVerified started a new trace")
+	}
+}
+
+func TestLogLinesCarryTraceIDs(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	defer tp.Shutdown(context.Background())
+	core, logs := observer.New(zap.DebugLevel)
+	a, _ := newTestAuth(t, WithTracerProvider(tp))
+	a.log = zap.New(core)
+
+	register(t, a, "jane@example.com")
+
+	span := named(rec.Ended(), "Auth.Register")[0]
+	entries := logs.All()
+	if len(entries) == 0 {
+		t.Fatal("nothing was logged")
+	}
+	for _, entry := range entries {
+		fields := entry.ContextMap()
+		if fields["trace_id"] != span.SpanContext().TraceID().String() {
+			t.Errorf("%q has trace_id %v, want %s", entry.Message, fields["trace_id"], span.SpanContext().TraceID())
+		}
+	}
+}
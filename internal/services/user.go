@@ -1,116 +1,237 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/notifier"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func (a *Auth) EmailVerified(email string) (bool, error) {
-	verified, err := a.redis.GetEmailVerifiedCache(email)
+func (a *Auth) EmailVerified(ctx context.Context, email string) (verified bool, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.EmailVerified")
+	defer func() { endSpan(span, err) }()
+	verified, err = a.redis.GetEmailVerifiedCache(ctx, email)
 	if err != nil {
-		a.log.Error("error email verified check", zap.Error(err))
+		a.logger(ctx).Error("error email verified check", zap.Error(err))
 	}
 	return verified, err
 }
 
-func (a *Auth) CreateToken(email, remember string) (string, time.Duration) {
-	token, tokenTTL := a.createToken(email, remember)
-	a.log.Info("token ttl successfully taken", zap.String("token", token), zap.Duration("tokenTTL", tokenTTL))
-	return token, tokenTTL
+// CreateToken signs the user in without a password, for callers that
+// checked who they are some other way. It returns an AccountStatusError for
+// users who may not sign in and ErrPasswordResetRequired for users who have
+// to choose a new password first. A password that is only past its maximum
+// age doesn't stop it, that is left to the next password sign-in.
+func (a *Auth) CreateToken(ctx context.Context, email, remember string) (token string, tokenTTL time.Duration, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.CreateToken")
+	defer func() { endSpan(span, err) }()
+	user, err := a.db.User(ctx, email)
+	if err != nil {
+		a.logger(ctx).Info("failed to get user", zap.String("email", email), zap.Error(err))
+		return "", 0, err
+	}
+	if err := accountStatus(user); err != nil {
+		a.logger(ctx).Info("account is not active", zap.String("email", email), zap.Error(err))
+		return "", 0, err
+	}
+	if user.PasswordResetRequired {
+		a.logger(ctx).Info("password reset required", zap.String("email", email))
+		return "", 0, ErrPasswordResetRequired
+	}
+	token, tokenTTL = a.createToken(ctx, email, "", remember)
+	if token == "" {
+		return "", 0, fmt.Errorf("failed to generate token")
+	}
+	a.logger(ctx).Info("token ttl successfully taken", zap.String("session", storage.SessionID(token)), zap.Duration("tokenTTL", tokenTTL))
+	return token, tokenTTL, nil
 }
 
-func (a *Auth) GetUserEmail(token string) string {
+func (a *Auth) GetUserEmail(ctx context.Context, token string) string {
 	if token == "" {
 		return ""
 	}
-	email := a.redis.GetEmail(token)
-	a.log.Info("user email successfully taken", zap.String("token", token), zap.String("email", email))
+	ctx, span := a.startSpan(ctx, "Auth.GetUserEmail")
+	defer span.End()
+	session, err := a.session(ctx, token)
+	if err != nil {
+		if !errors.Is(err, storage.ErrSessionNotFound) {
+			endSpan(span, err)
+		}
+		return ""
+	}
+	email := session.Email
+	a.logger(ctx).Info("user email successfully taken", zap.String("session", storage.SessionID(token)), zap.String("email", email))
 	return email
 }
 
-func (a *Auth) EmailVerify(email string) error {
-	err := a.db.EmailVerify(email)
+func (a *Auth) EmailVerify(ctx context.Context, email string) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.EmailVerify")
+	defer func() { endSpan(span, err) }()
+	found := true
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		verified, err := tx.EmailVerified(ctx, email)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			found = false
+			return nil
+		}
+		if verified {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.EmailVerify(ctx, email); err != nil {
+			return err
+		}
+		return emit(ctx, tx, events.TypeEmailVerified, events.EmailVerified{Email: email})
+	})
 	if err != nil {
-		a.log.Error("error email verify", zap.Error(err), zap.String("email", email))
+		a.logger(ctx).Error("error email verify", zap.Error(err), zap.String("email", email))
+		a.audit(ctx, failed(models.EventEmailVerify, email, "", "internal_error"))
 		return err
 	}
-	err = a.redis.SetEmailVerifiedCache(email, true)
+	if !found {
+		// nothing to verify, and no cache entry saying otherwise
+		a.logger(ctx).Info("email verify for unknown user", zap.String("email", email))
+		return nil
+	}
+	a.audit(ctx, succeeded(models.EventEmailVerify, email, ""))
+	err = a.redis.SetEmailVerifiedCache(ctx, email, true)
 	if err != nil {
-		a.log.Error("error set email verified", zap.Error(err), zap.String("email", email))
+		a.logger(ctx).Error("error set email verified", zap.Error(err), zap.String("email", email))
 		return err
 	}
 	return nil
 }
 
-func (a *Auth) ChangePass(email, pass, ip, oldToken string) (string, time.Duration, error) {
-	log := a.log.With(
+func (a *Auth) ChangePass(ctx context.Context, email, pass, ip, oldToken string) (token string, tokenTTL time.Duration, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ChangePass")
+	defer func() { endSpan(span, err) }()
+	log := a.logger(ctx).With(
 		zap.String("email", email),
 		zap.String("ip", ip),
 	)
 	log.Info("password changing")
-	user, err := a.db.User(email)
+	user, err := a.db.User(ctx, email)
 	if err != nil {
 		log.Error("failed to get user", zap.Error(err))
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, "unknown_user"))
 		return "", 0, ErrInvalidCredentials
 	}
-	passHash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.MinCost)
+	previous, err := a.previousPasswords(ctx, user)
+	if err != nil {
+		log.Error("failed to get password history", zap.Error(err))
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, "internal_error"))
+		return "", 0, err
+	}
+	if err := a.checkPassword(ctx, email, pass, previous...); err != nil {
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, passwordFailure(err)))
+		return "", 0, err
+	}
+	passHash, err := a.hashPassword(ctx, pass)
 	if err != nil {
 		log.Error("failed to generate password hash", zap.Error(err))
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, "internal_error"))
 		return "", 0, err
 	}
-	user.PassHash = passHash
-	if err = a.updateUser(user, ip); err != nil {
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		return a.setPassword(ctx, tx, email, passHash, ip)
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Error("failed to get user", zap.Error(err))
+			a.audit(ctx, failed(models.EventPasswordChange, email, ip, "unknown_user"))
+			return "", 0, ErrInvalidCredentials
+		}
 		log.Error("failed to update user", zap.Error(err))
+		a.audit(ctx, failed(models.EventPasswordChange, email, ip, "internal_error"))
 		return "", 0, err
 	}
-	token, tokenTTL := a.createToken(email, "on")
+	a.audit(ctx, succeeded(models.EventPasswordChange, email, ip))
+	token, tokenTTL = a.createToken(ctx, email, ip, "on")
 	if token == "" {
 		log.Error("failed to generate token", zap.Error(err))
 		return "", 0, fmt.Errorf("failed to generate token")
 	}
-	err = a.redis.DeleteToken(oldToken)
-	if err != nil {
+	if err := a.sessions.Delete(ctx, oldToken); err != nil {
 		log.Error("error delete token", zap.Error(err))
 	}
 
 	return token, tokenTTL, nil
 }
 
-func (a *Auth) ChangeEmail(email, newEmail, oldToken string) (string, time.Duration, error) {
-	log := a.log.With(
+// ChangeEmail moves the user to newEmail and returns a new session. Every
+// session of the old address ends, oldToken among them.
+func (a *Auth) ChangeEmail(ctx context.Context, email, newEmail, oldToken string) (token string, tokenTTL time.Duration, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ChangeEmail")
+	defer func() { endSpan(span, err) }()
+	normalized, err := a.validateEmail(ctx, newEmail)
+	if err != nil {
+		a.audit(ctx, failed(models.EventEmailChange, email, "", "invalid_email"))
+		return "", 0, err
+	}
+	newEmail = normalized
+	log := a.logger(ctx).With(
 		zap.String("email", email),
 		zap.String("newEmail", newEmail),
 	)
 	log.Info("email changing")
-	user, err := a.db.User(email)
+	err = a.db.WithTx(ctx, func(tx database.Database) error {
+		_, err := a.modifyUser(ctx, tx, email, func(user *models.User) {
+			user.Email = newEmail
+		}, "email")
+		if err != nil {
+			return err
+		}
+		if err := emit(ctx, tx, events.TypeEmailChanged, events.EmailChanged{OldEmail: email, NewEmail: newEmail}); err != nil {
+			return err
+		}
+		// The old address is told, so a hijacked account doesn't go unnoticed.
+		if err := a.sendNotification(ctx, tx, email, notifier.TemplateEmailChanged, map[string]string{"NewEmail": newEmail}); err != nil {
+			return err
+		}
+		// Sessions are linked by address, and the old one is free to be
+		// registered again. They end last, so the change is kept only if
+		// they did.
+		return a.sessions.DeleteAll(ctx, email)
+	})
 	if err != nil {
-		log.Error("failed to get user", zap.Error(err))
-		return "", 0, ErrInvalidCredentials
-	}
-	user.Email = newEmail
-	if err = a.updateUser(user, ""); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUserNotFound):
+			log.Error("failed to get user", zap.Error(err))
+			a.audit(ctx, failed(models.EventEmailChange, email, "", "unknown_user"))
+			return "", 0, ErrInvalidCredentials
+		case errors.Is(err, storage.ErrUserExists):
+			a.audit(ctx, failed(models.EventEmailChange, email, "", "email_taken"))
+		default:
+			a.audit(ctx, failed(models.EventEmailChange, email, "", "internal_error"))
+		}
 		log.Error("failed to update user", zap.Error(err))
 		return "", 0, err
 	}
-	token, tokenTTL := a.createToken(newEmail, "on")
+	// Recorded under both addresses so it shows up whichever one is
+	// looked up.
+	event := succeeded(models.EventEmailChange, email, "")
+	event.Reason = "changed to " + newEmail
+	a.audit(ctx, event)
+	event = succeeded(models.EventEmailChange, newEmail, "")
+	event.Reason = "changed from " + email
+	a.audit(ctx, event)
+	token, tokenTTL = a.createToken(ctx, newEmail, "", "on")
 	if token == "" {
 		log.Error("failed to generate token", zap.Error(err))
 		return "", 0, fmt.Errorf("failed to generate token")
 	}
-	err = a.redis.DeleteToken(oldToken)
-	if err != nil {
-		log.Error("error delete token", zap.Error(err))
-	}
-
-	err = a.redis.Delete("emailVerified:" + email)
-	if err != nil {
+	if err := a.redis.DeleteEmailVerifiedCache(ctx, email); err != nil {
 		log.Error("error delete email verified", zap.Error(err))
 	}
 
 	return token, tokenTTL, nil
 }
-
-// func (a *Auth) Delete() {}
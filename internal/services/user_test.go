@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/mailaddr"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+func TestEmailVerify(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+
+	tests := []struct {
+		name  string
+		email string
+		want  bool
+	}{
+		{"registered user", "jane@example.com", true},
+		{"again", "jane@example.com", true},
+		{"unknown user", "ann@example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.EmailVerify(ctx, tt.email); err != nil {
+				t.Fatalf("EmailVerify: %v", err)
+			}
+			verified, _ := a.EmailVerified(ctx, tt.email)
+			if verified != tt.want {
+				t.Errorf("EmailVerified = %v, want %v", verified, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmailVerified(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	register(t, a, "ann@example.com")
+	if err := a.EmailVerify(ctx, "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		email   string
+		want    bool
+		wantErr error
+	}{
+		{"not verified", "jane@example.com", false, nil},
+		{"verified", "ann@example.com", true, nil},
+		{"unknown user", "bob@example.com", false, storage.ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verified, err := a.EmailVerified(ctx, tt.email)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EmailVerified = %v, want %v", err, tt.wantErr)
+			}
+			if verified != tt.want {
+				t.Errorf("EmailVerified = %v, want %v", verified, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateToken(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	register(t, a, "jane@example.com")
+	register(t, a, "ann@example.com")
+	register(t, a, "eve@example.com")
+	if err := a.DisableUser(ctx, "ann@example.com", "abuse", maxTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.RequirePasswordReset(ctx, "eve@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		remember string
+		wantErr  error
+		wantTTL  time.Duration
+	}{
+		{"signed in", "jane@example.com", "", nil, time.Hour},
+		{"remember me", "jane@example.com", "on", nil, 24 * time.Hour},
+		{"unknown user", "bob@example.com", "", storage.ErrUserNotFound, 0},
+		{"disabled user", "ann@example.com", "", ErrAccountInactive, 0},
+		{"password reset required", "eve@example.com", "", ErrPasswordResetRequired, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, ttl, err := a.CreateToken(ctx, tt.email, tt.remember)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateToken = %v, want %v", err, tt.wantErr)
+			}
+			if ttl != tt.wantTTL {
+				t.Errorf("ttl = %v, want %v", ttl, tt.wantTTL)
+			}
+			if got := a.GetUserEmail(ctx, token); tt.wantErr == nil && got != tt.email {
+				t.Errorf("session of %q, want %q", got, tt.email)
+			}
+		})
+	}
+}
+
+func TestGetUserEmail(t *testing.T) {
+	a, _ := newTestAuth(t, WithAccessRefresh(0))
+	ctx := context.Background()
+	token := register(t, a, "jane@example.com")
+	disabled := register(t, a, "ann@example.com")
+	if err := a.DisableUser(ctx, "ann@example.com", "abuse", maxTime); err != nil {
+		t.Fatal(err)
+	}
+	loggedOut := login(t, a, "jane@example.com", testPassword, "192.0.2.1")
+	if err := a.Logout(ctx, loggedOut); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"signed in", token, "jane@example.com"},
+		{"disabled user", disabled, ""},
+		{"logged out", loggedOut, ""},
+		{"unknown session", "not-a-session", ""},
+		{"no session", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.GetUserEmail(ctx, tt.token); got != tt.want {
+				t.Errorf("GetUserEmail = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangePass(t *testing.T) {
+	ctx := context.Background()
+	const newPassword = "a brand new horse battery 12"
+
+	tests := []struct {
+		name         string
+		email        string
+		wantErr      error
+		wantPassword string
+	}{
+		{"registered user", "jane@example.com", nil, newPassword},
+		{"unknown user", "bob@example.com", ErrInvalidCredentials, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAuth(t)
+			old := register(t, a, "jane@example.com")
+			token, _, err := a.ChangePass(ctx, tt.email, newPassword, "192.0.2.1", old)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangePass = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if got := a.GetUserEmail(ctx, old); got != "jane@example.com" {
+					t.Errorf("old session of %q after a failed change, want it kept", got)
+				}
+				return
+			}
+			if got := a.GetUserEmail(ctx, old); got != "" {
+				t.Errorf("old session of %q after the change", got)
+			}
+			if got := a.GetUserEmail(ctx, token); got != tt.email {
+				t.Errorf("new session of %q, want %q", got, tt.email)
+			}
+			if _, _, err := a.Login(ctx, tt.email, tt.wantPassword, "192.0.2.1", ""); err != nil {
+				t.Errorf("Login with the new password: %v", err)
+			}
+		})
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		email       string
+		newEmail    string
+		wantErr     error
+		wantInvalid bool
+		wantEmail   string
+	}{
+		{"changed", "jane@example.com", "janet@example.com", nil, false, "janet@example.com"},
+		{"normalized", "jane@example.com", " Janet@Example.COM", nil, false, "janet@example.com"},
+		{"taken", "jane@example.com", "ann@example.com", storage.ErrUserExists, false, ""},
+		{"unknown user", "bob@example.com", "bobby@example.com", ErrInvalidCredentials, false, ""},
+		{"not an email", "jane@example.com", "janet", nil, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAuth(t)
+			old := register(t, a, "jane@example.com")
+			other := login(t, a, "jane@example.com", testPassword, "192.0.2.1")
+			register(t, a, "ann@example.com")
+			token, _, err := a.ChangeEmail(ctx, tt.email, tt.newEmail, old)
+			var invalid *mailaddr.Error
+			if gotInvalid := errors.As(err, &invalid); gotInvalid != tt.wantInvalid {
+				t.Fatalf("ChangeEmail = %v, want an invalid email error %v", err, tt.wantInvalid)
+			}
+			if !tt.wantInvalid && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ChangeEmail = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				for _, session := range []string{old, other} {
+					if got := a.GetUserEmail(ctx, session); got != "jane@example.com" {
+						t.Errorf("session of %q after a failed change, want it kept", got)
+					}
+				}
+				return
+			}
+			for _, session := range []string{old, other} {
+				if got := a.GetUserEmail(ctx, session); got != "" {
+					t.Errorf("session of %q after the change", got)
+				}
+			}
+			if got := a.GetUserEmail(ctx, token); got != tt.wantEmail {
+				t.Errorf("new session of %q, want %q", got, tt.wantEmail)
+			}
+			if _, _, err := a.Login(ctx, tt.wantEmail, testPassword, "192.0.2.1", ""); err != nil {
+				t.Errorf("Login with the new email: %v", err)
+			}
+		})
+	}
+}
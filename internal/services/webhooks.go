@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"github.com/GosMachine/ServiceAuth/internal/webhooks"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// WithInsecureWebhooks lets subscriptions use plain http URLs.
+func WithInsecureWebhooks(allow bool) Option {
+	return func(a *Auth) {
+		a.allowHTTPWebhooks = allow
+	}
+}
+
+// CreateWebhook subscribes rawURL to eventTypes, all events if empty. The
+// returned subscription carries the signing secret, which is not shown
+// again.
+func (a *Auth) CreateWebhook(ctx context.Context, rawURL string, eventTypes []string) (sub models.WebhookSubscription, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.CreateWebhook")
+	defer func() { endSpan(span, err) }()
+	if err := a.validateWebhook(rawURL, eventTypes); err != nil {
+		return sub, err
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return sub, err
+	}
+	sub = models.WebhookSubscription{URL: rawURL, Secret: secret, EventTypes: eventTypes, Active: true}
+	if err := a.db.CreateWebhook(ctx, &sub); err != nil {
+		a.logger(ctx).Error("failed to create webhook", zap.Error(err))
+		return sub, err
+	}
+	a.auditAdmin(ctx, "", "webhook "+strconv.FormatInt(sub.ID, 10)+" created")
+	return sub, nil
+}
+
+func (a *Auth) ListWebhooks(ctx context.Context) (subs []models.WebhookSubscription, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ListWebhooks")
+	defer func() { endSpan(span, err) }()
+	return a.db.Webhooks(ctx)
+}
+
+// UpdateWebhook replaces the URL, event types and active flag of a
+// subscription. Switching it back on clears its failure count. With
+// rotateSecret a new secret is generated and returned.
+func (a *Auth) UpdateWebhook(ctx context.Context, id int64, rawURL string, eventTypes []string, active, rotateSecret bool) (sub models.WebhookSubscription, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.UpdateWebhook")
+	defer func() { endSpan(span, err) }()
+	if err := a.validateWebhook(rawURL, eventTypes); err != nil {
+		return sub, err
+	}
+	sub, err = a.db.Webhook(ctx, id)
+	if err != nil {
+		return sub, err
+	}
+	columns := []string{"url", "event_types", "active"}
+	if active && !sub.Active {
+		sub.ConsecutiveFailures = 0
+		sub.DisabledReason = ""
+		columns = append(columns, "consecutive_failures", "disabled_reason")
+	}
+	sub.URL, sub.EventTypes, sub.Active = rawURL, eventTypes, active
+	if rotateSecret {
+		if sub.Secret, err = webhooks.NewSecret(); err != nil {
+			return sub, err
+		}
+		columns = append(columns, "secret")
+	}
+	if err := a.db.UpdateWebhook(ctx, sub, columns...); err != nil {
+		a.logger(ctx).Error("failed to update webhook", zap.Error(err))
+		return sub, err
+	}
+	a.auditAdmin(ctx, "", "webhook "+strconv.FormatInt(id, 10)+" updated")
+	return sub, nil
+}
+
+func (a *Auth) DeleteWebhook(ctx context.Context, id int64) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.DeleteWebhook")
+	defer func() { endSpan(span, err) }()
+	if err := a.db.DeleteWebhook(ctx, id); err != nil {
+		return err
+	}
+	a.auditAdmin(ctx, "", "webhook "+strconv.FormatInt(id, 10)+" deleted")
+	return nil
+}
+
+// ListWebhookDeliveries pages through deliveries newest first, filter.Status
+// "dead" gives the dead-letter list.
+func (a *Auth) ListWebhookDeliveries(ctx context.Context, filter database.WebhookDeliveryFilter, pageToken string) (deliveries []models.WebhookDelivery, nextPageToken string, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.ListWebhookDeliveries")
+	defer func() { endSpan(span, err) }()
+	if pageToken != "" {
+		if filter.BeforeID, err = decodePageToken(pageToken); err != nil {
+			return nil, "", err
+		}
+	}
+	pageSize := pageLimit(filter.Limit)
+	filter.Limit = pageSize + 1
+	deliveries, err = a.db.WebhookDeliveries(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(deliveries) > pageSize {
+		deliveries = deliveries[:pageSize]
+		nextPageToken = encodePageToken(deliveries[pageSize-1].ID)
+	}
+	return deliveries, nextPageToken, nil
+}
+
+// WebhookDelivery returns a delivery with the log of its attempts.
+func (a *Auth) WebhookDelivery(ctx context.Context, id int64) (delivery models.WebhookDelivery, attempts []models.WebhookAttempt, err error) {
+	ctx, span := a.startSpan(ctx, "Auth.WebhookDelivery")
+	defer func() { endSpan(span, err) }()
+	delivery, err = a.db.WebhookDelivery(ctx, id)
+	if err != nil {
+		return delivery, nil, err
+	}
+	attempts, err = a.db.WebhookAttempts(ctx, id)
+	return delivery, attempts, err
+}
+
+// RedeliverWebhook queues a delivery again right away, typically one from
+// the dead-letter list once the receiver is fixed.
+func (a *Auth) RedeliverWebhook(ctx context.Context, id int64) (err error) {
+	ctx, span := a.startSpan(ctx, "Auth.RedeliverWebhook")
+	defer func() { endSpan(span, err) }()
+	delivery, err := a.db.WebhookDelivery(ctx, id)
+	if err != nil {
+		return err
+	}
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	delivery.DeliveredAt = nil
+	if err := a.db.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		return err
+	}
+	a.auditAdmin(ctx, "", "webhook delivery "+strconv.FormatInt(id, 10)+" requeued")
+	return nil
+}
+
+func (a *Auth) validateWebhook(rawURL string, eventTypes []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: url must be absolute", ErrInvalidWebhook)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && a.allowHTTPWebhooks) {
+		return fmt.Errorf("%w: url must use https", ErrInvalidWebhook)
+	}
+	for _, t := range eventTypes {
+		if !events.KnownType(t) {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, t)
+		}
+	}
+	return nil
+}
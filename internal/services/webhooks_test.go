@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+func TestValidateWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		types    []string
+		insecure bool
+		wantErr  bool
+	}{
+		{"https", "https://partner.example.com/hook", []string{events.TypeUserRegistered}, false, false},
+		{"all event types", "https://partner.example.com/hook", nil, false, false},
+		{"http", "http://partner.example.com/hook", nil, false, true},
+		{"http allowed", "http://localhost:9000/hook", nil, true, false},
+		{"relative", "/hook", nil, false, true},
+		{"not a url", "://", nil, false, true},
+		{"unknown event type", "https://partner.example.com/hook", []string{"user.renamed"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAuth(t, WithInsecureWebhooks(tt.insecure))
+			err := a.validateWebhook(tt.url, tt.types)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("validateWebhook = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidWebhook) {
+				t.Errorf("error %v is not ErrInvalidWebhook", err)
+			}
+		})
+	}
+}
+
+func TestWebhookLifecycle(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+	sub, err := a.CreateWebhook(ctx, "https://partner.example.com/hook", []string{events.TypeUserRegistered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Secret == "" || !sub.Active {
+		t.Fatalf("created %+v, want an active webhook with a secret", sub)
+	}
+
+	updated, err := a.UpdateWebhook(ctx, sub.ID, "https://partner.example.com/v2", nil, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Secret == sub.Secret || updated.Active || updated.URL != "https://partner.example.com/v2" {
+		t.Errorf("updated %+v, want a new secret and url, inactive", updated)
+	}
+	if _, err := a.UpdateWebhook(ctx, sub.ID+1, "https://partner.example.com/v2", nil, true, false); !errors.Is(err, storage.ErrWebhookNotFound) {
+		t.Errorf("UpdateWebhook of an unknown id = %v, want ErrWebhookNotFound", err)
+	}
+
+	if err := a.DeleteWebhook(ctx, sub.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.DeleteWebhook(ctx, sub.ID); !errors.Is(err, storage.ErrWebhookNotFound) {
+		t.Errorf("second DeleteWebhook = %v, want ErrWebhookNotFound", err)
+	}
+	subs, err := a.ListWebhooks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 0 {
+		t.Errorf("ListWebhooks after the delete = %+v", subs)
+	}
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+)
+
+// AuthEventStore keeps the audit log.
+type AuthEventStore interface {
+	RecordAuthEvent(ctx context.Context, event *models.AuthEvent) error
+	AuthEvents(ctx context.Context, filter AuthEventFilter) ([]models.AuthEvent, error)
+	PruneAuthEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AuthEventFilter selects audit events. Zero fields match everything;
+// BeforeID continues a listing after the last event of the previous page.
+type AuthEventFilter struct {
+	Subject  string
+	Type     string
+	Since    time.Time
+	Until    time.Time
+	BeforeID int64
+	Limit    int
+}
+
+func (d *database) RecordAuthEvent(ctx context.Context, event *models.AuthEvent) error {
+	return d.db.WithContext(ctx).Create(event).Error
+}
+
+// AuthEvents returns the matching events, newest first.
+func (d *database) AuthEvents(ctx context.Context, filter AuthEventFilter) ([]models.AuthEvent, error) {
+	query := d.read.WithContext(ctx).Order("id DESC")
+	if filter.Subject != "" {
+		query = query.Where("subject = ?", filter.Subject)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.BeforeID > 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var events []models.AuthEvent
+	if err := query.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// PruneAuthEvents deletes events created before before and returns how many
+// went.
+func (d *database) PruneAuthEvents(ctx context.Context, before time.Time) (int64, error) {
+	res := d.db.WithContext(ctx).Where("created_at < ?", before).Delete(&models.AuthEvent{})
+	return res.RowsAffected, res.Error
+}
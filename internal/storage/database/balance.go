@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BalanceStore keeps the balance ledger and the cached balances.
+type BalanceStore interface {
+	AddBalanceTransaction(ctx context.Context, tx *models.BalanceTransaction) error
+	BalanceTransactions(ctx context.Context, caller, idempotencyKey string) ([]models.BalanceTransaction, error)
+	UserBalanceTransactions(ctx context.Context, userID int, beforeID int64, limit int) ([]models.BalanceTransaction, error)
+	Balance(ctx context.Context, userID int, currency string) (models.Balance, error)
+	Balances(ctx context.Context, userID int) ([]models.Balance, error)
+	SaveBalance(ctx context.Context, balance models.Balance) error
+	BalanceMismatches(ctx context.Context) ([]BalanceMismatch, error)
+	LegacyBalances(ctx context.Context, afterID, limit int) ([]models.User, error)
+}
+
+// BalanceMismatch is a cached balance that differs from the sum of its
+// ledger. A side that has no row counts as zero.
+type BalanceMismatch struct {
+	UserID   int
+	Currency string
+	Cached   int64
+	Ledger   int64
+}
+
+// AddBalanceTransaction appends to the ledger. It returns
+// storage.ErrDuplicateTransaction when the user already has a transaction
+// with the caller and idempotency key.
+func (d *database) AddBalanceTransaction(ctx context.Context, tx *models.BalanceTransaction) error {
+	err := d.db.WithContext(ctx).Create(tx).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return storage.ErrDuplicateTransaction
+	}
+	return err
+}
+
+// BalanceTransactions returns the transactions caller made with the
+// idempotency key, two for a transfer.
+func (d *database) BalanceTransactions(ctx context.Context, caller, idempotencyKey string) ([]models.BalanceTransaction, error) {
+	var txs []models.BalanceTransaction
+	err := d.db.WithContext(ctx).
+		Where("caller = ? AND idempotency_key = ?", caller, idempotencyKey).
+		Order("id").
+		Find(&txs).Error
+	return txs, err
+}
+
+// UserBalanceTransactions returns the user's ledger, newest first. A
+// positive beforeID starts below it and a positive limit caps the count.
+func (d *database) UserBalanceTransactions(ctx context.Context, userID int, beforeID int64, limit int) ([]models.BalanceTransaction, error) {
+	query := d.read.WithContext(ctx).Where("user_id = ?", userID).Order("id DESC")
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var txs []models.BalanceTransaction
+	err := query.Find(&txs).Error
+	return txs, err
+}
+
+// Balance returns the user's cached balance in currency and locks it until
+// the transaction ends. A user without one has a zero balance.
+func (d *database) Balance(ctx context.Context, userID int, currency string) (models.Balance, error) {
+	var balance models.Balance
+	err := d.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND currency = ?", userID, currency).
+		First(&balance).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Balance{UserID: userID, Currency: currency}, nil
+	}
+	return balance, err
+}
+
+// Balances returns the user's cached balances, one per currency.
+func (d *database) Balances(ctx context.Context, userID int) ([]models.Balance, error) {
+	var balances []models.Balance
+	err := d.db.WithContext(ctx).Where("user_id = ?", userID).Order("currency").Find(&balances).Error
+	return balances, err
+}
+
+func (d *database) SaveBalance(ctx context.Context, balance models.Balance) error {
+	balance.UpdatedAt = time.Now()
+	return d.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "currency"}},
+			DoUpdates: clause.AssignmentColumns([]string{"amount", "updated_at"}),
+		}).
+		Create(&balance).Error
+}
+
+// BalanceMismatches compares every cached balance with the sum of its
+// ledger.
+func (d *database) BalanceMismatches(ctx context.Context) ([]BalanceMismatch, error) {
+	var mismatches []BalanceMismatch
+	err := d.db.WithContext(ctx).Raw(`
+		SELECT COALESCE(b.user_id, l.user_id) AS user_id,
+			COALESCE(b.currency, l.currency) AS currency,
+			COALESCE(b.amount, 0) AS cached,
+			COALESCE(l.total, 0) AS ledger
+		FROM balances b
+		FULL OUTER JOIN (
+			SELECT user_id, currency, SUM(amount)::bigint AS total
+			FROM balance_transactions
+			GROUP BY user_id, currency
+		) l ON l.user_id = b.user_id AND l.currency = b.currency
+		WHERE COALESCE(b.amount, 0) <> COALESCE(l.total, 0)
+		ORDER BY 1, 2`).
+		Scan(&mismatches).Error
+	return mismatches, err
+}
+
+// LegacyBalances returns the users, deleted ones included, with a non-zero
+// users.balance and an id above afterID, by id.
+func (d *database) LegacyBalances(ctx context.Context, afterID, limit int) ([]models.User, error) {
+	var users []models.User
+	err := d.db.WithContext(ctx).Unscoped().
+		Where("balance <> 0 AND id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&users).Error
+	return users, err
+}
@@ -1,35 +1,222 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
 	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"os"
 )
 
+const maxConnectBackoff = 30 * time.Second
+
 type database struct {
 	db *gorm.DB
+	// read points to the replica when one is configured. Only lookups that
+	// tolerate replication lag go there, everything else uses db.
+	read *gorm.DB
 }
 
+// Database is every store on one connection, plus transactions that span
+// them. Code that needs a single area can take its store instead.
 type Database interface {
-	CreateUser(email, ip string, passHash []byte, emailVerified bool) error
-	User(email string) (models.User, error)
-	EmailVerified(email string) (bool, error)
-	EmailVerify(email string) error
-	UpdateUser(user models.User) error
-	DeleteUser(email string) error
+	UserStore
+	AuthEventStore
+	OutboxStore
+	WebhookStore
+	NotificationStore
+	DeviceStore
+	PasswordStore
+	RoleStore
+	DeletionStore
+	BalanceStore
+	// WithTx runs fn in a single transaction, the Database passed to fn
+	// is bound to it. Returning an error from fn rolls everything back.
+	WithTx(ctx context.Context, fn func(tx Database) error) error
+	// WithSerializableTx is WithTx at the serializable isolation level. It
+	// returns storage.ErrSerializationFailure when the transaction has to
+	// be retried.
+	WithSerializableTx(ctx context.Context, fn func(tx Database) error) error
+}
+
+// Plugin builds a GORM plugin for the connection called name, which is
+// "primary" or "replica".
+type Plugin func(name string) gorm.Plugin
+
+func New(log *zap.Logger, cfg config.DatabaseConfig, plugins ...Plugin) (Database, error) {
+	dsn, err := primaryDSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db, err := open(log, "primary", dsn, cfg, plugins)
+	if err != nil {
+		return nil, err
+	}
+	read := db
+	if cfg.ReplicaDSN != "" {
+		read, err = open(log, "replica", cfg.ReplicaDSN, cfg, plugins)
+		if err != nil {
+			closeDB(db)
+			return nil, err
+		}
+	}
+	err = db.AutoMigrate(&models.User{}, &models.Session{}, &models.AuthEvent{}, &models.OutboxEvent{},
+		&models.WebhookSubscription{}, &models.WebhookDelivery{}, &models.WebhookAttempt{},
+		&models.Notification{}, &models.KnownDevice{}, &models.LoginAlert{},
+		&models.PasswordHistory{}, &models.PasswordResetToken{},
+		&models.Role{}, &models.Permission{}, &models.UserRole{}, &models.AccountDeletion{},
+		&models.BalanceTransaction{}, &models.Balance{})
+	if err == nil {
+		err = dropLegacyIndexes(db)
+	}
+	if err != nil {
+		closeDB(db)
+		if read != db {
+			closeDB(read)
+		}
+		return nil, err
+	}
+	return &database{db: db, read: read}, nil
+}
+
+func (d *database) WithTx(ctx context.Context, fn func(tx Database) error) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&database{db: tx, read: tx})
+	})
+}
+
+func (d *database) WithSerializableTx(ctx context.Context, fn func(tx Database) error) error {
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&database{db: tx, read: tx})
+	}, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01") {
+		return storage.ErrSerializationFailure
+	}
+	return err
+}
+
+// legacyIndexes are indexes AutoMigrate created before their columns changed,
+// it never drops an index by itself.
+var legacyIndexes = []struct {
+	model interface{}
+	name  string
+}{
+	// unique on the key and user alone, before keys were scoped per caller
+	{&models.BalanceTransaction{}, "idx_balance_transactions_key"},
 }
 
-func New() (Database, error) {
-	connection := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USERNAME"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_DATABASE"))
-	db, err := gorm.Open(postgres.Open(connection), &gorm.Config{})
+func dropLegacyIndexes(db *gorm.DB) error {
+	for _, idx := range legacyIndexes {
+		if db.Migrator().HasIndex(idx.model, idx.name) {
+			if err := db.Migrator().DropIndex(idx.model, idx.name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// open sets up the pool and waits for Postgres to accept connections,
+// backing off between attempts so the service survives a slow database start.
+func open(log *zap.Logger, name, dsn string, cfg config.DatabaseConfig, plugins []Plugin) (*gorm.DB, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
 	if err != nil {
+		return nil, fmt.Errorf("parse dsn: %w", err)
+	}
+	if cfg.StatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+	sqlDB := stdlib.OpenDB(*connConfig)
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if err = ping(log.With(zap.String("db", name)), sqlDB, cfg); err != nil {
+		sqlDB.Close()
 		return nil, err
 	}
-	err = db.AutoMigrate(&models.User{})
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
-	return &database{db: db}, nil
+	for _, plugin := range plugins {
+		if err := db.Use(plugin(name)); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// closeDB closes the pool under db, for giving up halfway through New.
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+func ping(log *zap.Logger, sqlDB *sql.DB, cfg config.DatabaseConfig) error {
+	backoff := cfg.ConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err := sqlDB.Ping()
+		if err == nil {
+			return nil
+		}
+		if attempt >= cfg.ConnectAttempts {
+			return fmt.Errorf("connect to postgres after %d attempts: %w", attempt, err)
+		}
+		log.Warn("postgres is not reachable, retrying",
+			zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+}
+
+func primaryDSN(cfg config.DatabaseConfig) (string, error) {
+	if cfg.DSNFile != "" {
+		return readSecret(cfg.DSNFile)
+	}
+	if cfg.DSN != "" {
+		return cfg.DSN, nil
+	}
+	password := cfg.Password
+	if cfg.PasswordFile != "" {
+		var err error
+		if password, err = readSecret(cfg.PasswordFile); err != nil {
+			return "", err
+		}
+	}
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		quote(cfg.Host), quote(cfg.Port), quote(cfg.User), quote(password), quote(cfg.Name), quote(cfg.SSLMode))
+	if cfg.SSLRootCert != "" {
+		dsn += " sslrootcert=" + quote(cfg.SSLRootCert)
+	}
+	return dsn, nil
+}
+
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// quote escapes a value for a keyword/value connection string.
+func quote(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
 }
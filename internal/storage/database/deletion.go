@@ -0,0 +1,131 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeletionStore keeps scheduled account deletions and removes user data.
+type DeletionStore interface {
+	AddAccountDeletion(ctx context.Context, deletion *models.AccountDeletion) error
+	AccountDeletion(ctx context.Context, tokenHash string) (models.AccountDeletion, error)
+	DueAccountDeletions(ctx context.Context, now time.Time, limit int) ([]models.AccountDeletion, error)
+	DeleteAccountDeletion(ctx context.Context, id int64) error
+	DeleteUserData(ctx context.Context, userID int) error
+	ScrubUserData(ctx context.Context, email, replacement string) error
+	PurgeUser(ctx context.Context, userID int) error
+}
+
+func (d *database) AddAccountDeletion(ctx context.Context, deletion *models.AccountDeletion) error {
+	return d.db.WithContext(ctx).Create(deletion).Error
+}
+
+// AccountDeletion returns the deletion with the given token hash and locks
+// it until the transaction ends, so it is either cancelled or purged.
+func (d *database) AccountDeletion(ctx context.Context, tokenHash string) (models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	err := d.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", tokenHash).
+		First(&deletion).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.AccountDeletion{}, storage.ErrDeletionNotFound
+	}
+	return deletion, err
+}
+
+// DueAccountDeletions returns deletions whose grace period ended by now,
+// oldest first.
+func (d *database) DueAccountDeletions(ctx context.Context, now time.Time, limit int) ([]models.AccountDeletion, error) {
+	var deletions []models.AccountDeletion
+	err := d.db.WithContext(ctx).
+		Where("purge_at <= ?", now).
+		Order("purge_at").
+		Limit(limit).
+		Find(&deletions).Error
+	return deletions, err
+}
+
+func (d *database) DeleteAccountDeletion(ctx context.Context, id int64) error {
+	return d.db.WithContext(ctx).Delete(&models.AccountDeletion{}, id).Error
+}
+
+// DeleteUserData deletes everything kept about the user apart from the user
+// row itself: devices, sign-in alerts, password history and reset tokens,
+// roles and a pending deletion. The balance ledger stays, it is a financial
+// record.
+func (d *database) DeleteUserData(ctx context.Context, userID int) error {
+	db := d.db.WithContext(ctx)
+	for _, model := range []any{
+		&models.KnownDevice{}, &models.LoginAlert{}, &models.PasswordHistory{},
+		&models.PasswordResetToken{}, &models.UserRole{}, &models.AccountDeletion{},
+	} {
+		if err := db.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScrubUserData replaces email with replacement where it outlives the user:
+// in the audit log, which also loses the addresses, locations and user
+// agents recorded for them, and in the payloads of events and webhook
+// deliveries. Their notifications are deleted.
+func (d *database) ScrubUserData(ctx context.Context, email, replacement string) error {
+	db := d.db.WithContext(ctx)
+	err := db.Model(&models.AuthEvent{}).Where("subject = ?", email).Updates(map[string]any{
+		"subject": replacement, "ip": "", "country": "", "city": "", "asn": 0, "user_agent": "",
+	}).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(&models.AuthEvent{}).Where("actor = ?", email).Update("actor", replacement).Error
+	if err != nil {
+		return err
+	}
+	if err := db.Where(`"to" = ?`, email).Delete(&models.Notification{}).Error; err != nil {
+		return err
+	}
+	from, to := jsonString(email), jsonString(replacement)
+	for _, model := range []any{&models.OutboxEvent{}, &models.WebhookDelivery{}} {
+		err := db.Model(model).
+			Where("strpos(payload::text, ?) > 0", from).
+			Update("payload", gorm.Expr("replace(payload::text, ?, ?)::jsonb", from, to)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonString quotes s the way Postgres prints a jsonb string, so whole
+// values are matched and not a part of a longer one.
+func jsonString(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// PurgeUser removes the user row for good, unlike DeleteUser which only
+// marks it deleted and keeps the email taken.
+func (d *database) PurgeUser(ctx context.Context, userID int) error {
+	res := d.db.WithContext(ctx).Unscoped().Delete(&models.User{}, userID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return storage.ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeviceStore keeps the known devices of users and the alerts about new ones.
+type DeviceStore interface {
+	KnownDevices(ctx context.Context, userID int) ([]models.KnownDevice, error)
+	SaveKnownDevice(ctx context.Context, device *models.KnownDevice) error
+	DeleteKnownDevice(ctx context.Context, id int64) error
+	AddLoginAlert(ctx context.Context, alert *models.LoginAlert) error
+	LoginAlert(ctx context.Context, tokenHash string) (models.LoginAlert, error)
+	UpdateLoginAlert(ctx context.Context, alert models.LoginAlert) error
+	PruneLoginAlerts(ctx context.Context, before time.Time) (int64, error)
+}
+
+func (d *database) KnownDevices(ctx context.Context, userID int) ([]models.KnownDevice, error) {
+	var devices []models.KnownDevice
+	err := d.db.WithContext(ctx).Where("user_id = ?", userID).Order("last_seen_at DESC").Find(&devices).Error
+	return devices, err
+}
+
+// SaveKnownDevice inserts device when it has no ID yet and otherwise updates
+// where and when it was last seen.
+func (d *database) SaveKnownDevice(ctx context.Context, device *models.KnownDevice) error {
+	if device.ID == 0 {
+		return d.db.WithContext(ctx).Create(device).Error
+	}
+	return d.db.WithContext(ctx).Model(device).
+		Select("ip_prefix", "user_agent", "country", "asn", "last_seen_at").
+		Updates(device).Error
+}
+
+func (d *database) DeleteKnownDevice(ctx context.Context, id int64) error {
+	return d.db.WithContext(ctx).Delete(&models.KnownDevice{}, id).Error
+}
+
+func (d *database) AddLoginAlert(ctx context.Context, alert *models.LoginAlert) error {
+	return d.db.WithContext(ctx).Create(alert).Error
+}
+
+// LoginAlert returns the alert with the given token hash and locks it until
+// the transaction ends, so a link can only be used once.
+func (d *database) LoginAlert(ctx context.Context, tokenHash string) (models.LoginAlert, error) {
+	var alert models.LoginAlert
+	err := d.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", tokenHash).
+		First(&alert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.LoginAlert{}, storage.ErrLoginAlertNotFound
+	}
+	return alert, err
+}
+
+func (d *database) UpdateLoginAlert(ctx context.Context, alert models.LoginAlert) error {
+	return d.db.WithContext(ctx).Model(&alert).Select("used_at").Updates(&alert).Error
+}
+
+// PruneLoginAlerts deletes alerts that expired before before.
+func (d *database) PruneLoginAlerts(ctx context.Context, before time.Time) (int64, error) {
+	res := d.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&models.LoginAlert{})
+	return res.RowsAffected, res.Error
+}
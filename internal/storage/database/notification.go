@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"gorm.io/gorm/clause"
+)
+
+// NotificationStore keeps the queued emails.
+type NotificationStore interface {
+	AddNotification(ctx context.Context, n *models.Notification) error
+	DueNotifications(ctx context.Context, now time.Time, limit int) ([]models.Notification, error)
+	UpdateNotification(ctx context.Context, n models.Notification) error
+	PruneNotifications(ctx context.Context, before time.Time) (int64, error)
+}
+
+func (d *database) AddNotification(ctx context.Context, n *models.Notification) error {
+	return d.db.WithContext(ctx).Create(n).Error
+}
+
+// DueNotifications returns pending notifications whose next attempt is due,
+// oldest first, locked like DueOutboxEvents. Call it inside WithTx.
+func (d *database) DueNotifications(ctx context.Context, now time.Time, limit int) ([]models.Notification, error) {
+	var notifications []models.Notification
+	err := d.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", models.NotificationPending, now).
+		Order("id").
+		Limit(limit).
+		Find(&notifications).Error
+	return notifications, err
+}
+
+func (d *database) UpdateNotification(ctx context.Context, n models.Notification) error {
+	return d.db.WithContext(ctx).Model(&n).
+		Select("status", "attempts", "next_attempt_at", "last_error", "sent_at", "data").
+		Updates(&n).Error
+}
+
+// PruneNotifications deletes notifications that were sent or gave up before
+// before.
+func (d *database) PruneNotifications(ctx context.Context, before time.Time) (int64, error) {
+	res := d.db.WithContext(ctx).
+		Where("status <> ? AND created_at < ?", models.NotificationPending, before).
+		Delete(&models.Notification{})
+	return res.RowsAffected, res.Error
+}
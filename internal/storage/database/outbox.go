@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxStore keeps the events waiting to be published.
+type OutboxStore interface {
+	AddOutboxEvent(ctx context.Context, event *models.OutboxEvent) error
+	DueOutboxEvents(ctx context.Context, now time.Time, limit int) ([]models.OutboxEvent, error)
+	UpdateOutboxEvent(ctx context.Context, event models.OutboxEvent) error
+	PruneOutbox(ctx context.Context, before time.Time) (int64, error)
+}
+
+func (d *database) AddOutboxEvent(ctx context.Context, event *models.OutboxEvent) error {
+	return d.db.WithContext(ctx).Create(event).Error
+}
+
+// DueOutboxEvents returns unpublished events whose next attempt is due,
+// oldest first. The rows stay locked until the transaction ends and rows
+// locked by someone else are skipped, so call it inside WithTx.
+func (d *database) DueOutboxEvents(ctx context.Context, now time.Time, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := d.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("published_at IS NULL AND next_attempt_at <= ?", now).
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func (d *database) UpdateOutboxEvent(ctx context.Context, event models.OutboxEvent) error {
+	return d.db.WithContext(ctx).Model(&event).
+		Select("attempts", "next_attempt_at", "last_error", "published_at").
+		Updates(&event).Error
+}
+
+// PruneOutbox deletes events published before before.
+func (d *database) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+	res := d.db.WithContext(ctx).Where("published_at < ?", before).Delete(&models.OutboxEvent{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PasswordStore keeps past password hashes and reset tokens.
+type PasswordStore interface {
+	AddPasswordHistory(ctx context.Context, entry *models.PasswordHistory) error
+	PasswordHistory(ctx context.Context, userID, limit int) ([]models.PasswordHistory, error)
+	TrimPasswordHistory(ctx context.Context, userID, keep int) error
+	AddPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error
+	PasswordResetToken(ctx context.Context, tokenHash string) (models.PasswordResetToken, error)
+	UpdatePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error
+	PrunePasswordResetTokens(ctx context.Context, before time.Time) (int64, error)
+}
+
+func (d *database) AddPasswordHistory(ctx context.Context, entry *models.PasswordHistory) error {
+	return d.db.WithContext(ctx).Create(entry).Error
+}
+
+// PasswordHistory returns up to limit of the user's previous passwords,
+// newest first.
+func (d *database) PasswordHistory(ctx context.Context, userID, limit int) ([]models.PasswordHistory, error) {
+	var entries []models.PasswordHistory
+	err := d.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&entries).Error
+	return entries, err
+}
+
+// TrimPasswordHistory deletes all but the newest keep entries of the user.
+func (d *database) TrimPasswordHistory(ctx context.Context, userID, keep int) error {
+	newest := d.db.Model(&models.PasswordHistory{}).
+		Select("id").
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(keep)
+	return d.db.WithContext(ctx).
+		Where("user_id = ? AND id NOT IN (?)", userID, newest).
+		Delete(&models.PasswordHistory{}).Error
+}
+
+func (d *database) AddPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	return d.db.WithContext(ctx).Create(token).Error
+}
+
+// PasswordResetToken returns the token with the given hash and locks it
+// until the transaction ends, so it can only be used once.
+func (d *database) PasswordResetToken(ctx context.Context, tokenHash string) (models.PasswordResetToken, error) {
+	var token models.PasswordResetToken
+	err := d.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", tokenHash).
+		First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.PasswordResetToken{}, storage.ErrResetTokenNotFound
+	}
+	return token, err
+}
+
+func (d *database) UpdatePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error {
+	return d.db.WithContext(ctx).Model(&token).Select("used_at").Updates(&token).Error
+}
+
+// PrunePasswordResetTokens deletes tokens that expired before before.
+func (d *database) PrunePasswordResetTokens(ctx context.Context, before time.Time) (int64, error) {
+	res := d.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&models.PasswordResetToken{})
+	return res.RowsAffected, res.Error
+}
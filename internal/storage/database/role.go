@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RoleStore keeps roles and who has them.
+type RoleStore interface {
+	SaveRole(ctx context.Context, role *models.Role) error
+	Role(ctx context.Context, name string) (models.Role, error)
+	AssignRole(ctx context.Context, userRole *models.UserRole) error
+	RevokeRole(ctx context.Context, userID int, roleID int64) error
+	UserRoles(ctx context.Context, email string) ([]models.Role, error)
+}
+
+// SaveRole creates the role or updates the one with the same name, and
+// replaces its permissions with role.Permissions, creating the ones that
+// don't exist yet. Only the permission names need to be set.
+func (d *database) SaveRole(ctx context.Context, role *models.Role) error {
+	db := d.db.WithContext(ctx)
+	permissions := role.Permissions
+	for i := range permissions {
+		err := db.Where(models.Permission{Name: permissions[i].Name}).FirstOrCreate(&permissions[i]).Error
+		if err != nil {
+			return err
+		}
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"description", "updated_at"}),
+	}).Omit("Permissions").Create(role).Error
+	if err != nil {
+		return err
+	}
+	if role.ID == 0 {
+		if err := db.Where("name = ?", role.Name).Select("id").First(role).Error; err != nil {
+			return err
+		}
+	}
+	return db.Model(role).Association("Permissions").Replace(permissions)
+}
+
+func (d *database) Role(ctx context.Context, name string) (models.Role, error) {
+	var role models.Role
+	err := d.db.WithContext(ctx).Preload("Permissions").Where("name = ?", name).First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Role{}, storage.ErrRoleNotFound
+	}
+	return role, err
+}
+
+// AssignRole does nothing if the user has the role already.
+func (d *database) AssignRole(ctx context.Context, userRole *models.UserRole) error {
+	return d.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(userRole).Error
+}
+
+func (d *database) RevokeRole(ctx context.Context, userID int, roleID int64) error {
+	return d.db.WithContext(ctx).Delete(&models.UserRole{}, "user_id = ? AND role_id = ?", userID, roleID).Error
+}
+
+// UserRoles returns the roles of the user with their permissions, ordered
+// by name.
+func (d *database) UserRoles(ctx context.Context, email string) ([]models.Role, error) {
+	var roles []models.Role
+	err := d.db.WithContext(ctx).
+		Preload("Permissions").
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Joins("JOIN users ON users.id = user_roles.user_id").
+		Where("users.email = ? AND users.deleted_at IS NULL", email).
+		Order("roles.name").
+		Find(&roles).Error
+	return roles, err
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var _ storage.SessionStore = (*Sessions)(nil)
+
+// Sessions is a Postgres backed storage.SessionStore. Expired rows are
+// ignored on read and removed by Reap.
+type Sessions struct {
+	db  *gorm.DB
+	log *zap.Logger
+}
+
+// NewSessions stores sessions in db, which must come from New.
+func NewSessions(db Database, log *zap.Logger) (*Sessions, error) {
+	d, ok := db.(*database)
+	if !ok {
+		return nil, fmt.Errorf("sessions need a postgres database, got %T", db)
+	}
+	return &Sessions{db: d.db, log: log}, nil
+}
+
+func (s *Sessions) Create(ctx context.Context, email string, ttl time.Duration, client storage.Client, access storage.Access) (string, error) {
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		token, err := storage.NewToken()
+		if err != nil {
+			return "", err
+		}
+		session := models.Session{
+			ID:              storage.SessionID(token),
+			Email:           email,
+			IP:              client.IP,
+			Country:         client.Country,
+			City:            client.City,
+			ASN:             client.ASN,
+			Roles:           access.Roles,
+			Permissions:     access.Permissions,
+			AccessCheckedAt: access.CheckedAt,
+			CreatedAt:       now,
+			ExpiresAt:       now.Add(ttl),
+		}
+		err = s.db.WithContext(ctx).Create(&session).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return token, nil
+	}
+	return "", errors.New("failed to generate unique token")
+}
+
+func (s *Sessions) Get(ctx context.Context, token string) (storage.Session, error) {
+	var session models.Session
+	err := s.db.WithContext(ctx).Where("id = ? AND expires_at > ?", storage.SessionID(token), time.Now()).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return storage.Session{}, storage.ErrSessionNotFound
+		}
+		return storage.Session{}, err
+	}
+	return toSession(session), nil
+}
+
+func (s *Sessions) SetAccess(ctx context.Context, token string, access storage.Access) error {
+	return s.db.WithContext(ctx).Model(&models.Session{ID: storage.SessionID(token)}).
+		Select("roles", "permissions", "access_checked_at").
+		Updates(&models.Session{Roles: access.Roles, Permissions: access.Permissions, AccessCheckedAt: access.CheckedAt}).Error
+}
+
+func (s *Sessions) Delete(ctx context.Context, token string) error {
+	return s.db.WithContext(ctx).Delete(&models.Session{}, "id = ?", storage.SessionID(token)).Error
+}
+
+func (s *Sessions) DeleteAll(ctx context.Context, email string) error {
+	return s.db.WithContext(ctx).Delete(&models.Session{}, "email = ?", email).Error
+}
+
+func (s *Sessions) List(ctx context.Context, email string) ([]storage.Session, error) {
+	var rows []models.Session
+	err := s.db.WithContext(ctx).Where("email = ? AND expires_at > ?", email, time.Now()).Order("created_at").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]storage.Session, len(rows))
+	for i, row := range rows {
+		sessions[i] = toSession(row)
+	}
+	return sessions, nil
+}
+
+// TTL follows the Redis convention: -2 if the session does not exist.
+func (s *Sessions) TTL(ctx context.Context, token string) time.Duration {
+	session, err := s.Get(ctx, token)
+	if err != nil {
+		return -2
+	}
+	return time.Until(session.ExpiresAt)
+}
+
+// Reap deletes expired sessions every interval until ctx is done.
+func (s *Sessions) Reap(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res := s.db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&models.Session{})
+			if res.Error != nil {
+				s.log.Error("failed to reap expired sessions", zap.Error(res.Error))
+				continue
+			}
+			if res.RowsAffected > 0 {
+				s.log.Info("expired sessions reaped", zap.Int64("count", res.RowsAffected))
+			}
+		}
+	}
+}
+
+func toSession(session models.Session) storage.Session {
+	return storage.Session{
+		ID:        session.ID,
+		Email:     session.Email,
+		CreatedAt: session.CreatedAt,
+		ExpiresAt: session.ExpiresAt,
+		Client: storage.Client{
+			IP:      session.IP,
+			Country: session.Country,
+			City:    session.City,
+			ASN:     session.ASN,
+		},
+		Access: storage.Access{
+			Roles:       session.Roles,
+			Permissions: session.Permissions,
+			CheckedAt:   session.AccessCheckedAt,
+		},
+	}
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/GosMachine/ServiceAuth/internal/models"
@@ -9,25 +11,97 @@ import (
 	"gorm.io/gorm"
 )
 
-func (d *database) CreateUser(email, ip string, passHash []byte, emailVerified bool) error {
-	user := models.User{Email: email, PassHash: passHash, IpCreated: ip, LastLoginIp: ip, LastLoginDate: time.Now(), EmailVerified: emailVerified}
-	if err := d.db.Create(&user).Error; err != nil {
+// UserStore keeps the accounts.
+type UserStore interface {
+	CreateUser(ctx context.Context, email, ip string, passHash []byte, emailVerified bool) error
+	Users(ctx context.Context, filter UserFilter) ([]models.User, error)
+	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, id int) (models.User, error)
+	EmailVerified(ctx context.Context, email string) (bool, error)
+	EmailVerify(ctx context.Context, email string) error
+	UpdateUser(ctx context.Context, user models.User, columns ...string) error
+	UpdateLastLogin(ctx context.Context, id int, ip string, date time.Time) error
+	DeleteUser(ctx context.Context, email string) error
+}
+
+func (d *database) CreateUser(ctx context.Context, email, ip string, passHash []byte, emailVerified bool) error {
+	now := time.Now()
+	user := models.User{Email: email, PassHash: passHash, IpCreated: ip, LastLoginIp: ip, LastLoginDate: now, PasswordChangedAt: now, EmailVerified: emailVerified, Status: models.UserActive}
+	err := d.db.WithContext(ctx).Create(&user).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
 		return storage.ErrUserExists
 	}
-	return nil
+	return err
+}
+
+// UserFilter selects users for listing. Zero fields match everything;
+// AfterID continues a listing after the last user of the previous page.
+type UserFilter struct {
+	EmailPrefix   string
+	Verified      *bool
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	AfterID       int
+	Limit         int
+}
+
+// Users returns the matching users ordered by id.
+func (d *database) Users(ctx context.Context, filter UserFilter) ([]models.User, error) {
+	query := d.read.WithContext(ctx).Order("id")
+	if filter.EmailPrefix != "" {
+		query = query.Where("email LIKE ?", likePrefix.Replace(filter.EmailPrefix)+"%")
+	}
+	if filter.Verified != nil {
+		query = query.Where("email_verified = ?", *filter.Verified)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+	if filter.AfterID > 0 {
+		query = query.Where("id > ?", filter.AfterID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// likePrefix escapes the LIKE wildcards, backslash is the default escape
+// character in Postgres.
+var likePrefix = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (d *database) User(ctx context.Context, email string) (models.User, error) {
+	var user models.User
+	if err := d.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.User{}, storage.ErrUserNotFound
+		}
+		return models.User{}, err
+	}
+	return user, nil
 }
 
-func (d *database) User(email string) (models.User, error) {
+func (d *database) UserByID(ctx context.Context, id int) (models.User, error) {
 	var user models.User
-	if err := d.db.Where("email = ?", email).First(&user).Error; err != nil {
-		return models.User{}, storage.ErrUserNotFound
+	if err := d.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.User{}, storage.ErrUserNotFound
+		}
+		return models.User{}, err
 	}
 	return user, nil
 }
 
-func (d *database) EmailVerified(email string) (bool, error) {
+func (d *database) EmailVerified(ctx context.Context, email string) (bool, error) {
 	var user models.User
-	if err := d.db.Where("email = ?", email).Select("email_verified").First(&user).Error; err != nil {
+	if err := d.read.WithContext(ctx).Where("email = ?", email).Select("email_verified").First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return false, storage.ErrUserNotFound
 		}
@@ -36,16 +110,46 @@ func (d *database) EmailVerified(email string) (bool, error) {
 	return user.EmailVerified, nil
 }
 
-func (d *database) EmailVerify(email string) error {
-	return d.db.Model(&models.User{}).Where("email = ?", email).Update("email_verified", true).Error
+func (d *database) EmailVerify(ctx context.Context, email string) error {
+	return d.db.WithContext(ctx).Model(&models.User{}).Where("email = ?", email).Update("email_verified", true).Error
 }
 
-func (d *database) UpdateUser(user models.User) error {
-	return d.db.Save(&user).Error
+// UpdateUser writes only the given columns of user. The row must still be at
+// user.Version, otherwise storage.ErrVersionConflict is returned and the caller
+// is expected to re-read the user and try again.
+func (d *database) UpdateUser(ctx context.Context, user models.User, columns ...string) error {
+	version := user.Version
+	user.Version++
+	res := d.db.WithContext(ctx).Model(&user).
+		Where("version = ?", version).
+		Select(append(columns, "version", "updated_at")).
+		Updates(&user)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrDuplicatedKey) {
+			return storage.ErrUserExists
+		}
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return storage.ErrVersionConflict
+	}
+	return nil
 }
 
-func (d *database) DeleteUser(email string) error {
-	if d.db.Where("email = ?", email).Delete(&models.User{}).Error != nil {
+func (d *database) UpdateLastLogin(ctx context.Context, id int, ip string, date time.Time) error {
+	values := map[string]interface{}{"last_login_date": date}
+	if ip != "" {
+		values["last_login_ip"] = ip
+	}
+	return d.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Updates(values).Error
+}
+
+func (d *database) DeleteUser(ctx context.Context, email string) error {
+	res := d.db.WithContext(ctx).Where("email = ?", email).Delete(&models.User{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
 		return storage.ErrUserNotFound
 	}
 	return nil
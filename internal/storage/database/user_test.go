@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"go.uber.org/zap"
+)
+
+// newTestDatabase connects to the Postgres in TEST_DB_DSN and skips the
+// test when it is unset. Tests share the database, so they use their own
+// email addresses and remove what they create.
+func newTestDatabase(t *testing.T) Database {
+	t.Helper()
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN is not set")
+	}
+	db, err := New(zap.NewNop(), config.DatabaseConfig{DSN: dsn, MaxOpenConns: 5, ConnectAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeDB(db.(*database).db) })
+	return db
+}
+
+func TestUpdateUserVersion(t *testing.T) {
+	db := newTestDatabase(t)
+	ctx := context.Background()
+	email := fmt.Sprintf("version-%d@example.com", time.Now().UnixNano())
+	if err := db.CreateUser(ctx, email, "192.0.2.1", []byte("hash"), false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DeleteUser(ctx, email) })
+
+	first, err := db.User(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := first
+
+	first.PassHash = []byte("first")
+	if err := db.UpdateUser(ctx, first, "pass_hash"); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	stale.PassHash = []byte("stale")
+	if err := db.UpdateUser(ctx, stale, "pass_hash"); !errors.Is(err, storage.ErrVersionConflict) {
+		t.Fatalf("UpdateUser of a stale read = %v, want ErrVersionConflict", err)
+	}
+
+	user, err := db.User(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(user.PassHash) != "first" || user.Version != first.Version+1 {
+		t.Errorf("stored pass hash %q at version %d, want %q at %d", user.PassHash, user.Version, "first", first.Version+1)
+	}
+	// a fresh read writes again
+	user.PassHash = []byte("second")
+	if err := db.UpdateUser(ctx, user, "pass_hash"); err != nil {
+		t.Errorf("UpdateUser after a fresh read: %v", err)
+	}
+}
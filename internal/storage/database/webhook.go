@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebhookStore keeps the webhook subscriptions and their deliveries.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, sub *models.WebhookSubscription) error
+	Webhook(ctx context.Context, id int64) (models.WebhookSubscription, error)
+	Webhooks(ctx context.Context) ([]models.WebhookSubscription, error)
+	UpdateWebhook(ctx context.Context, sub models.WebhookSubscription, columns ...string) error
+	DeleteWebhook(ctx context.Context, id int64) error
+	AddWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	WebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error)
+	WebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	AddWebhookAttempt(ctx context.Context, attempt *models.WebhookAttempt) error
+	WebhookAttempts(ctx context.Context, deliveryID int64) ([]models.WebhookAttempt, error)
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// WebhookDeliveryFilter selects deliveries, newest first. Zero fields match
+// everything; BeforeID continues after the last delivery of the previous
+// page.
+type WebhookDeliveryFilter struct {
+	SubscriptionID int64
+	Status         string
+	BeforeID       int64
+	Limit          int
+}
+
+func (d *database) CreateWebhook(ctx context.Context, sub *models.WebhookSubscription) error {
+	return d.db.WithContext(ctx).Create(sub).Error
+}
+
+func (d *database) Webhook(ctx context.Context, id int64) (models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+	if err := d.db.WithContext(ctx).First(&sub, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return sub, storage.ErrWebhookNotFound
+		}
+		return sub, err
+	}
+	return sub, nil
+}
+
+func (d *database) Webhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	var subs []models.WebhookSubscription
+	err := d.db.WithContext(ctx).Order("id").Find(&subs).Error
+	return subs, err
+}
+
+// UpdateWebhook writes the given columns of sub.
+func (d *database) UpdateWebhook(ctx context.Context, sub models.WebhookSubscription, columns ...string) error {
+	res := d.db.WithContext(ctx).Model(&sub).Select(append(columns, "updated_at")).Updates(&sub)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return storage.ErrWebhookNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook removes the subscription together with its deliveries and
+// their logs.
+func (d *database) DeleteWebhook(ctx context.Context, id int64) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		deliveries := tx.Model(&models.WebhookDelivery{}).Select("id").Where("subscription_id = ?", id)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&models.WebhookAttempt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("subscription_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&models.WebhookSubscription{}, id)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return storage.ErrWebhookNotFound
+		}
+		return nil
+	})
+}
+
+func (d *database) AddWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return d.db.WithContext(ctx).Create(&deliveries).Error
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is
+// due, oldest first, locked like DueOutboxEvents. Call it inside WithTx.
+func (d *database) DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := d.db.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+		Order("id").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (d *database) WebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := d.db.WithContext(ctx).First(&delivery, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return delivery, storage.ErrDeliveryNotFound
+		}
+		return delivery, err
+	}
+	return delivery, nil
+}
+
+func (d *database) WebhookDeliveries(ctx context.Context, filter WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	query := d.read.WithContext(ctx).Order("id DESC")
+	if filter.SubscriptionID > 0 {
+		query = query.Where("subscription_id = ?", filter.SubscriptionID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.BeforeID > 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var deliveries []models.WebhookDelivery
+	if err := query.Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (d *database) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	return d.db.WithContext(ctx).Model(&delivery).
+		Select("status", "attempts", "next_attempt_at", "last_status_code", "last_error", "delivered_at", "updated_at").
+		Updates(&delivery).Error
+}
+
+func (d *database) AddWebhookAttempt(ctx context.Context, attempt *models.WebhookAttempt) error {
+	return d.db.WithContext(ctx).Create(attempt).Error
+}
+
+// WebhookAttempts returns the log of a delivery, oldest first.
+func (d *database) WebhookAttempts(ctx context.Context, deliveryID int64) ([]models.WebhookAttempt, error) {
+	var attempts []models.WebhookAttempt
+	err := d.read.WithContext(ctx).Where("delivery_id = ?", deliveryID).Order("id").Find(&attempts).Error
+	return attempts, err
+}
+
+// PruneWebhookDeliveries deletes deliveries that succeeded before before,
+// and their logs.
+func (d *database) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	var pruned int64
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		old := tx.Model(&models.WebhookDelivery{}).Select("id").
+			Where("status = ? AND delivered_at < ?", models.DeliveryDelivered, before)
+		if err := tx.Where("delivery_id IN (?)", old).Delete(&models.WebhookAttempt{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("status = ? AND delivered_at < ?", models.DeliveryDelivered, before).Delete(&models.WebhookDelivery{})
+		pruned = res.RowsAffected
+		return res.Error
+	})
+	return pruned, err
+}
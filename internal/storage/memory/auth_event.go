@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+func (d *Database) RecordAuthEvent(ctx context.Context, event *models.AuthEvent) error {
+	defer d.lock()()
+	event.ID = d.s.nextEventID
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = d.now()
+	}
+	d.s.authEvents = append(d.s.authEvents, *event)
+	d.s.nextEventID++
+	return nil
+}
+
+func (d *Database) AuthEvents(ctx context.Context, filter database.AuthEventFilter) ([]models.AuthEvent, error) {
+	defer d.lock()()
+	var events []models.AuthEvent
+	for i := len(d.s.authEvents) - 1; i >= 0; i-- {
+		event := d.s.authEvents[i]
+		switch {
+		case filter.Subject != "" && event.Subject != filter.Subject,
+			filter.Type != "" && event.Type != filter.Type,
+			!filter.Since.IsZero() && event.CreatedAt.Before(filter.Since),
+			!filter.Until.IsZero() && !event.CreatedAt.Before(filter.Until),
+			filter.BeforeID > 0 && event.ID >= filter.BeforeID:
+			continue
+		}
+		events = append(events, event)
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (d *Database) PruneAuthEvents(ctx context.Context, before time.Time) (int64, error) {
+	defer d.lock()()
+	kept := d.s.authEvents[:0]
+	for _, event := range d.s.authEvents {
+		if !event.CreatedAt.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	pruned := int64(len(d.s.authEvents) - len(kept))
+	d.s.authEvents = kept
+	return pruned, nil
+}
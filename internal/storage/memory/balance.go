@@ -0,0 +1,137 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+type balanceKey struct {
+	userID   int
+	currency string
+}
+
+func (d *Database) AddBalanceTransaction(ctx context.Context, tx *models.BalanceTransaction) error {
+	defer d.lock()()
+	for _, existing := range d.s.balanceTxs {
+		if existing.Caller == tx.Caller && existing.IdempotencyKey == tx.IdempotencyKey && existing.UserID == tx.UserID {
+			return storage.ErrDuplicateTransaction
+		}
+	}
+	tx.ID = d.s.nextBalanceTxID
+	if tx.CreatedAt.IsZero() {
+		tx.CreatedAt = d.now()
+	}
+	d.s.balanceTxs = append(d.s.balanceTxs, *tx)
+	d.s.nextBalanceTxID++
+	return nil
+}
+
+func (d *Database) BalanceTransactions(ctx context.Context, caller, idempotencyKey string) ([]models.BalanceTransaction, error) {
+	defer d.lock()()
+	var txs []models.BalanceTransaction
+	for _, tx := range d.s.balanceTxs {
+		if tx.Caller == caller && tx.IdempotencyKey == idempotencyKey {
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
+
+func (d *Database) UserBalanceTransactions(ctx context.Context, userID int, beforeID int64, limit int) ([]models.BalanceTransaction, error) {
+	defer d.lock()()
+	var txs []models.BalanceTransaction
+	for i := len(d.s.balanceTxs) - 1; i >= 0; i-- {
+		tx := d.s.balanceTxs[i]
+		if tx.UserID != userID || (beforeID > 0 && tx.ID >= beforeID) {
+			continue
+		}
+		txs = append(txs, tx)
+		if limit > 0 && len(txs) == limit {
+			break
+		}
+	}
+	return txs, nil
+}
+
+func (d *Database) Balance(ctx context.Context, userID int, currency string) (models.Balance, error) {
+	defer d.lock()()
+	if balance, ok := d.s.balances[balanceKey{userID, currency}]; ok {
+		return balance, nil
+	}
+	return models.Balance{UserID: userID, Currency: currency}, nil
+}
+
+func (d *Database) Balances(ctx context.Context, userID int) ([]models.Balance, error) {
+	defer d.lock()()
+	var balances []models.Balance
+	for key, balance := range d.s.balances {
+		if key.userID == userID {
+			balances = append(balances, balance)
+		}
+	}
+	sort.Slice(balances, func(i, j int) bool { return balances[i].Currency < balances[j].Currency })
+	return balances, nil
+}
+
+func (d *Database) SaveBalance(ctx context.Context, balance models.Balance) error {
+	defer d.lock()()
+	balance.UpdatedAt = d.now()
+	d.s.balances[balanceKey{balance.UserID, balance.Currency}] = balance
+	return nil
+}
+
+func (d *Database) BalanceMismatches(ctx context.Context) ([]database.BalanceMismatch, error) {
+	defer d.lock()()
+	sums := map[balanceKey]*database.BalanceMismatch{}
+	get := func(key balanceKey) *database.BalanceMismatch {
+		m, ok := sums[key]
+		if !ok {
+			m = &database.BalanceMismatch{UserID: key.userID, Currency: key.currency}
+			sums[key] = m
+		}
+		return m
+	}
+	for key, balance := range d.s.balances {
+		get(key).Cached = balance.Amount
+	}
+	for _, tx := range d.s.balanceTxs {
+		get(balanceKey{tx.UserID, tx.Currency}).Ledger += tx.Amount
+	}
+	var mismatches []database.BalanceMismatch
+	for _, m := range sums {
+		if m.Cached != m.Ledger {
+			mismatches = append(mismatches, *m)
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		if mismatches[i].UserID != mismatches[j].UserID {
+			return mismatches[i].UserID < mismatches[j].UserID
+		}
+		return mismatches[i].Currency < mismatches[j].Currency
+	})
+	return mismatches, nil
+}
+
+func (d *Database) LegacyBalances(ctx context.Context, afterID, limit int) ([]models.User, error) {
+	defer d.lock()()
+	var users []models.User
+	for _, user := range d.s.users {
+		if user.Balance != 0 && user.ID > afterID {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// WithSerializableTx is WithTx, transactions never overlap here.
+func (d *Database) WithSerializableTx(ctx context.Context, fn func(tx database.Database) error) error {
+	return d.WithTx(ctx, fn)
+}
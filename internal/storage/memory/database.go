@@ -0,0 +1,370 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"gorm.io/gorm"
+)
+
+var _ database.Database = (*Database)(nil)
+
+// Database keeps users in process memory. It is meant for local development
+// and tests and mirrors the semantics of the Postgres implementation.
+type Database struct {
+	mu *sync.Mutex
+	// inTx is set on the handle passed to WithTx callbacks, the lock is
+	// already held by the enclosing WithTx then.
+	inTx bool
+	s    *state
+	now  func() time.Time
+}
+
+type state struct {
+	users        map[int]models.User
+	nextID       int
+	authEvents   []models.AuthEvent
+	nextEventID  int64
+	outbox       []models.OutboxEvent
+	nextOutboxID int64
+
+	webhooks       map[int64]models.WebhookSubscription
+	deliveries     []models.WebhookDelivery
+	attempts       []models.WebhookAttempt
+	nextWebhookID  int64
+	nextDeliveryID int64
+	nextAttemptID  int64
+
+	notifications      []models.Notification
+	nextNotificationID int64
+
+	devices      map[int64]models.KnownDevice
+	nextDeviceID int64
+	alerts       []models.LoginAlert
+	nextAlertID  int64
+
+	passwordHistory  []models.PasswordHistory
+	nextHistoryID    int64
+	resetTokens      []models.PasswordResetToken
+	nextResetTokenID int64
+
+	roles            map[int64]models.Role
+	nextRoleID       int64
+	permissions      map[string]int64
+	nextPermissionID int64
+	userRoles        []models.UserRole
+
+	deletions      []models.AccountDeletion
+	nextDeletionID int64
+
+	balanceTxs      []models.BalanceTransaction
+	nextBalanceTxID int64
+	balances        map[balanceKey]models.Balance
+}
+
+func (s *state) clone() *state {
+	c := &state{
+		users:        make(map[int]models.User, len(s.users)),
+		nextID:       s.nextID,
+		authEvents:   append([]models.AuthEvent(nil), s.authEvents...),
+		nextEventID:  s.nextEventID,
+		outbox:       append([]models.OutboxEvent(nil), s.outbox...),
+		nextOutboxID: s.nextOutboxID,
+
+		webhooks:       make(map[int64]models.WebhookSubscription, len(s.webhooks)),
+		deliveries:     append([]models.WebhookDelivery(nil), s.deliveries...),
+		attempts:       append([]models.WebhookAttempt(nil), s.attempts...),
+		nextWebhookID:  s.nextWebhookID,
+		nextDeliveryID: s.nextDeliveryID,
+		nextAttemptID:  s.nextAttemptID,
+
+		notifications:      append([]models.Notification(nil), s.notifications...),
+		nextNotificationID: s.nextNotificationID,
+
+		devices:      make(map[int64]models.KnownDevice, len(s.devices)),
+		nextDeviceID: s.nextDeviceID,
+		alerts:       append([]models.LoginAlert(nil), s.alerts...),
+		nextAlertID:  s.nextAlertID,
+
+		passwordHistory:  append([]models.PasswordHistory(nil), s.passwordHistory...),
+		nextHistoryID:    s.nextHistoryID,
+		resetTokens:      append([]models.PasswordResetToken(nil), s.resetTokens...),
+		nextResetTokenID: s.nextResetTokenID,
+
+		roles:            make(map[int64]models.Role, len(s.roles)),
+		nextRoleID:       s.nextRoleID,
+		permissions:      make(map[string]int64, len(s.permissions)),
+		nextPermissionID: s.nextPermissionID,
+		userRoles:        append([]models.UserRole(nil), s.userRoles...),
+
+		deletions:      append([]models.AccountDeletion(nil), s.deletions...),
+		nextDeletionID: s.nextDeletionID,
+
+		balanceTxs:      append([]models.BalanceTransaction(nil), s.balanceTxs...),
+		nextBalanceTxID: s.nextBalanceTxID,
+		balances:        make(map[balanceKey]models.Balance, len(s.balances)),
+	}
+	for id, user := range s.users {
+		c.users[id] = user
+	}
+	for id, sub := range s.webhooks {
+		c.webhooks[id] = sub
+	}
+	for id, device := range s.devices {
+		c.devices[id] = device
+	}
+	for id, role := range s.roles {
+		c.roles[id] = role
+	}
+	for name, id := range s.permissions {
+		c.permissions[name] = id
+	}
+	for key, balance := range s.balances {
+		c.balances[key] = balance
+	}
+	return c
+}
+
+// NewDatabase returns an empty Database. now may be nil, time.Now is used then.
+func NewDatabase(now func() time.Time) *Database {
+	if now == nil {
+		now = time.Now
+	}
+	return &Database{
+		mu: &sync.Mutex{},
+		s: &state{
+			users:          map[int]models.User{},
+			nextID:         1,
+			nextEventID:    1,
+			nextOutboxID:   1,
+			webhooks:       map[int64]models.WebhookSubscription{},
+			nextWebhookID:  1,
+			nextDeliveryID: 1,
+			nextAttemptID:  1,
+
+			nextNotificationID: 1,
+
+			devices:      map[int64]models.KnownDevice{},
+			nextDeviceID: 1,
+			nextAlertID:  1,
+
+			nextHistoryID:    1,
+			nextResetTokenID: 1,
+
+			roles:            map[int64]models.Role{},
+			nextRoleID:       1,
+			permissions:      map[string]int64{},
+			nextPermissionID: 1,
+
+			nextDeletionID: 1,
+
+			nextBalanceTxID: 1,
+			balances:        map[balanceKey]models.Balance{},
+		},
+		now: now,
+	}
+}
+
+func (d *Database) lock() func() {
+	if d.inTx {
+		return func() {}
+	}
+	d.mu.Lock()
+	return d.mu.Unlock
+}
+
+func (d *Database) find(email string) (models.User, bool) {
+	for _, user := range d.s.users {
+		if user.Email == email && !user.DeletedAt.Valid {
+			return user, true
+		}
+	}
+	return models.User{}, false
+}
+
+// taken reports whether email is reserved. Like the unique index in Postgres
+// it also counts soft-deleted users.
+func (d *Database) taken(email string, exceptID int) bool {
+	for _, user := range d.s.users {
+		if user.Email == email && user.ID != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Database) CreateUser(ctx context.Context, email, ip string, passHash []byte, emailVerified bool) error {
+	defer d.lock()()
+	if d.taken(email, 0) {
+		return storage.ErrUserExists
+	}
+	now := d.now()
+	user := models.User{
+		ID:                d.s.nextID,
+		Email:             email,
+		PassHash:          passHash,
+		IpCreated:         ip,
+		LastLoginIp:       ip,
+		LastLoginDate:     now,
+		PasswordChangedAt: now,
+		EmailVerified:     emailVerified,
+		Status:            models.UserActive,
+		Version:           1,
+	}
+	user.CreatedAt, user.UpdatedAt = now, now
+	d.s.users[user.ID] = user
+	d.s.nextID++
+	return nil
+}
+
+func (d *Database) User(ctx context.Context, email string) (models.User, error) {
+	defer d.lock()()
+	user, ok := d.find(email)
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (d *Database) UserByID(ctx context.Context, id int) (models.User, error) {
+	defer d.lock()()
+	user, ok := d.s.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return models.User{}, storage.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (d *Database) Users(ctx context.Context, filter database.UserFilter) ([]models.User, error) {
+	defer d.lock()()
+	var users []models.User
+	for _, user := range d.s.users {
+		switch {
+		case user.DeletedAt.Valid,
+			!strings.HasPrefix(user.Email, filter.EmailPrefix),
+			filter.Verified != nil && user.EmailVerified != *filter.Verified,
+			!filter.CreatedAfter.IsZero() && user.CreatedAt.Before(filter.CreatedAfter),
+			!filter.CreatedBefore.IsZero() && !user.CreatedAt.Before(filter.CreatedBefore),
+			user.ID <= filter.AfterID:
+			continue
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if filter.Limit > 0 && len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+	return users, nil
+}
+
+func (d *Database) EmailVerified(ctx context.Context, email string) (bool, error) {
+	user, err := d.User(ctx, email)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerified, nil
+}
+
+func (d *Database) EmailVerify(ctx context.Context, email string) error {
+	defer d.lock()()
+	if user, ok := d.find(email); ok {
+		user.EmailVerified = true
+		d.s.users[user.ID] = user
+	}
+	return nil
+}
+
+// UpdateUser copies the given columns from user onto the stored row. Column
+// names are the same as in Postgres so both implementations accept the same
+// calls, an unknown one fails the update like it does there.
+func (d *Database) UpdateUser(ctx context.Context, user models.User, columns ...string) error {
+	defer d.lock()()
+	stored, ok := d.s.users[user.ID]
+	if !ok || stored.DeletedAt.Valid || stored.Version != user.Version {
+		return storage.ErrVersionConflict
+	}
+	for _, column := range columns {
+		switch column {
+		case "email":
+			if d.taken(user.Email, user.ID) {
+				return storage.ErrUserExists
+			}
+			stored.Email = user.Email
+		case "email_verified":
+			stored.EmailVerified = user.EmailVerified
+		case "pass_hash":
+			stored.PassHash = user.PassHash
+		case "last_login_ip":
+			stored.LastLoginIp = user.LastLoginIp
+		case "last_login_date":
+			stored.LastLoginDate = user.LastLoginDate
+		case "balance":
+			stored.Balance = user.Balance
+		case "password_changed_at":
+			stored.PasswordChangedAt = user.PasswordChangedAt
+		case "password_reset_required":
+			stored.PasswordResetRequired = user.PasswordResetRequired
+		case "password_reset_reason":
+			stored.PasswordResetReason = user.PasswordResetReason
+		case "status":
+			stored.Status = user.Status
+		case "status_reason":
+			stored.StatusReason = user.StatusReason
+		case "status_until":
+			stored.StatusUntil = user.StatusUntil
+		case "ip_created":
+			stored.IpCreated = user.IpCreated
+		default:
+			return fmt.Errorf("memory: unknown user column %q", column)
+		}
+	}
+	stored.Version++
+	stored.UpdatedAt = d.now()
+	d.s.users[user.ID] = stored
+	return nil
+}
+
+func (d *Database) UpdateLastLogin(ctx context.Context, id int, ip string, date time.Time) error {
+	defer d.lock()()
+	user, ok := d.s.users[id]
+	if !ok {
+		return nil
+	}
+	user.LastLoginDate = date
+	if ip != "" {
+		user.LastLoginIp = ip
+	}
+	d.s.users[id] = user
+	return nil
+}
+
+func (d *Database) DeleteUser(ctx context.Context, email string) error {
+	defer d.lock()()
+	user, ok := d.find(email)
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	user.DeletedAt = gorm.DeletedAt{Time: d.now(), Valid: true}
+	d.s.users[user.ID] = user
+	return nil
+}
+
+// WithTx serialises transactions and restores the previous state when fn
+// fails, which is enough to give callers all-or-nothing behaviour.
+func (d *Database) WithTx(ctx context.Context, fn func(tx database.Database) error) error {
+	defer d.lock()()
+	snapshot := d.s.clone()
+	tx := &Database{mu: d.mu, inTx: true, s: d.s, now: d.now}
+	if err := fn(tx); err != nil {
+		*d.s = *snapshot
+		return err
+	}
+	return nil
+}
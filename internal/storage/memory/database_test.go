@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+// newUsers returns a Database holding jane@example.com and bob@example.com,
+// and jane's row.
+func newUsers(t *testing.T) (*Database, models.User) {
+	t.Helper()
+	ctx := context.Background()
+	db := NewDatabase(nil)
+	for _, email := range []string{"jane@example.com", "bob@example.com"} {
+		if err := db.CreateUser(ctx, email, "192.0.2.1", []byte("hash"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jane, err := db.User(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, jane
+}
+
+func TestUpdateUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(user *models.User)
+		columns []string
+		wantErr error
+	}{
+		{"known column", func(u *models.User) { u.EmailVerified = true }, []string{"email_verified"}, nil},
+		{"stale version", func(u *models.User) { u.Version-- }, []string{"email_verified"}, storage.ErrVersionConflict},
+		{"email taken", func(u *models.User) { u.Email = "bob@example.com" }, []string{"email"}, storage.ErrUserExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, jane := newUsers(t)
+			tt.change(&jane)
+			if err := db.UpdateUser(context.Background(), jane, tt.columns...); !errors.Is(err, tt.wantErr) {
+				t.Errorf("UpdateUser = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdateUserRejectsUnknownColumns(t *testing.T) {
+	ctx := context.Background()
+	db, jane := newUsers(t)
+	jane.EmailVerified = true
+	if err := db.UpdateUser(ctx, jane, "email_verified", "nickname"); err == nil {
+		t.Fatal("UpdateUser with an unknown column succeeded")
+	}
+	stored, err := db.UserByID(ctx, jane.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.EmailVerified || stored.Version != jane.Version {
+		t.Errorf("the failed update was applied: %+v", stored)
+	}
+}
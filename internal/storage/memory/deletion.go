@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+func (d *Database) AddAccountDeletion(ctx context.Context, deletion *models.AccountDeletion) error {
+	defer d.lock()()
+	deletion.ID = d.s.nextDeletionID
+	if deletion.CreatedAt.IsZero() {
+		deletion.CreatedAt = d.now()
+	}
+	d.s.deletions = append(d.s.deletions, *deletion)
+	d.s.nextDeletionID++
+	return nil
+}
+
+func (d *Database) AccountDeletion(ctx context.Context, tokenHash string) (models.AccountDeletion, error) {
+	defer d.lock()()
+	for _, deletion := range d.s.deletions {
+		if deletion.TokenHash == tokenHash {
+			return deletion, nil
+		}
+	}
+	return models.AccountDeletion{}, storage.ErrDeletionNotFound
+}
+
+func (d *Database) DueAccountDeletions(ctx context.Context, now time.Time, limit int) ([]models.AccountDeletion, error) {
+	defer d.lock()()
+	var due []models.AccountDeletion
+	for _, deletion := range d.s.deletions {
+		if !deletion.PurgeAt.After(now) {
+			due = append(due, deletion)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].PurgeAt.Before(due[j].PurgeAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (d *Database) DeleteAccountDeletion(ctx context.Context, id int64) error {
+	defer d.lock()()
+	kept := d.s.deletions[:0]
+	for _, deletion := range d.s.deletions {
+		if deletion.ID != id {
+			kept = append(kept, deletion)
+		}
+	}
+	d.s.deletions = kept
+	return nil
+}
+
+func (d *Database) DeleteUserData(ctx context.Context, userID int) error {
+	defer d.lock()()
+	for id, device := range d.s.devices {
+		if device.UserID == userID {
+			delete(d.s.devices, id)
+		}
+	}
+	alerts := d.s.alerts[:0]
+	for _, alert := range d.s.alerts {
+		if alert.UserID != userID {
+			alerts = append(alerts, alert)
+		}
+	}
+	d.s.alerts = alerts
+	history := d.s.passwordHistory[:0]
+	for _, entry := range d.s.passwordHistory {
+		if entry.UserID != userID {
+			history = append(history, entry)
+		}
+	}
+	d.s.passwordHistory = history
+	tokens := d.s.resetTokens[:0]
+	for _, token := range d.s.resetTokens {
+		if token.UserID != userID {
+			tokens = append(tokens, token)
+		}
+	}
+	d.s.resetTokens = tokens
+	userRoles := d.s.userRoles[:0]
+	for _, userRole := range d.s.userRoles {
+		if userRole.UserID != userID {
+			userRoles = append(userRoles, userRole)
+		}
+	}
+	d.s.userRoles = userRoles
+	deletions := d.s.deletions[:0]
+	for _, deletion := range d.s.deletions {
+		if deletion.UserID != userID {
+			deletions = append(deletions, deletion)
+		}
+	}
+	d.s.deletions = deletions
+	return nil
+}
+
+func (d *Database) ScrubUserData(ctx context.Context, email, replacement string) error {
+	defer d.lock()()
+	for i, event := range d.s.authEvents {
+		if event.Subject == email {
+			event.Subject = replacement
+			event.IP, event.Country, event.City, event.ASN, event.UserAgent = "", "", "", 0, ""
+		}
+		if event.Actor == email {
+			event.Actor = replacement
+		}
+		d.s.authEvents[i] = event
+	}
+	notifications := d.s.notifications[:0]
+	for _, n := range d.s.notifications {
+		if n.To != email {
+			notifications = append(notifications, n)
+		}
+	}
+	d.s.notifications = notifications
+	// payloads here are as encoding/json wrote them
+	from, _ := json.Marshal(email)
+	to, _ := json.Marshal(replacement)
+	for i := range d.s.outbox {
+		d.s.outbox[i].Payload = bytes.ReplaceAll(d.s.outbox[i].Payload, from, to)
+	}
+	for i := range d.s.deliveries {
+		d.s.deliveries[i].Payload = bytes.ReplaceAll(d.s.deliveries[i].Payload, from, to)
+	}
+	return nil
+}
+
+func (d *Database) PurgeUser(ctx context.Context, userID int) error {
+	defer d.lock()()
+	if _, ok := d.s.users[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	delete(d.s.users, userID)
+	return nil
+}
@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+func (d *Database) KnownDevices(ctx context.Context, userID int) ([]models.KnownDevice, error) {
+	defer d.lock()()
+	var devices []models.KnownDevice
+	for _, device := range d.s.devices {
+		if device.UserID == userID {
+			devices = append(devices, device)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].LastSeenAt.After(devices[j].LastSeenAt) })
+	return devices, nil
+}
+
+func (d *Database) SaveKnownDevice(ctx context.Context, device *models.KnownDevice) error {
+	defer d.lock()()
+	if device.ID == 0 {
+		device.ID = d.s.nextDeviceID
+		d.s.nextDeviceID++
+		d.s.devices[device.ID] = *device
+		return nil
+	}
+	stored, ok := d.s.devices[device.ID]
+	if !ok {
+		return nil
+	}
+	stored.IPPrefix = device.IPPrefix
+	stored.UserAgent = device.UserAgent
+	stored.Country = device.Country
+	stored.ASN = device.ASN
+	stored.LastSeenAt = device.LastSeenAt
+	d.s.devices[device.ID] = stored
+	return nil
+}
+
+func (d *Database) DeleteKnownDevice(ctx context.Context, id int64) error {
+	defer d.lock()()
+	delete(d.s.devices, id)
+	return nil
+}
+
+func (d *Database) AddLoginAlert(ctx context.Context, alert *models.LoginAlert) error {
+	defer d.lock()()
+	alert.ID = d.s.nextAlertID
+	if alert.CreatedAt.IsZero() {
+		alert.CreatedAt = d.now()
+	}
+	d.s.alerts = append(d.s.alerts, *alert)
+	d.s.nextAlertID++
+	return nil
+}
+
+func (d *Database) LoginAlert(ctx context.Context, tokenHash string) (models.LoginAlert, error) {
+	defer d.lock()()
+	for _, alert := range d.s.alerts {
+		if alert.TokenHash == tokenHash {
+			return alert, nil
+		}
+	}
+	return models.LoginAlert{}, storage.ErrLoginAlertNotFound
+}
+
+func (d *Database) UpdateLoginAlert(ctx context.Context, alert models.LoginAlert) error {
+	defer d.lock()()
+	for i := range d.s.alerts {
+		if d.s.alerts[i].ID == alert.ID {
+			d.s.alerts[i].UsedAt = alert.UsedAt
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *Database) PruneLoginAlerts(ctx context.Context, before time.Time) (int64, error) {
+	defer d.lock()()
+	kept := d.s.alerts[:0]
+	for _, alert := range d.s.alerts {
+		if !alert.ExpiresAt.Before(before) {
+			kept = append(kept, alert)
+		}
+	}
+	pruned := int64(len(d.s.alerts) - len(kept))
+	d.s.alerts = kept
+	return pruned, nil
+}
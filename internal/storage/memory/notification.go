@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+)
+
+func (d *Database) AddNotification(ctx context.Context, n *models.Notification) error {
+	defer d.lock()()
+	n.ID = d.s.nextNotificationID
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = d.now()
+	}
+	d.s.notifications = append(d.s.notifications, *n)
+	d.s.nextNotificationID++
+	return nil
+}
+
+func (d *Database) DueNotifications(ctx context.Context, now time.Time, limit int) ([]models.Notification, error) {
+	defer d.lock()()
+	var due []models.Notification
+	for _, n := range d.s.notifications {
+		if n.Status == models.NotificationPending && !n.NextAttemptAt.After(now) {
+			due = append(due, n)
+			if len(due) == limit {
+				break
+			}
+		}
+	}
+	return due, nil
+}
+
+func (d *Database) UpdateNotification(ctx context.Context, n models.Notification) error {
+	defer d.lock()()
+	for i := range d.s.notifications {
+		if d.s.notifications[i].ID == n.ID {
+			stored := &d.s.notifications[i]
+			stored.Status = n.Status
+			stored.Attempts = n.Attempts
+			stored.NextAttemptAt = n.NextAttemptAt
+			stored.LastError = n.LastError
+			stored.SentAt = n.SentAt
+			stored.Data = n.Data
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *Database) PruneNotifications(ctx context.Context, before time.Time) (int64, error) {
+	defer d.lock()()
+	kept := d.s.notifications[:0]
+	for _, n := range d.s.notifications {
+		if n.Status == models.NotificationPending || !n.CreatedAt.Before(before) {
+			kept = append(kept, n)
+		}
+	}
+	pruned := int64(len(d.s.notifications) - len(kept))
+	d.s.notifications = kept
+	return pruned, nil
+}
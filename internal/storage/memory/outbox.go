@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+)
+
+func (d *Database) AddOutboxEvent(ctx context.Context, event *models.OutboxEvent) error {
+	defer d.lock()()
+	event.ID = d.s.nextOutboxID
+	d.s.outbox = append(d.s.outbox, *event)
+	d.s.nextOutboxID++
+	return nil
+}
+
+// DueOutboxEvents has no locking to do, transactions are serialised anyway.
+func (d *Database) DueOutboxEvents(ctx context.Context, now time.Time, limit int) ([]models.OutboxEvent, error) {
+	defer d.lock()()
+	var events []models.OutboxEvent
+	for _, event := range d.s.outbox {
+		if event.PublishedAt == nil && !event.NextAttemptAt.After(now) {
+			events = append(events, event)
+			if len(events) == limit {
+				break
+			}
+		}
+	}
+	return events, nil
+}
+
+func (d *Database) UpdateOutboxEvent(ctx context.Context, event models.OutboxEvent) error {
+	defer d.lock()()
+	for i := range d.s.outbox {
+		if d.s.outbox[i].ID == event.ID {
+			stored := &d.s.outbox[i]
+			stored.Attempts = event.Attempts
+			stored.NextAttemptAt = event.NextAttemptAt
+			stored.LastError = event.LastError
+			stored.PublishedAt = event.PublishedAt
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *Database) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+	defer d.lock()()
+	kept := d.s.outbox[:0]
+	for _, event := range d.s.outbox {
+		if event.PublishedAt == nil || !event.PublishedAt.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	pruned := int64(len(d.s.outbox) - len(kept))
+	d.s.outbox = kept
+	return pruned, nil
+}
@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+func (d *Database) AddPasswordHistory(ctx context.Context, entry *models.PasswordHistory) error {
+	defer d.lock()()
+	entry.ID = d.s.nextHistoryID
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = d.now()
+	}
+	d.s.passwordHistory = append(d.s.passwordHistory, *entry)
+	d.s.nextHistoryID++
+	return nil
+}
+
+func (d *Database) PasswordHistory(ctx context.Context, userID, limit int) ([]models.PasswordHistory, error) {
+	defer d.lock()()
+	var entries []models.PasswordHistory
+	for i := len(d.s.passwordHistory) - 1; i >= 0 && len(entries) < limit; i-- {
+		if entry := d.s.passwordHistory[i]; entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (d *Database) TrimPasswordHistory(ctx context.Context, userID, keep int) error {
+	defer d.lock()()
+	seen := 0
+	kept := make([]models.PasswordHistory, 0, len(d.s.passwordHistory))
+	for i := len(d.s.passwordHistory) - 1; i >= 0; i-- {
+		entry := d.s.passwordHistory[i]
+		if entry.UserID == userID {
+			if seen == keep {
+				continue
+			}
+			seen++
+		}
+		kept = append(kept, entry)
+	}
+	// kept is newest first, the history is stored oldest first
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	d.s.passwordHistory = kept
+	return nil
+}
+
+func (d *Database) AddPasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	defer d.lock()()
+	token.ID = d.s.nextResetTokenID
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = d.now()
+	}
+	d.s.resetTokens = append(d.s.resetTokens, *token)
+	d.s.nextResetTokenID++
+	return nil
+}
+
+func (d *Database) PasswordResetToken(ctx context.Context, tokenHash string) (models.PasswordResetToken, error) {
+	defer d.lock()()
+	for _, token := range d.s.resetTokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return models.PasswordResetToken{}, storage.ErrResetTokenNotFound
+}
+
+func (d *Database) UpdatePasswordResetToken(ctx context.Context, token models.PasswordResetToken) error {
+	defer d.lock()()
+	for i := range d.s.resetTokens {
+		if d.s.resetTokens[i].ID == token.ID {
+			d.s.resetTokens[i].UsedAt = token.UsedAt
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *Database) PrunePasswordResetTokens(ctx context.Context, before time.Time) (int64, error) {
+	defer d.lock()()
+	kept := d.s.resetTokens[:0]
+	for _, token := range d.s.resetTokens {
+		if !token.ExpiresAt.Before(before) {
+			kept = append(kept, token)
+		}
+	}
+	pruned := int64(len(d.s.resetTokens) - len(kept))
+	d.s.resetTokens = kept
+	return pruned, nil
+}
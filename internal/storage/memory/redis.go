@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"github.com/GosMachine/ServiceAuth/internal/storage/redis"
+	"go.uber.org/zap"
+)
+
+var _ redis.Service = (*Redis)(nil)
+
+const emailVerifiedTTL = 24 * time.Hour
+
+// Redis is an in-memory redis.Service. Expiration is checked against the
+// injected clock on every read, so tests can move time forward instead of
+// sleeping.
+type Redis struct {
+	mu   sync.Mutex
+	keys map[string]entry
+	db   database.UserStore
+	log  *zap.Logger
+	now  func() time.Time
+}
+
+type entry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// NewRedis returns an empty Redis. now may be nil, time.Now is used then.
+func NewRedis(db database.UserStore, log *zap.Logger, now func() time.Time) *Redis {
+	if now == nil {
+		now = time.Now
+	}
+	return &Redis{keys: map[string]entry{}, db: db, log: log, now: now}
+}
+
+// get returns the live value of key, dropping it if it has expired.
+func (r *Redis) get(key string) (entry, bool) {
+	e, ok := r.keys[key]
+	if !ok {
+		return entry{}, false
+	}
+	if !e.expiresAt.IsZero() && !r.now().Before(e.expiresAt) {
+		delete(r.keys, key)
+		return entry{}, false
+	}
+	return e, true
+}
+
+func (r *Redis) set(key, value string, expiration time.Duration) {
+	e := entry{value: value}
+	if expiration > 0 {
+		e.expiresAt = r.now().Add(expiration)
+	}
+	r.keys[key] = e
+}
+
+func (r *Redis) SetEmailVerifiedCache(ctx context.Context, email string, verified bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value := "0"
+	if verified {
+		value = "1"
+	}
+	r.set("emailVerified:"+email, value, emailVerifiedTTL)
+	return nil
+}
+
+func (r *Redis) GetEmailVerifiedCache(ctx context.Context, email string) (bool, error) {
+	r.mu.Lock()
+	e, ok := r.get("emailVerified:" + email)
+	r.mu.Unlock()
+	if ok {
+		return e.value == "1", nil
+	}
+	verified, err := r.db.EmailVerified(ctx, email)
+	if err != nil {
+		r.log.Error("err check email verified", zap.String("email", email), zap.Error(err))
+		return false, err
+	}
+	return verified, r.SetEmailVerifiedCache(ctx, email, verified)
+}
+
+func (r *Redis) DeleteEmailVerifiedCache(ctx context.Context, email string) error {
+	return r.Delete(ctx, "emailVerified:"+email)
+}
+
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		delete(r.keys, key)
+	}
+	return nil
+}
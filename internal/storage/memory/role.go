@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+func (d *Database) SaveRole(ctx context.Context, role *models.Role) error {
+	defer d.lock()()
+	now := d.now()
+	permissions := make([]models.Permission, len(role.Permissions))
+	for i, permission := range role.Permissions {
+		id, ok := d.s.permissions[permission.Name]
+		if !ok {
+			id = d.s.nextPermissionID
+			d.s.nextPermissionID++
+			d.s.permissions[permission.Name] = id
+		}
+		permissions[i] = models.Permission{ID: id, Name: permission.Name}
+	}
+	role.Permissions = permissions
+	for id, stored := range d.s.roles {
+		if stored.Name == role.Name {
+			role.ID, role.CreatedAt, role.UpdatedAt = id, stored.CreatedAt, now
+			d.s.roles[id] = *role
+			return nil
+		}
+	}
+	role.ID, role.CreatedAt, role.UpdatedAt = d.s.nextRoleID, now, now
+	d.s.nextRoleID++
+	d.s.roles[role.ID] = *role
+	return nil
+}
+
+func (d *Database) Role(ctx context.Context, name string) (models.Role, error) {
+	defer d.lock()()
+	for _, role := range d.s.roles {
+		if role.Name == name {
+			return role, nil
+		}
+	}
+	return models.Role{}, storage.ErrRoleNotFound
+}
+
+func (d *Database) AssignRole(ctx context.Context, userRole *models.UserRole) error {
+	defer d.lock()()
+	for _, stored := range d.s.userRoles {
+		if stored.UserID == userRole.UserID && stored.RoleID == userRole.RoleID {
+			return nil
+		}
+	}
+	if userRole.CreatedAt.IsZero() {
+		userRole.CreatedAt = d.now()
+	}
+	d.s.userRoles = append(d.s.userRoles, *userRole)
+	return nil
+}
+
+func (d *Database) RevokeRole(ctx context.Context, userID int, roleID int64) error {
+	defer d.lock()()
+	kept := make([]models.UserRole, 0, len(d.s.userRoles))
+	for _, userRole := range d.s.userRoles {
+		if userRole.UserID != userID || userRole.RoleID != roleID {
+			kept = append(kept, userRole)
+		}
+	}
+	d.s.userRoles = kept
+	return nil
+}
+
+func (d *Database) UserRoles(ctx context.Context, email string) ([]models.Role, error) {
+	defer d.lock()()
+	user, ok := d.find(email)
+	if !ok {
+		return nil, nil
+	}
+	var roles []models.Role
+	for _, userRole := range d.s.userRoles {
+		if userRole.UserID == user.ID {
+			roles = append(roles, d.s.roles[userRole.RoleID])
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
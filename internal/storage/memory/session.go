@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+)
+
+var _ storage.SessionCache = (*Sessions)(nil)
+
+// Sessions is an in-memory storage.SessionStore, expiry follows the
+// injected clock.
+type Sessions struct {
+	mu       sync.Mutex
+	sessions map[string]storage.Session
+	now      func() time.Time
+}
+
+// NewSessions returns an empty Sessions. now may be nil, time.Now is used then.
+func NewSessions(now func() time.Time) *Sessions {
+	if now == nil {
+		now = time.Now
+	}
+	return &Sessions{sessions: map[string]storage.Session{}, now: now}
+}
+
+func (s *Sessions) get(token string) (storage.Session, bool) {
+	session, ok := s.sessions[token]
+	if !ok {
+		return storage.Session{}, false
+	}
+	if !s.now().Before(session.ExpiresAt) {
+		delete(s.sessions, token)
+		return storage.Session{}, false
+	}
+	return session, true
+}
+
+func (s *Sessions) Create(ctx context.Context, email string, ttl time.Duration, client storage.Client, access storage.Access) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for i := 0; i < 5; i++ {
+		token, err := storage.NewToken()
+		if err != nil {
+			return "", err
+		}
+		if _, ok := s.get(token); ok {
+			continue
+		}
+		s.sessions[token] = storage.Session{
+			ID:        storage.SessionID(token),
+			Email:     email,
+			CreatedAt: now,
+			ExpiresAt: now.Add(ttl),
+			Client:    client,
+			Access:    access,
+		}
+		return token, nil
+	}
+	return "", errors.New("failed to generate unique token")
+}
+
+func (s *Sessions) Put(ctx context.Context, token string, session storage.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.ID = storage.SessionID(token)
+	s.sessions[token] = session
+	return nil
+}
+
+func (s *Sessions) Get(ctx context.Context, token string) (storage.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.get(token)
+	if !ok {
+		return storage.Session{}, storage.ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *Sessions) SetAccess(ctx context.Context, token string, access storage.Access) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.get(token); ok {
+		session.Access = access
+		s.sessions[token] = session
+	}
+	return nil
+}
+
+func (s *Sessions) Delete(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+	return nil
+}
+
+func (s *Sessions) DeleteAll(ctx context.Context, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, session := range s.sessions {
+		if session.Email == email {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+func (s *Sessions) List(ctx context.Context, email string) ([]storage.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []storage.Session
+	for token := range s.sessions {
+		if session, ok := s.get(token); ok && session.Email == email {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+func (s *Sessions) TTL(ctx context.Context, token string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.get(token)
+	if !ok {
+		return -2
+	}
+	return session.ExpiresAt.Sub(s.now())
+}
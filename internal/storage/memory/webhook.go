@@ -0,0 +1,207 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+func (d *Database) CreateWebhook(ctx context.Context, sub *models.WebhookSubscription) error {
+	defer d.lock()()
+	now := d.now()
+	sub.ID = d.s.nextWebhookID
+	sub.CreatedAt, sub.UpdatedAt = now, now
+	d.s.webhooks[sub.ID] = *sub
+	d.s.nextWebhookID++
+	return nil
+}
+
+func (d *Database) Webhook(ctx context.Context, id int64) (models.WebhookSubscription, error) {
+	defer d.lock()()
+	sub, ok := d.s.webhooks[id]
+	if !ok {
+		return sub, storage.ErrWebhookNotFound
+	}
+	return sub, nil
+}
+
+func (d *Database) Webhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	defer d.lock()()
+	var subs []models.WebhookSubscription
+	for id := int64(1); id < d.s.nextWebhookID; id++ {
+		if sub, ok := d.s.webhooks[id]; ok {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (d *Database) UpdateWebhook(ctx context.Context, sub models.WebhookSubscription, columns ...string) error {
+	defer d.lock()()
+	stored, ok := d.s.webhooks[sub.ID]
+	if !ok {
+		return storage.ErrWebhookNotFound
+	}
+	for _, column := range columns {
+		switch column {
+		case "url":
+			stored.URL = sub.URL
+		case "secret":
+			stored.Secret = sub.Secret
+		case "event_types":
+			stored.EventTypes = sub.EventTypes
+		case "active":
+			stored.Active = sub.Active
+		case "consecutive_failures":
+			stored.ConsecutiveFailures = sub.ConsecutiveFailures
+		case "disabled_reason":
+			stored.DisabledReason = sub.DisabledReason
+		default:
+			return fmt.Errorf("memory: unknown webhook column %q", column)
+		}
+	}
+	stored.UpdatedAt = d.now()
+	d.s.webhooks[sub.ID] = stored
+	return nil
+}
+
+func (d *Database) DeleteWebhook(ctx context.Context, id int64) error {
+	defer d.lock()()
+	if _, ok := d.s.webhooks[id]; !ok {
+		return storage.ErrWebhookNotFound
+	}
+	delete(d.s.webhooks, id)
+	d.deleteDeliveries(func(delivery models.WebhookDelivery) bool {
+		return delivery.SubscriptionID == id
+	})
+	return nil
+}
+
+// deleteDeliveries drops the deliveries matching drop and their attempts and
+// returns how many went.
+func (d *Database) deleteDeliveries(drop func(models.WebhookDelivery) bool) int64 {
+	dropped := map[int64]bool{}
+	kept := d.s.deliveries[:0]
+	for _, delivery := range d.s.deliveries {
+		if drop(delivery) {
+			dropped[delivery.ID] = true
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	d.s.deliveries = kept
+	attempts := d.s.attempts[:0]
+	for _, attempt := range d.s.attempts {
+		if !dropped[attempt.DeliveryID] {
+			attempts = append(attempts, attempt)
+		}
+	}
+	d.s.attempts = attempts
+	return int64(len(dropped))
+}
+
+func (d *Database) AddWebhookDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	defer d.lock()()
+	now := d.now()
+	for _, delivery := range deliveries {
+		delivery.ID = d.s.nextDeliveryID
+		delivery.CreatedAt, delivery.UpdatedAt = now, now
+		d.s.deliveries = append(d.s.deliveries, delivery)
+		d.s.nextDeliveryID++
+	}
+	return nil
+}
+
+func (d *Database) DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	defer d.lock()()
+	var due []models.WebhookDelivery
+	for _, delivery := range d.s.deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+			if len(due) == limit {
+				break
+			}
+		}
+	}
+	return due, nil
+}
+
+func (d *Database) WebhookDelivery(ctx context.Context, id int64) (models.WebhookDelivery, error) {
+	defer d.lock()()
+	for _, delivery := range d.s.deliveries {
+		if delivery.ID == id {
+			return delivery, nil
+		}
+	}
+	return models.WebhookDelivery{}, storage.ErrDeliveryNotFound
+}
+
+func (d *Database) WebhookDeliveries(ctx context.Context, filter database.WebhookDeliveryFilter) ([]models.WebhookDelivery, error) {
+	defer d.lock()()
+	var deliveries []models.WebhookDelivery
+	for i := len(d.s.deliveries) - 1; i >= 0; i-- {
+		delivery := d.s.deliveries[i]
+		switch {
+		case filter.SubscriptionID > 0 && delivery.SubscriptionID != filter.SubscriptionID,
+			filter.Status != "" && delivery.Status != filter.Status,
+			filter.BeforeID > 0 && delivery.ID >= filter.BeforeID:
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		if filter.Limit > 0 && len(deliveries) == filter.Limit {
+			break
+		}
+	}
+	return deliveries, nil
+}
+
+func (d *Database) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	defer d.lock()()
+	for i := range d.s.deliveries {
+		if d.s.deliveries[i].ID == delivery.ID {
+			stored := &d.s.deliveries[i]
+			stored.Status = delivery.Status
+			stored.Attempts = delivery.Attempts
+			stored.NextAttemptAt = delivery.NextAttemptAt
+			stored.LastStatusCode = delivery.LastStatusCode
+			stored.LastError = delivery.LastError
+			stored.DeliveredAt = delivery.DeliveredAt
+			stored.UpdatedAt = d.now()
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *Database) AddWebhookAttempt(ctx context.Context, attempt *models.WebhookAttempt) error {
+	defer d.lock()()
+	attempt.ID = d.s.nextAttemptID
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = d.now()
+	}
+	d.s.attempts = append(d.s.attempts, *attempt)
+	d.s.nextAttemptID++
+	return nil
+}
+
+func (d *Database) WebhookAttempts(ctx context.Context, deliveryID int64) ([]models.WebhookAttempt, error) {
+	defer d.lock()()
+	var attempts []models.WebhookAttempt
+	for _, attempt := range d.s.attempts {
+		if attempt.DeliveryID == deliveryID {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (d *Database) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	defer d.lock()()
+	return d.deleteDeliveries(func(delivery models.WebhookDelivery) bool {
+		return delivery.Status == models.DeliveryDelivered && delivery.DeliveredAt != nil && delivery.DeliveredAt.Before(before)
+	}), nil
+}
@@ -0,0 +1,29 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+// passthrough is the Service used when no Redis is configured: nothing is
+// cached and every lookup goes to the database.
+type passthrough struct {
+	db database.UserStore
+}
+
+func NewPassthrough(db database.UserStore) Service {
+	return &passthrough{db: db}
+}
+
+func (p *passthrough) SetEmailVerifiedCache(ctx context.Context, email string, verified bool) error {
+	return nil
+}
+
+func (p *passthrough) GetEmailVerifiedCache(ctx context.Context, email string) (bool, error) {
+	return p.db.EmailVerified(ctx, email)
+}
+
+func (p *passthrough) DeleteEmailVerifiedCache(ctx context.Context, email string) error { return nil }
+
+func (p *passthrough) Delete(ctx context.Context, values ...string) error { return nil }
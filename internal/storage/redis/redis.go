@@ -2,38 +2,124 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"os"
 	"time"
 
+	"github.com/GosMachine/ServiceAuth/internal/config"
 	"github.com/GosMachine/ServiceAuth/internal/storage/database"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
+const pingTimeout = 5 * time.Second
+
 type Redis struct {
-	client *redis.Client
-	db     database.Database
+	client redis.UniversalClient
+	prefix string
+	db     database.UserStore
 	log    *zap.Logger
 }
 type Service interface {
-	GetEmail(token string) string
-	CreateToken(email string, expiration time.Duration) string
-	DeleteToken(token string) error
-	SetEmailVerifiedCache(email string, verified bool) error
-	GetEmailVerifiedCache(email string) (bool, error)
-	GetTokenTTL(token string) time.Duration
-	Delete(values ...string) error
+	SetEmailVerifiedCache(ctx context.Context, email string, verified bool) error
+	GetEmailVerifiedCache(ctx context.Context, email string) (bool, error)
+	DeleteEmailVerifiedCache(ctx context.Context, email string) error
+	Delete(ctx context.Context, values ...string) error
 }
 
-func (r *Redis) Delete(keys ...string) error {
-	return r.client.Del(context.Background(), keys...).Err()
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	// one DEL per key, a multi-key DEL fails across cluster slots
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, r.key(key))
+		}
+		return nil
+	})
+	return err
 }
 
-func New(db database.Database, log *zap.Logger) Service {
-	client := redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_ADDR"),
-		Password: os.Getenv("REDIS_PASS"),
-		DB:       0,
-	})
-	return &Redis{client: client, db: db, log: log}
+func New(client redis.UniversalClient, prefix string, db database.UserStore, log *zap.Logger) Service {
+	return &Redis{client: client, prefix: prefix, db: db, log: log}
+}
+
+// Connect builds a client for the configured mode and makes sure Redis
+// answers before the service starts taking requests.
+func Connect(cfg config.RedisConfig) (redis.UniversalClient, error) {
+	client, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis (%s mode, %v) is unreachable: %w", cfg.Mode, cfg.Addrs, err)
+	}
+	return client, nil
+}
+
+func newClient(cfg config.RedisConfig) (redis.UniversalClient, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		SentinelUsername: cfg.SentinelUsername,
+		SentinelPassword: cfg.SentinelPassword,
+		DB:               cfg.DB,
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+	}
+	if cfg.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	switch cfg.Mode {
+	case "sentinel":
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case "cluster":
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return redis.NewClient(opts.Simple()), nil
+	}
+}
+
+func newTLSConfig(cfg config.RedisTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read redis ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// key namespaces every key the service touches with the configured prefix.
+func (r *Redis) key(key string) string {
+	return r.prefix + key
 }
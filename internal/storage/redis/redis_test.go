@@ -0,0 +1,58 @@
+package redis
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// recorder answers every command itself and keeps what was sent, so the
+// client never dials.
+type recorder struct {
+	cmds [][]interface{}
+}
+
+func (r *recorder) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (r *recorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		r.cmds = append(r.cmds, cmd.Args())
+		return nil
+	}
+}
+
+func (r *recorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			r.cmds = append(r.cmds, cmd.Args())
+		}
+		return nil
+	}
+}
+
+func TestDeleteSendsOneKeyPerDel(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	rec := &recorder{}
+	client.AddHook(rec)
+
+	r := New(client, "auth:", nil, nil)
+	if err := r.Delete(context.Background(), "a", "b", "c"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if len(rec.cmds) != 3 {
+		t.Fatalf("sent %d commands, want 3: %v", len(rec.cmds), rec.cmds)
+	}
+	for i, key := range []string{"auth:a", "auth:b", "auth:c"} {
+		args := rec.cmds[i]
+		if len(args) != 2 || args[0] != "del" || args[1] != key {
+			t.Errorf("command %d = %v, want [del %s]", i, args, key)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+var _ storage.SessionCache = (*Sessions)(nil)
+
+// Sessions stores each session under its token and keeps a per-user set of
+// tokens so that a user's sessions can be listed.
+type Sessions struct {
+	client redis.UniversalClient
+	prefix string
+	log    *zap.Logger
+}
+
+type sessionValue struct {
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IP        string    `json:"ip,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	City      string    `json:"city,omitempty"`
+	ASN       uint      `json:"asn,omitempty"`
+	// Roles, Permissions and AccessCheckedAt are missing from sessions
+	// created before roles existed, they are filled in on first use.
+	Roles           []string  `json:"roles,omitempty"`
+	Permissions     []string  `json:"permissions,omitempty"`
+	AccessCheckedAt time.Time `json:"access_checked_at,omitempty"`
+}
+
+func NewSessions(client redis.UniversalClient, prefix string, log *zap.Logger) *Sessions {
+	return &Sessions{client: client, prefix: prefix, log: log}
+}
+
+func (s *Sessions) Create(ctx context.Context, email string, ttl time.Duration, client storage.Client, access storage.Access) (string, error) {
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		token, err := storage.NewToken()
+		if err != nil {
+			return "", err
+		}
+		session := storage.Session{Email: email, CreatedAt: now, ExpiresAt: now.Add(ttl), Client: client, Access: access}
+		ok, err := s.save(ctx, token, session, true)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return token, nil
+		}
+	}
+	return "", errors.New("failed to generate unique token")
+}
+
+func (s *Sessions) Put(ctx context.Context, token string, session storage.Session) error {
+	_, err := s.save(ctx, token, session, false)
+	return err
+}
+
+func (s *Sessions) save(ctx context.Context, token string, session storage.Session, onlyNew bool) (bool, error) {
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return true, nil
+	}
+	value, err := json.Marshal(sessionValue{
+		Email:     session.Email,
+		CreatedAt: session.CreatedAt,
+		ExpiresAt: session.ExpiresAt,
+		IP:        session.IP,
+		Country:   session.Country,
+		City:      session.City,
+		ASN:       session.ASN,
+
+		Roles:           session.Roles,
+		Permissions:     session.Permissions,
+		AccessCheckedAt: session.CheckedAt,
+	})
+	if err != nil {
+		return false, err
+	}
+	if onlyNew {
+		ok, err := s.client.SetNX(ctx, s.key(token), value, ttl).Result()
+		if err != nil || !ok {
+			return false, err
+		}
+	} else if err := s.client.Set(ctx, s.key(token), value, ttl).Err(); err != nil {
+		return false, err
+	}
+
+	index := s.indexKey(session.Email)
+	if err := s.client.SAdd(ctx, index, token).Err(); err != nil {
+		return false, err
+	}
+	// the index has to live as long as the longest session in it
+	if current := s.client.TTL(ctx, index).Val(); current < ttl {
+		s.client.Expire(ctx, index, ttl)
+	}
+	return true, nil
+}
+
+func (s *Sessions) Get(ctx context.Context, token string) (storage.Session, error) {
+	value, err := s.client.Get(ctx, s.key(token)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return storage.Session{}, storage.ErrSessionNotFound
+		}
+		return storage.Session{}, err
+	}
+	return s.decode(ctx, token, value)
+}
+
+// decode also understands the plain email values written before sessions
+// carried any metadata.
+func (s *Sessions) decode(ctx context.Context, token, value string) (storage.Session, error) {
+	session := storage.Session{ID: storage.SessionID(token)}
+	if !strings.HasPrefix(value, "{") {
+		session.Email = value
+		session.ExpiresAt = time.Now().Add(s.client.TTL(ctx, s.key(token)).Val())
+		return session, nil
+	}
+	var v sessionValue
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return storage.Session{}, err
+	}
+	session.Email, session.CreatedAt, session.ExpiresAt = v.Email, v.CreatedAt, v.ExpiresAt
+	session.Client = storage.Client{IP: v.IP, Country: v.Country, City: v.City, ASN: v.ASN}
+	session.Access = storage.Access{Roles: v.Roles, Permissions: v.Permissions, CheckedAt: v.AccessCheckedAt}
+	return session, nil
+}
+
+// SetAccess rewrites the session, sessions in the old plain format become
+// JSON ones.
+func (s *Sessions) SetAccess(ctx context.Context, token string, access storage.Access) error {
+	session, err := s.Get(ctx, token)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return nil
+		}
+		return err
+	}
+	session.Access = access
+	_, err = s.save(ctx, token, session, false)
+	return err
+}
+
+func (s *Sessions) Delete(ctx context.Context, token string) error {
+	session, err := s.Get(ctx, token)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := s.client.Del(ctx, s.key(token)).Err(); err != nil {
+		return err
+	}
+	return s.client.SRem(ctx, s.indexKey(session.Email), token).Err()
+}
+
+func (s *Sessions) DeleteAll(ctx context.Context, email string) error {
+	index := s.indexKey(email)
+	tokens, err := s.client.SMembers(ctx, index).Result()
+	if err != nil {
+		return err
+	}
+	// one DEL per key, the keys may live on different cluster slots
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, token := range tokens {
+			pipe.Del(ctx, s.key(token))
+		}
+		pipe.Del(ctx, index)
+		return nil
+	})
+	return err
+}
+
+func (s *Sessions) List(ctx context.Context, email string) ([]storage.Session, error) {
+	index := s.indexKey(email)
+	tokens, err := s.client.SMembers(ctx, index).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]storage.Session, 0, len(tokens))
+	for _, token := range tokens {
+		session, err := s.Get(ctx, token)
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			s.client.SRem(ctx, index, token)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *Sessions) TTL(ctx context.Context, token string) time.Duration {
+	return s.client.TTL(ctx, s.key(token)).Val()
+}
+
+// key keeps the unprefixed-token layout used before sessions were split out
+// of Service, so existing sessions stay valid.
+func (s *Sessions) key(token string) string {
+	return s.prefix + token
+}
+
+func (s *Sessions) indexKey(email string) string {
+	return s.prefix + "sessions:" + email
+}
@@ -5,46 +5,27 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/GosMachine/ServiceAuth/internal/utils"
 	"go.uber.org/zap"
 )
 
-// returning user email
-func (r *Redis) GetEmail(token string) string {
-	return r.client.Get(context.Background(), token).Val()
+func (r *Redis) SetEmailVerifiedCache(ctx context.Context, email string, verified bool) error {
+	return r.client.Set(ctx, r.emailVerifiedKey(email), verified, time.Hour*24).Err()
 }
 
-func (r *Redis) CreateToken(email string, expiration time.Duration) string {
-	for i := 0; i < 5; i++ {
-		token := utils.GenerateRandomString(32)
-		if r.client.Exists(context.Background(), token).Val() == 0 {
-			if r.client.Set(context.Background(), token, email, expiration).Err() != nil {
-				continue
-			}
-			return token
-		}
-	}
-	return ""
-}
-
-func (r *Redis) DeleteToken(token string) error {
-	return r.client.Del(context.Background(), token).Err()
-}
-
-func (r *Redis) SetEmailVerifiedCache(email string, verified bool) error {
-	return r.client.Set(context.Background(), fmt.Sprintf("emailVerified:%s", email), verified, time.Hour*24).Err()
+func (r *Redis) DeleteEmailVerifiedCache(ctx context.Context, email string) error {
+	return r.client.Del(ctx, r.emailVerifiedKey(email)).Err()
 }
 
-func (r *Redis) GetEmailVerifiedCache(email string) (bool, error) {
-	verified, err := r.client.Get(context.Background(), fmt.Sprintf("emailVerified:%s", email)).Bool()
+func (r *Redis) GetEmailVerifiedCache(ctx context.Context, email string) (bool, error) {
+	verified, err := r.client.Get(ctx, r.emailVerifiedKey(email)).Bool()
 	if err != nil {
 		r.log.Error("error get user data from cache", zap.Error(err))
-		verified, err = r.db.EmailVerified(email)
+		verified, err = r.db.EmailVerified(ctx, email)
 		if err != nil {
 			r.log.Error("err check email verified", zap.String("email", email), zap.Error(err))
 			return false, err
 		}
-		err = r.SetEmailVerifiedCache(email, verified)
+		err = r.SetEmailVerifiedCache(ctx, email, verified)
 		if err != nil {
 			r.log.Error("err set email verified cache", zap.Error(err), zap.String("email", email))
 		}
@@ -55,6 +36,6 @@ func (r *Redis) GetEmailVerifiedCache(email string) (bool, error) {
 	return verified, nil
 }
 
-func (r *Redis) GetTokenTTL(token string) time.Duration {
-	return r.client.TTL(context.Background(), token).Val()
+func (r *Redis) emailVerifiedKey(email string) string {
+	return r.key(fmt.Sprintf("emailVerified:%s", email))
 }
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type Session struct {
+	// ID identifies the session without revealing the token, it is the
+	// hex encoded SHA-256 of the token.
+	ID        string
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Client
+	Access
+}
+
+// Client is where a session was started from, as far as it is known.
+type Client struct {
+	IP      string
+	Country string
+	City    string
+	ASN     uint
+}
+
+// Access is a copy of the user's roles and permissions kept with a session,
+// taken at CheckedAt. It is refreshed once it is older than the configured
+// interval, so role changes reach existing sessions within it.
+type Access struct {
+	Roles       []string
+	Permissions []string
+	CheckedAt   time.Time
+}
+
+// Can tells whether permission is among a's permissions.
+func (a Access) Can(permission string) bool {
+	for _, p := range a.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type SessionStore interface {
+	Create(ctx context.Context, email string, ttl time.Duration, client Client, access Access) (token string, err error)
+	Get(ctx context.Context, token string) (Session, error)
+	// SetAccess replaces the roles and permissions of a session, it does
+	// nothing if the session is gone.
+	SetAccess(ctx context.Context, token string, access Access) error
+	Delete(ctx context.Context, token string) error
+	List(ctx context.Context, email string) ([]Session, error)
+	// DeleteAll ends every session of the user.
+	DeleteAll(ctx context.Context, email string) error
+	TTL(ctx context.Context, token string) time.Duration
+}
+
+// SessionCache is a SessionStore that can also hold sessions minted elsewhere.
+type SessionCache interface {
+	SessionStore
+	Put(ctx context.Context, token string, session Session) error
+}
+
+// NewToken returns a random session token for a SessionStore to hand out.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func SessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
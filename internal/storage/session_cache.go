@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// cachedSessions keeps primary as the source of truth and writes every
+// session through to cache, so reads are served by the cache while sessions
+// survive losing it.
+type cachedSessions struct {
+	primary SessionStore
+	cache   SessionCache
+	log     *zap.Logger
+}
+
+func NewCachedSessionStore(primary SessionStore, cache SessionCache, log *zap.Logger) SessionStore {
+	return &cachedSessions{primary: primary, cache: cache, log: log}
+}
+
+func (c *cachedSessions) Create(ctx context.Context, email string, ttl time.Duration, client Client, access Access) (string, error) {
+	token, err := c.primary.Create(ctx, email, ttl, client, access)
+	if err != nil {
+		return "", err
+	}
+	session, err := c.primary.Get(ctx, token)
+	if err == nil {
+		err = c.cache.Put(ctx, token, session)
+	}
+	if err != nil {
+		c.log.Warn("failed to cache session", zap.Error(err))
+	}
+	return token, nil
+}
+
+func (c *cachedSessions) Get(ctx context.Context, token string) (Session, error) {
+	if session, err := c.cache.Get(ctx, token); err == nil {
+		return session, nil
+	}
+	session, err := c.primary.Get(ctx, token)
+	if err != nil {
+		return Session{}, err
+	}
+	if err := c.cache.Put(ctx, token, session); err != nil {
+		c.log.Warn("failed to cache session", zap.Error(err))
+	}
+	return session, nil
+}
+
+func (c *cachedSessions) SetAccess(ctx context.Context, token string, access Access) error {
+	if err := c.primary.SetAccess(ctx, token, access); err != nil {
+		return err
+	}
+	return c.cache.SetAccess(ctx, token, access)
+}
+
+// Delete evicts the session from the cache before and after removing it
+// from primary. Get trusts the cache, so if it can't be reached the session
+// is left alone and the error returned rather than have it outlive its
+// revocation. The second eviction catches a Get that cached it in between.
+func (c *cachedSessions) Delete(ctx context.Context, token string) error {
+	if err := c.cache.Delete(ctx, token); err != nil {
+		return err
+	}
+	if err := c.primary.Delete(ctx, token); err != nil {
+		return err
+	}
+	return c.cache.Delete(ctx, token)
+}
+
+// DeleteAll evicts the sessions the same way as Delete.
+func (c *cachedSessions) DeleteAll(ctx context.Context, email string) error {
+	if err := c.cache.DeleteAll(ctx, email); err != nil {
+		return err
+	}
+	if err := c.primary.DeleteAll(ctx, email); err != nil {
+		return err
+	}
+	return c.cache.DeleteAll(ctx, email)
+}
+
+func (c *cachedSessions) List(ctx context.Context, email string) ([]Session, error) {
+	return c.primary.List(ctx, email)
+}
+
+func (c *cachedSessions) TTL(ctx context.Context, token string) time.Duration {
+	return c.primary.TTL(ctx, token)
+}
@@ -0,0 +1,77 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+	"go.uber.org/zap"
+)
+
+var errCacheDown = errors.New("cache is down")
+
+// flakyCache is a memory session cache whose deletes fail while down is set.
+type flakyCache struct {
+	*memory.Sessions
+	down bool
+}
+
+func (c *flakyCache) Delete(ctx context.Context, token string) error {
+	if c.down {
+		return errCacheDown
+	}
+	return c.Sessions.Delete(ctx, token)
+}
+
+func (c *flakyCache) DeleteAll(ctx context.Context, email string) error {
+	if c.down {
+		return errCacheDown
+	}
+	return c.Sessions.DeleteAll(ctx, email)
+}
+
+func TestCachedSessionsRevoke(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(s storage.SessionStore, token string) error
+	}{
+		{"Delete", func(s storage.SessionStore, token string) error {
+			return s.Delete(context.Background(), token)
+		}},
+		{"DeleteAll", func(s storage.SessionStore, token string) error {
+			return s.DeleteAll(context.Background(), "jane@example.com")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			primary := memory.NewSessions(nil)
+			cache := &flakyCache{Sessions: memory.NewSessions(nil)}
+			s := storage.NewCachedSessionStore(primary, cache, zap.NewNop())
+			token, err := s.Create(ctx, "jane@example.com", time.Hour, storage.Client{}, storage.Access{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a revoke that can't reach the cache fails and changes nothing
+			cache.down = true
+			if err := tt.revoke(s, token); !errors.Is(err, errCacheDown) {
+				t.Fatalf("%s with the cache down = %v, want %v", tt.name, err, errCacheDown)
+			}
+			if _, err := primary.Get(ctx, token); err != nil {
+				t.Errorf("primary Get after a failed %s: %v", tt.name, err)
+			}
+
+			cache.down = false
+			if err := tt.revoke(s, token); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if _, err := s.Get(ctx, token); !errors.Is(err, storage.ErrSessionNotFound) {
+				t.Errorf("Get after %s = %v, want ErrSessionNotFound", tt.name, err)
+			}
+		})
+	}
+}
@@ -3,6 +3,26 @@ package storage
 import "errors"
 
 var (
-	ErrUserExists   = errors.New("user already exists")
-	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists      = errors.New("user already exists")
+	ErrUserNotFound    = errors.New("user not found")
+	ErrVersionConflict = errors.New("user was modified concurrently")
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+var (
+	ErrLoginAlertNotFound = errors.New("login alert not found")
+	ErrResetTokenNotFound = errors.New("password reset token not found")
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrDeletionNotFound   = errors.New("account deletion not found")
+)
+
+var (
+	ErrDuplicateTransaction = errors.New("balance transaction already exists")
+	// ErrSerializationFailure is returned by WithSerializableTx when the
+	// transaction conflicted with a concurrent one, it can be retried.
+	ErrSerializationFailure = errors.New("transaction could not be serialized")
 )
@@ -0,0 +1,56 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+)
+
+var _ events.TxSink = (*Dispatcher)(nil)
+
+// Dispatcher is the events.Sink that feeds webhooks: it queues a delivery
+// for every active subscription that wants the event. The Worker sends them.
+type Dispatcher struct {
+	db database.Database
+}
+
+func NewDispatcher(db database.Database) *Dispatcher {
+	return &Dispatcher{db: db}
+}
+
+func (d *Dispatcher) Publish(ctx context.Context, event events.Envelope) error {
+	return d.PublishTx(ctx, d.db, event)
+}
+
+// PublishTx queues the deliveries through tx, so they exist exactly when the
+// event is marked published.
+func (d *Dispatcher) PublishTx(ctx context.Context, tx database.Database, event events.Envelope) error {
+	subs, err := tx.Webhooks(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var deliveries []models.WebhookDelivery
+	for _, sub := range subs {
+		if !sub.Active || !sub.Wants(event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, models.WebhookDelivery{
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        body,
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+		})
+	}
+	return tx.AddWebhookDeliveries(ctx, deliveries)
+}
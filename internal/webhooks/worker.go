@@ -0,0 +1,236 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"go.uber.org/zap"
+)
+
+const (
+	pruneInterval = time.Hour
+	maxErrorLen   = 1024
+)
+
+// Worker sends queued deliveries. It leases the ones it takes for
+// cfg.Lease and sends them without holding a transaction, so several
+// workers can run side by side. A delivery that keeps failing is retried
+// with backoff until cfg.MaxAttempts, then dead-lettered. A subscription is
+// switched off after cfg.DisableAfter failures in a row.
+type Worker struct {
+	db     database.Database
+	client *http.Client
+	log    *zap.Logger
+	cfg    config.WebhooksConfig
+}
+
+func NewWorker(db database.Database, log *zap.Logger, cfg config.WebhooksConfig) *Worker {
+	return &Worker{
+		db:     db,
+		client: &http.Client{Timeout: cfg.Timeout},
+		log:    log,
+		cfg:    cfg,
+	}
+}
+
+// Run delivers due webhooks every cfg.PollInterval until ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			n, err := w.DeliverBatch(ctx)
+			if err != nil {
+				w.log.Error("failed to deliver webhooks", zap.Error(err))
+				break
+			}
+			if n < w.cfg.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+		if time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			w.prune(ctx)
+		}
+	}
+}
+
+// DeliverBatch sends up to cfg.BatchSize due deliveries and returns how many
+// it handled.
+func (w *Worker) DeliverBatch(ctx context.Context) (int, error) {
+	due, err := w.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i := range due {
+		if err := w.deliver(ctx, &due[i]); err != nil {
+			return len(due), err
+		}
+	}
+	return len(due), nil
+}
+
+// claim leases up to cfg.BatchSize due deliveries by moving their next
+// attempt cfg.Lease ahead, which keeps other workers off them. A worker that
+// dies before recording the outcome leaves them to fall due again.
+func (w *Worker) claim(ctx context.Context) ([]models.WebhookDelivery, error) {
+	var due []models.WebhookDelivery
+	err := w.db.WithTx(ctx, func(tx database.Database) error {
+		now := time.Now()
+		var err error
+		due, err = tx.DueWebhookDeliveries(ctx, now, w.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, delivery := range due {
+			delivery.NextAttemptAt = now.Add(w.cfg.Lease)
+			if err := tx.UpdateWebhookDelivery(ctx, delivery); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return due, err
+}
+
+// deliver sends a claimed delivery and records the attempt in a short
+// transaction afterwards.
+func (w *Worker) deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	sub, err := w.db.Webhook(ctx, delivery.SubscriptionID)
+	if err != nil && !errors.Is(err, storage.ErrWebhookNotFound) {
+		return err
+	}
+	if err != nil || !sub.Active {
+		delivery.Status = models.DeliveryDead
+		delivery.LastError = "subscription is disabled"
+		return w.db.UpdateWebhookDelivery(ctx, *delivery)
+	}
+
+	attempt := w.send(ctx, &sub, delivery)
+	return w.db.WithTx(ctx, func(tx database.Database) error {
+		// the failure count may have moved while we were sending
+		sub, err := tx.Webhook(ctx, delivery.SubscriptionID)
+		if errors.Is(err, storage.ErrWebhookNotFound) {
+			// deleted together with its deliveries
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.AddWebhookAttempt(ctx, &attempt); err != nil {
+			return err
+		}
+		w.record(&sub, delivery, attempt)
+		if err := tx.UpdateWebhookDelivery(ctx, *delivery); err != nil {
+			return err
+		}
+		err = tx.UpdateWebhook(ctx, sub, "consecutive_failures", "active", "disabled_reason")
+		if errors.Is(err, storage.ErrWebhookNotFound) {
+			return nil
+		}
+		return err
+	})
+}
+
+// record applies the outcome of attempt to the delivery and the failure
+// count of its subscription.
+func (w *Worker) record(sub *models.WebhookSubscription, delivery *models.WebhookDelivery, attempt models.WebhookAttempt) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatusCode = attempt.StatusCode
+	delivery.LastError = attempt.Error
+	if attempt.Error == "" {
+		delivery.Status = models.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		sub.ConsecutiveFailures = 0
+		return
+	}
+
+	w.log.Warn("webhook delivery failed",
+		zap.Int64("webhook_id", sub.ID),
+		zap.Int64("delivery_id", delivery.ID),
+		zap.Int("attempts", delivery.Attempts),
+		zap.String("error", attempt.Error),
+	)
+	if delivery.Attempts >= w.cfg.MaxAttempts {
+		delivery.Status = models.DeliveryDead
+	} else {
+		delivery.NextAttemptAt = now.Add(events.Backoff(delivery.Attempts, w.cfg.MaxBackoff))
+	}
+	sub.ConsecutiveFailures++
+	if sub.Active && sub.ConsecutiveFailures >= w.cfg.DisableAfter {
+		sub.Active = false
+		sub.DisabledReason = fmt.Sprintf("disabled after %d failed deliveries in a row", sub.ConsecutiveFailures)
+		w.log.Warn("webhook disabled", zap.Int64("webhook_id", sub.ID))
+	}
+}
+
+// send makes one signed POST and reports how it went. Statuses outside 2xx
+// count as failures.
+func (w *Worker) send(ctx context.Context, sub *models.WebhookSubscription, delivery *models.WebhookDelivery) (attempt models.WebhookAttempt) {
+	start := time.Now()
+	attempt = models.WebhookAttempt{DeliveryID: delivery.ID, CreatedAt: start}
+	defer func() { attempt.DurationMs = time.Since(start).Milliseconds() }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = truncate(err.Error())
+		return attempt
+	}
+	timestamp := start.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ServiceAuth-Webhooks/1")
+	req.Header.Set(HeaderID, delivery.EventID)
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, timestamp, delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		attempt.Error = truncate(err.Error())
+		return attempt
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = "unexpected status " + resp.Status
+	}
+	return attempt
+}
+
+// prune drops deliveries that succeeded more than cfg.Retention ago.
+func (w *Worker) prune(ctx context.Context) {
+	pruned, err := w.db.PruneWebhookDeliveries(ctx, time.Now().Add(-w.cfg.Retention))
+	if err != nil {
+		w.log.Error("failed to prune webhook deliveries", zap.Error(err))
+		return
+	}
+	if pruned > 0 {
+		w.log.Info("delivered webhooks pruned", zap.Int64("count", pruned))
+	}
+}
+
+func truncate(s string) string {
+	if len(s) > maxErrorLen {
+		return s[:maxErrorLen]
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/GosMachine/ServiceAuth/internal/config"
+	"github.com/GosMachine/ServiceAuth/internal/events"
+	"github.com/GosMachine/ServiceAuth/internal/models"
+	"github.com/GosMachine/ServiceAuth/internal/storage/database"
+	"github.com/GosMachine/ServiceAuth/internal/storage/memory"
+	"go.uber.org/zap"
+)
+
+var testConfig = config.WebhooksConfig{
+	BatchSize:    10,
+	Timeout:      5 * time.Second,
+	MaxAttempts:  3,
+	MaxBackoff:   time.Minute,
+	DisableAfter: 2,
+	Lease:        time.Minute,
+}
+
+// setup subscribes url to every event and queues one user.registered
+// delivery for it.
+func setup(t *testing.T, url string) (*memory.Database, models.WebhookSubscription) {
+	t.Helper()
+	ctx := context.Background()
+	db := memory.NewDatabase(nil)
+	sub := models.WebhookSubscription{URL: url, Secret: "whsec_test", Active: true}
+	if err := db.CreateWebhook(ctx, &sub); err != nil {
+		t.Fatal(err)
+	}
+	event, err := events.New(events.TypeUserRegistered, events.UserRegistered{Email: "jane@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDispatcher(db).Publish(ctx, events.Wrap(event)); err != nil {
+		t.Fatal(err)
+	}
+	return db, sub
+}
+
+// deliverBatch runs DeliverBatch but gives up instead of hanging when the
+// endpoint deadlocks on the database.
+func deliverBatch(t *testing.T, w *Worker) int {
+	t.Helper()
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := w.DeliverBatch(context.Background())
+		done <- result{n, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Fatalf("DeliverBatch: %v", res.err)
+		}
+		return res.n
+	case <-time.After(5 * time.Second):
+		t.Fatal("DeliverBatch did not return")
+		return 0
+	}
+}
+
+func onlyDelivery(t *testing.T, db *memory.Database) models.WebhookDelivery {
+	t.Helper()
+	deliveries, err := db.WebhookDeliveries(context.Background(), database.WebhookDeliveryFilter{})
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("deliveries = %v, %v, want one", deliveries, err)
+	}
+	return deliveries[0]
+}
+
+func TestDeliverSignsAndRecords(t *testing.T) {
+	var db *memory.Database
+	var claimedByOther int
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if r.Header.Get(HeaderSignature) != Sign("whsec_test", timestamp, body) {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// with the database held this would deadlock
+		n, err := NewWorker(db, zap.NewNop(), testConfig).DeliverBatch(r.Context())
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		claimedByOther = n
+	}))
+	defer srv.Close()
+	db, sub := setup(t, srv.URL)
+
+	if n := deliverBatch(t, NewWorker(db, zap.NewNop(), testConfig)); n != 1 {
+		t.Fatalf("delivered %d, want 1", n)
+	}
+	if claimedByOther != 0 {
+		t.Errorf("a second worker took %d leased deliveries, want none", claimedByOther)
+	}
+	delivery := onlyDelivery(t, db)
+	if delivery.Status != models.DeliveryDelivered || delivery.LastStatusCode != http.StatusOK || delivery.Attempts != 1 {
+		t.Errorf("delivery = %+v, want delivered on the first attempt", delivery)
+	}
+	attempts, _ := db.WebhookAttempts(context.Background(), delivery.ID)
+	if len(attempts) != 1 || attempts[0].Error != "" {
+		t.Errorf("attempts = %+v, want one that succeeded", attempts)
+	}
+	if stored, _ := db.Webhook(context.Background(), sub.ID); stored.ConsecutiveFailures != 0 {
+		t.Errorf("consecutive failures = %d, want 0", stored.ConsecutiveFailures)
+	}
+}
+
+func TestDeliverFailureBacksOffAndDisables(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	db, sub := setup(t, srv.URL)
+	w := NewWorker(db, zap.NewNop(), testConfig)
+
+	deliverBatch(t, w)
+	delivery := onlyDelivery(t, db)
+	if delivery.Status != models.DeliveryPending || delivery.LastStatusCode != http.StatusBadGateway {
+		t.Fatalf("delivery = %+v, want it pending a retry", delivery)
+	}
+	if wait := time.Until(delivery.NextAttemptAt); wait <= 0 || wait > time.Second {
+		t.Errorf("next attempt in %v, want the first backoff", wait)
+	}
+
+	// the retry fails as well and reaches DisableAfter
+	if err := w.deliver(context.Background(), &delivery); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	stored, _ := db.Webhook(context.Background(), sub.ID)
+	if stored.Active || stored.ConsecutiveFailures != 2 {
+		t.Errorf("subscription = %+v, want it disabled after two failures", stored)
+	}
+
+	if err := w.deliver(context.Background(), &delivery); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if delivery := onlyDelivery(t, db); delivery.Status != models.DeliveryDead {
+		t.Errorf("status = %q, want dead once the subscription is disabled", delivery.Status)
+	}
+}
+
+func TestLeaseExpires(t *testing.T) {
+	db, _ := setup(t, "http://127.0.0.1:0")
+	if _, err := NewWorker(db, zap.NewNop(), testConfig).claim(context.Background()); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	// the worker died before sending
+	if due, _ := db.DueWebhookDeliveries(context.Background(), time.Now(), 10); len(due) != 0 {
+		t.Fatal("a leased delivery is due")
+	}
+	if due, _ := db.DueWebhookDeliveries(context.Background(), time.Now().Add(testConfig.Lease+time.Second), 10); len(due) != 1 {
+		t.Fatal("the delivery did not fall due after the lease")
+	}
+}